@@ -2,13 +2,13 @@ package gocode
 
 import (
 	"bytes"
+	"context"
 	"go/ast"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
-	"sync/atomic"
 
 	"github.com/charlievieth/buildutil"
 )
@@ -25,6 +25,11 @@ type candidate struct {
 	Type    string
 	Class   decl_class
 	Package string
+	Doc     string
+	Score   int
+	Snippet string
+	Edit    *TextEdit
+	Import  string // import path of the unimported package of the candidate
 }
 
 type out_buffers struct {
@@ -34,6 +39,12 @@ type out_buffers struct {
 	ctx               *auto_complete_context
 	tmpns             map[string]bool
 	ignorecase        bool
+	matcher           Matcher // ranks the candidates if set
+	snippets          bool    // see Config.Snippets
+
+	// type expected at the cursor, see deduce_expected_type
+	expected       ast.Expr
+	expected_scope *scope
 }
 
 func new_out_buffers(ctx *auto_complete_context) *out_buffers {
@@ -46,6 +57,8 @@ func new_out_buffers(ctx *auto_complete_context) *out_buffers {
 		candidates:        make([]candidate, 0, 64),
 		ctx:               ctx,
 		canonical_aliases: aliases,
+		matcher:           ctx.declcache.context.config.Matcher(),
+		snippets:          ctx.declcache.context.config.Snippets(),
 	}
 }
 
@@ -56,6 +69,9 @@ func (b *out_buffers) Len() int {
 func (b *out_buffers) Less(i, j int) bool {
 	x := b.candidates[i]
 	y := b.candidates[j]
+	if x.Score != y.Score {
+		return x.Score > y.Score
+	}
 	if x.Class == y.Class {
 		return x.Name < y.Name
 	}
@@ -67,15 +83,37 @@ func (b *out_buffers) Swap(i, j int) {
 }
 
 func (b *out_buffers) append_decl(p, name, pkg string, decl *decl, class decl_class) {
-	c1 := !g_config.ProposeBuiltins() && decl.scope == g_universe_scope && decl.name != "Error"
+	c1 := !b.ctx.declcache.context.config.ProposeBuiltins() && decl.scope == g_universe_scope && decl.name != "Error"
 	c2 := class != decl_invalid && decl.class != class
-	c3 := class == decl_invalid && !has_prefix(name, p, b.ignorecase)
+	c3 := false
 	c4 := !decl.matches()
 	c5 := !check_type_expr(decl.typ)
 
+	score := 0
+	if class == decl_invalid {
+		if b.matcher == nil {
+			c3 = !has_prefix(name, p, b.ignorecase)
+		} else {
+			var ok bool
+			score, ok = b.matcher.Match(name, p)
+			c3 = !ok
+		}
+	}
+
 	if c1 || c2 || c3 || c4 || c5 {
 		return
 	}
+	if b.matcher != nil {
+		score += b.ctx.locality(decl)
+	}
+	if t, s := value_type(decl); assignable(t, s, b.expected, b.expected_scope) {
+		score += 20
+	}
+
+	snippet := ""
+	if decl.class == decl_func && b.snippets {
+		snippet = func_snippet(name, decl.typ, decl.scope, b.canonical_aliases)
+	}
 
 	decl.pretty_print_type(b.tmpbuf, b.canonical_aliases)
 	b.candidates = append(b.candidates, candidate{
@@ -83,6 +121,9 @@ func (b *out_buffers) append_decl(p, name, pkg string, decl *decl, class decl_cl
 		Type:    b.tmpbuf.String(),
 		Class:   decl.class,
 		Package: pkg,
+		Doc:     decl.doc,
+		Score:   score,
+		Snippet: snippet,
 	})
 	b.tmpbuf.Reset()
 }
@@ -157,19 +198,34 @@ func new_auto_complete_context(pcache package_cache, declcache *decl_cache) *aut
 	return c
 }
 
-func (c *auto_complete_context) update_caches() {
+// cancelled is the panic value that aborts a completion when its context is
+// done, see check_context.
+type cancelled struct {
+	err error
+}
+
+// check_context aborts the completion if ctx is done.
+func check_context(ctx context.Context) {
+	if err := ctx.Err(); err != nil {
+		panic(cancelled{err})
+	}
+}
+
+func (c *auto_complete_context) update_caches(ctx context.Context) {
 	// temporary map for packages that we need to check for a cache expiration
 	// map is used as a set of unique items to prevent double checks
 	ps := make(map[string]*package_file_cache, len(c.current.packages))
 
 	// collect import information from all of the files
-	c.pcache.append_packages(ps, c.current.packages)
-	c.others = get_other_package_files(c.current.name, c.current.package_name, c.declcache)
+	c.pcache.append_packages(ps, c.current.packages, c.declcache.context)
+	c.others = get_other_package_files(ctx, c.current.name, c.current.package_name, c.declcache)
 	for _, other := range c.others {
-		c.pcache.append_packages(ps, other.packages)
+		c.pcache.append_packages(ps, other.packages, c.declcache.context)
 	}
 
-	update_packages(ps)
+	// the packages that fail are reported along with the candidates
+	c.current.errors = append(c.current.errors, update_packages(ctx, ps)...)
+	c.current.errors = append(c.current.errors, c.pcache.update_dependencies(ctx, ps, c.declcache.context)...)
 
 	// fix imports for all files
 	fixup_packages(c.current.filescope, c.current.packages, c.pcache)
@@ -210,6 +266,27 @@ func (c *auto_complete_context) make_decl_set(scope *scope) map[string]*decl {
 	return set
 }
 
+// locality returns the score bonus of declaration d, which is the highest
+// for local declarations, then for those of the package and then for the
+// imported packages. Members of types and packages have none.
+func (c *auto_complete_context) locality(d *decl) int {
+	for s := c.current.scope; s != nil; s = s.parent {
+		if s.entities[d.name] != d {
+			continue
+		}
+		switch s {
+		case g_universe_scope:
+			return 0
+		case c.current.filescope:
+			return 5
+		case c.pkg:
+			return 10
+		}
+		return 15
+	}
+	return 0
+}
+
 func (c *auto_complete_context) get_candidates_from_set(set map[string]*decl, partial string, class decl_class, b *out_buffers) {
 	for key, value := range set {
 		if value == nil {
@@ -268,6 +345,8 @@ func (c *auto_complete_context) get_candidates_from_decl(cc cursor_context, clas
 				continue
 			}
 		}
+		// members of packages loaded from source may only have a value
+		decl.infer_type()
 		b.append_decl(cc.partial, decl.name, c.decl_package_import_path(decl), decl, class)
 	}
 	// propose all children of an underlying struct/interface type
@@ -284,7 +363,7 @@ func (c *auto_complete_context) get_candidates_from_decl(cc cursor_context, clas
 }
 
 func (c *auto_complete_context) get_import_candidates(partial string, b *out_buffers) {
-	currentPackagePath, pkgdirs := gocodeDaemon.context.pkg_dirs()
+	currentPackagePath, pkgdirs := c.declcache.context.pkg_dirs()
 	resultSet := map[string]struct{}{}
 	for _, pkgdir := range pkgdirs {
 		// convert srcpath to pkgpath and get candidates
@@ -329,12 +408,9 @@ func get_import_candidates_dir(root, partial string, ignorecase bool, currentPac
 	}
 }
 
-// returns three slices of the same length containing:
-// 1. apropos names
-// 2. apropos types (pretty-printed)
-// 3. apropos classes
-// and length of the part that should be replaced (if any)
-func (c *auto_complete_context) apropos(file []byte, filename string, cursor int) ([]candidate, int) {
+// process parses the file being edited up to the cursor and updates the
+// caches of the other files of its package and of the imported packages.
+func (c *auto_complete_context) process(ctx context.Context, file []byte, filename string, cursor int) {
 	c.current.cursor = cursor
 	c.current.name = filename
 
@@ -357,7 +433,38 @@ func (c *auto_complete_context) apropos(file []byte, filename string, cursor int
 	// Updates cache of other files and packages. See the function for details of
 	// the process. At the end merges all the top-level declarations into the package
 	// block.
-	c.update_caches()
+	check_context(ctx)
+	c.update_caches(ctx)
+	c.current.fixup_receiver_type_params(c.others)
+	check_context(ctx)
+}
+
+// process_file is like process, but parses the file whole, as is, for the
+// lookups of declarations at any cursor, which set_cursor moves.
+func (c *auto_complete_context) process_file(ctx context.Context, file []byte, filename string) {
+	c.current.cursor = -1
+	c.current.name = filename
+	c.current.process_file(file)
+
+	check_context(ctx)
+	c.update_caches(ctx)
+	check_context(ctx)
+}
+
+// set_cursor moves the cursor of the file processed by process_file to
+// offset cursor.
+func (c *auto_complete_context) set_cursor(cursor int) {
+	c.current.set_cursor(cursor)
+	c.current.fixup_receiver_type_params(c.others)
+}
+
+// returns three slices of the same length containing:
+// 1. apropos names
+// 2. apropos types (pretty-printed)
+// 3. apropos classes
+// and length of the part that should be replaced (if any)
+func (c *auto_complete_context) apropos(ctx context.Context, file []byte, filename string, cursor int) ([]candidate, int) {
+	c.process(ctx, file, filename, cursor)
 
 	// And we're ready to Go. ;)
 
@@ -365,10 +472,13 @@ func (c *auto_complete_context) apropos(file []byte, filename string, cursor int
 
 	partial := 0
 	cc, ok := c.deduce_cursor_context(file, cursor)
+	cc.expected, cc.expected_scope = c.deduce_expected_type(file, cursor)
+	b.expected, b.expected_scope = cc.expected, cc.expected_scope
+	unimported := ""
 	if !ok {
 		var d *decl
-		if ident, ok := cc.expr.(*ast.Ident); ok && g_config.UnimportedPackages() {
-			d = resolveKnownPackageIdent(ident.Name, c.current.name, c.current.context)
+		if ident, ok := cc.expr.(*ast.Ident); ok && c.declcache.context.config.UnimportedPackages() {
+			d, unimported = c.resolveKnownPackageIdent(ident.Name)
 		}
 		if d == nil {
 			return nil, 0
@@ -406,6 +516,12 @@ func (c *auto_complete_context) apropos(file []byte, filename string, cursor int
 			b.ignorecase = true
 			c.get_candidates_from_set(set, cc.partial, class, b)
 		}
+		if cc.partial != "" && class == decl_invalid {
+			c.get_keyword_candidates(file, cursor, cc.partial, b)
+			if c.declcache.context.config.UnimportedPackages() {
+				c.get_unimported_candidates(set, cc.partial, b)
+			}
+		}
 	} else {
 		c.get_candidates_from_decl(cc, class, b)
 		if cc.partial != "" && len(b.candidates) == 0 {
@@ -413,6 +529,9 @@ func (c *auto_complete_context) apropos(file []byte, filename string, cursor int
 			b.ignorecase = true
 			c.get_candidates_from_decl(cc, class, b)
 		}
+		if cc.struct_field && class == decl_invalid && c.declcache.context.config.FillStructs() {
+			c.get_fill_struct_candidate(file, cursor, cc.partial, cc.decl, b)
+		}
 	}
 	partial = len(cc.partial)
 
@@ -420,34 +539,57 @@ func (c *auto_complete_context) apropos(file []byte, filename string, cursor int
 		return nil, 0
 	}
 
+	if unimported != "" {
+		for i := range b.candidates {
+			b.candidates[i].Import = unimported
+		}
+	}
+
 	sort.Sort(b)
 	return b.candidates, partial
 }
 
-func update_packages(ps map[string]*package_file_cache) {
+// update_packages updates the caches of packages ps concurrently and returns
+// the errors of those that failed, which are left out. Packages are no longer
+// updated once ctx is done.
+func update_packages(ctx context.Context, ps map[string]*package_file_cache) []*Error {
 	// initiate package cache update
-	var wg sync.WaitGroup
-	var failed int32
-
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []*Error
+	)
 	for _, p := range ps {
 		wg.Add(1)
 		go func(p *package_file_cache) {
+			defer wg.Done()
 			defer func() {
-				wg.Done()
 				if err := recover(); err != nil {
-					print_backtrace(err)
-					atomic.StoreInt32(&failed, 1)
+					if g_debug {
+						print_backtrace(err)
+					}
+					e := panic_error(err)
+					if e.Path == "" {
+						e.Path = p.import_name
+					}
+					mu.Lock()
+					errs = append(errs, e)
+					mu.Unlock()
 				}
 			}()
-			p.update_cache()
+			if ctx.Err() == nil {
+				p.update_cache()
+			}
 		}(p)
 	}
 
 	// wait for its completion
 	wg.Wait()
-	if atomic.LoadInt32(&failed) != 0 {
-		panic("One of the package cache updaters panicked")
-	}
+	check_context(ctx)
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Path < errs[j].Path
+	})
+	return errs
 }
 
 func collect_type_alias_methods(d *decl) map[string]*decl {
@@ -535,25 +677,36 @@ func fixup_packages(filescope *scope, pkgs []package_import, pcache package_cach
 	}
 }
 
-func get_other_package_files(filename, packageName string, declcache *decl_cache) []*decl_file_cache {
-	others := find_other_package_files(filename, packageName)
+// get_other_package_files returns the updated declaration caches of the
+// other files of the package. Files are no longer updated once ctx is done.
+func get_other_package_files(ctx context.Context, filename, packageName string, declcache *decl_cache) []*decl_file_cache {
+	others := find_other_package_files(filename, packageName, declcache.context)
 	ret := make([]*decl_file_cache, len(others))
 
 	var (
 		wg     sync.WaitGroup
 		mu     sync.Mutex
-		failed int32
+		failed *Error
 	)
 	for i, nm := range others {
 		wg.Add(1)
 		go func(i int, name string) {
+			defer wg.Done()
 			defer func() {
-				wg.Done()
 				if err := recover(); err != nil {
-					print_backtrace(err)
-					atomic.StoreInt32(&failed, 1)
+					if g_debug {
+						print_backtrace(err)
+					}
+					mu.Lock()
+					if failed == nil {
+						failed = panic_error(err)
+					}
+					mu.Unlock()
 				}
 			}()
+			if ctx.Err() != nil {
+				return
+			}
 
 			dc := declcache.get_and_update(name)
 			mu.Lock()
@@ -563,19 +716,20 @@ func get_other_package_files(filename, packageName string, declcache *decl_cache
 	}
 	wg.Wait()
 
-	if atomic.LoadInt32(&failed) != 0 {
-		panic("One of the decl cache updaters panicked")
+	check_context(ctx)
+	if failed != nil {
+		panic(failed)
 	}
 	return ret
 }
 
-func find_other_package_files(filename, package_name string) []string {
+func find_other_package_files(filename, package_name string, context *package_lookup_context) []string {
 	if filename == "" {
 		return nil
 	}
 
 	dir, file := filepath.Split(filename)
-	files_in_dir, err := readdir_gofiles_lstat(dir)
+	files_in_dir, err := context.dirs.readdir_gofiles_lstat(dir)
 	if err != nil {
 		// TODO (CEV): panic seems a little aggressive
 		// and will blow out the cache on restart
@@ -591,8 +745,15 @@ func find_other_package_files(filename, package_name string) []string {
 		if !has_go_ext(name) || name == file || stat.Mode()&non_regular != 0 {
 			continue
 		}
-		abspath := dir + string(filepath.Separator) + name
-		if file_package_name(abspath) == package_name {
+		abspath := filepath.Join(dir, name)
+		if file_package_name(abspath, context) == package_name {
+			out = append(out, abspath)
+		}
+	}
+
+	// unsaved files that do not exist on disk yet
+	for _, abspath := range overlay_go_files(dir, context) {
+		if filepath.Base(abspath) != file && file_package_name(abspath, context) == package_name {
 			out = append(out, abspath)
 		}
 	}
@@ -600,8 +761,26 @@ func find_other_package_files(filename, package_name string) []string {
 	return out
 }
 
-func file_package_name(filename string) string {
-	name, _ := buildutil.ReadPackageName(filename, nil)
+// overlay_go_files returns the Go files of directory dir of the overlay that
+// do not exist on disk, sorted by name.
+func overlay_go_files(dir string, context *package_lookup_context) []string {
+	var out []string
+	for abspath := range context.overlay {
+		d, name := filepath.Split(abspath)
+		if filepath.Clean(d) == filepath.Clean(dir) && has_go_ext(name) && !file_exists(abspath) {
+			out = append(out, abspath)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func file_package_name(filename string, context *package_lookup_context) string {
+	var src interface{}
+	if data, ok := context.overlay[filename]; ok {
+		src = data
+	}
+	name, _ := buildutil.ReadPackageName(filename, src)
 	return name
 }
 
@@ -656,5 +835,4 @@ func check_type_expr(e ast.Expr) bool {
 	default:
 		return true
 	}
-	return true
 }
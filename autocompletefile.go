@@ -2,6 +2,7 @@ package gocode
 
 import (
 	"bytes"
+	"errors"
 	"go/ast"
 	"go/parser"
 	"go/scanner"
@@ -47,6 +48,35 @@ type auto_complete_file struct {
 	cursor  int // for current file buffer only
 	fset    *token.FileSet
 	context *package_lookup_context
+
+	// the parsed contents, for the positions of the declarations
+	data       []byte      // contents with a semicolon at the cursor
+	semi       int         // offset of the semicolon
+	file       *token.File // file without the function at the cursor
+	block      *token.File // function at the cursor, if any
+	block_beg  int         // offset of the function at the cursor
+	block_size int
+
+	// the file if it is parsed whole, without a semicolon, for any
+	// cursor, see process_file
+	whole *ast.File
+
+	// statements the cursor is in and the offset of the '{' or the ':' that
+	// starts the list of statements the cursor is in, or -1
+	stmts      stmt_flags
+	stmt_start int
+
+	// results of the function at the cursor, if any, and their scope
+	results       *ast.FieldList
+	results_scope *scope
+
+	// method at the cursor, the receiver type of which is declared in
+	// another file, and the scope of its type parameters, see
+	// fixup_receiver_type_params
+	recv_method *ast.FuncDecl
+	recv_scope  *scope
+
+	errors []*Error // problems found processing the file
 }
 
 func new_auto_complete_file(name string, context *package_lookup_context) *auto_complete_file {
@@ -60,34 +90,57 @@ func new_auto_complete_file(name string, context *package_lookup_context) *auto_
 
 func (f *auto_complete_file) offset(p token.Pos) int {
 	const fixlen = len("package p;")
+	if tf := f.fset.File(p); tf != nil && tf == f.file && f.whole != nil {
+		return tf.Offset(p)
+	}
 	return f.fset.Position(p).Offset - fixlen
 }
 
+// parse_error returns the syntax errors err of the file without the
+// declaration at the cursor at their positions in the original file.
+func (f *auto_complete_file) parse_error(err error) error {
+	list, ok := err.(scanner.ErrorList)
+	if !ok {
+		return err
+	}
+	errs := make(scanner.ErrorList, len(list))
+	for i, e := range list {
+		offset := e.Pos.Offset
+		if offset >= f.block_beg {
+			offset += f.block_size
+		}
+		pos := f.data_position(offset)
+		pos.Filename = "" // Error.Path is the file name
+		errs[i] = &scanner.Error{Pos: pos, Msg: e.Msg}
+	}
+	return errs
+}
+
 // this one is used for current file buffer exclusively
 func (f *auto_complete_file) process_data(data []byte) {
 	cur, filedata, block := rip_off_decl(data, f.cursor)
-	file, err := parser.ParseFile(f.fset, "", filedata, parser.AllErrors)
+	f.data = data
+	f.semi = f.cursor
+	f.block_beg = f.cursor - cur
+	f.block_size = len(block)
+	f.block = nil
+	f.whole = nil
+	f.results, f.results_scope = nil, nil
+	f.recv_method, f.recv_scope = nil, nil
+	f.stmts, f.stmt_start = 0, -1
+
+	base := f.fset.Base()
+	file, err := parser.ParseFile(f.fset, f.name, filedata, parser.AllErrors|f.context.parse_mode())
+	f.file = f.fset.File(token.Pos(base))
 	if err != nil && g_debug {
 		log_parse_error("Error parsing input file (outer block)", err)
 	}
-	f.package_name = package_name(file)
-
-	f.decls = make(map[string]*decl)
-	f.packages = collect_package_imports(f.name, file.Decls, f.context)
-	f.filescope = new_scope(nil)
-	f.scope = f.filescope
-
-	for _, d := range file.Decls {
-		anonymify_ast(d, 0, f.filescope)
-	}
-
-	// process all top-level declarations
-	for _, decl := range file.Decls {
-		append_to_top_decls(f.decls, decl, f.scope)
-	}
+	f.process_top_decls(file, err)
 	if block != nil {
 		// process local function as top-level declaration
+		base := f.fset.Base()
 		decls, err := parse_decl_list(f.fset, block)
+		f.block = f.fset.File(token.Pos(base))
 		if err != nil && g_debug {
 			log_parse_error("Error parsing input file (inner block)", err)
 		}
@@ -109,16 +162,131 @@ func (f *auto_complete_file) process_data(data []byte) {
 
 }
 
+// process_file parses the whole file data, as is, for the lookups of
+// declarations at any cursor, which set_cursor moves. Unlike process_data,
+// which parses the function at the cursor apart, with a semicolon at the
+// cursor, it is meant for complete files.
+func (f *auto_complete_file) process_file(data []byte) {
+	f.data = data
+	f.semi = len(data) + 1 // none
+	f.block_beg, f.block_size = 0, 0
+	f.block = nil
+
+	base := f.fset.Base()
+	file, err := parser.ParseFile(f.fset, f.name, data, parser.AllErrors|f.context.parse_mode())
+	f.file = f.fset.File(token.Pos(base))
+	if err != nil && g_debug {
+		log_parse_error("Error parsing input file (whole)", err)
+	}
+	f.whole = file
+	f.process_top_decls(file, err)
+	f.set_cursor(-1)
+}
+
+// set_cursor moves the cursor of the file processed by process_file to
+// offset cursor and works out the local declarations there.
+func (f *auto_complete_file) set_cursor(cursor int) {
+	f.cursor = cursor
+	f.scope = f.filescope
+	f.results, f.results_scope = nil, nil
+	f.recv_method, f.recv_scope = nil, nil
+	f.stmts, f.stmt_start = 0, -1
+	if cursor == -1 {
+		return
+	}
+	for _, decl := range f.whole.Decls {
+		if f.offset(decl.Pos()) < cursor && cursor <= f.offset(decl.End()) {
+			f.process_decl_locals(decl)
+		}
+	}
+}
+
+// process_top_decls processes the top-level declarations of file, which
+// was parsed with error err.
+func (f *auto_complete_file) process_top_decls(file *ast.File, err error) {
+	f.package_name = package_name(file)
+	f.errors = nil
+	if err != nil {
+		f.errors = append(f.errors, &Error{Kind: ParseError, Path: f.name, Err: f.parse_error(err)})
+	}
+
+	f.decls = make(map[string]*decl)
+	f.packages = collect_package_imports(f.name, file.Decls, f.context)
+	for _, path := range unresolved_imports(file.Decls, f.packages) {
+		err := errors.New("cannot find package")
+		f.errors = append(f.errors, &Error{Kind: ImportError, Path: path, Err: err})
+	}
+	f.filescope = new_scope(nil)
+	f.filescope.positions = f.position
+	f.scope = f.filescope
+
+	for _, d := range file.Decls {
+		anonymify_ast(d, 0, f.filescope)
+	}
+
+	// process all top-level declarations
+	for _, decl := range file.Decls {
+		append_to_top_decls(f.decls, decl, f.scope)
+	}
+}
+
+// position returns the position p of a declaration of the file in the
+// contents given to process_data, less the semicolon inserted at the cursor.
+func (f *auto_complete_file) position(p token.Pos) token.Position {
+	const fixlen = len("package p;")
+	tf := f.fset.File(p)
+	if tf == nil {
+		return token.Position{}
+	}
+	offset := tf.Offset(p)
+	switch tf {
+	case f.block:
+		offset += f.block_beg - fixlen
+	case f.file:
+		if offset >= f.block_beg {
+			offset += f.block_size
+		}
+	default:
+		// the declaration is from an earlier version of the file
+		return token.Position{}
+	}
+	return f.data_position(offset)
+}
+
+// data_position returns the position of byte offset in the contents given to
+// process_data, less the semicolon inserted at the cursor.
+func (f *auto_complete_file) data_position(offset int) token.Position {
+	pos := token.Position{Filename: f.name, Offset: offset, Line: 1, Column: 1}
+	if offset > f.semi {
+		pos.Offset--
+	}
+	for i := 0; i < offset && i < len(f.data); i++ {
+		switch {
+		case i == f.semi:
+		case f.data[i] == '\n':
+			pos.Line++
+			pos.Column = 1
+		default:
+			pos.Column++
+		}
+	}
+	return pos
+}
+
 func (f *auto_complete_file) process_decl_locals(decl ast.Decl) {
 	switch t := decl.(type) {
 	case *ast.FuncDecl:
 		if f.cursor_in(t.Body) {
+			f.process_type_params(t)
+
 			s := f.scope
 			f.scope = new_scope(f.scope)
 
 			f.process_field_list(t.Recv, s)
 			f.process_field_list(t.Type.Params, s)
 			f.process_field_list(t.Type.Results, s)
+			f.results, f.results_scope = t.Type.Results, f.scope
+			f.stmts = stmt_in_func
 			f.process_block_stmt(t.Body)
 		}
 	default:
@@ -145,6 +313,9 @@ func (f *auto_complete_file) process_decl(decl ast.Decl) {
 			if d == nil {
 				return
 			}
+			d.pos = name.Pos()
+			d.tparams = ast_decl_type_params(data.decl)
+			d.doc = ast_decl_doc(data.decl)
 
 			f.scope.add_named_decl(d)
 		}
@@ -154,6 +325,7 @@ func (f *auto_complete_file) process_decl(decl ast.Decl) {
 func (f *auto_complete_file) process_block_stmt(block *ast.BlockStmt) {
 	if block != nil && f.cursor_in(block) {
 		f.scope, _ = advance_scope(f.scope)
+		f.enter_stmt_list(block.Lbrace, f.stmts&^(stmt_in_switch|stmt_in_case))
 
 		for _, stmt := range block.List {
 			f.process_stmt(stmt)
@@ -177,6 +349,8 @@ func (v *func_lit_visitor) Visit(node ast.Node) ast.Visitor {
 
 		v.ctx.process_field_list(t.Type.Params, s)
 		v.ctx.process_field_list(t.Type.Results, s)
+		v.ctx.results, v.ctx.results_scope = t.Type.Results, v.ctx.scope
+		v.ctx.stmts = stmt_in_func
 		v.ctx.process_block_stmt(t.Body)
 
 		return nil
@@ -210,6 +384,7 @@ func (f *auto_complete_file) process_stmt(stmt ast.Stmt) {
 			f.scope, _ = advance_scope(f.scope)
 
 			f.process_stmt(t.Init)
+			f.stmts |= stmt_in_loop | stmt_in_breakable
 			f.process_block_stmt(t.Body)
 		}
 	case *ast.SwitchStmt:
@@ -229,6 +404,7 @@ func (f *auto_complete_file) process_select_stmt(a *ast.SelectStmt) {
 	}
 	var prevscope *scope
 	f.scope, prevscope = advance_scope(f.scope)
+	f.enter_stmt_list(a.Body.Lbrace, f.stmts|stmt_in_switch|stmt_in_breakable)
 
 	var last_cursor_after *ast.CommClause
 	for _, s := range a.Body.List {
@@ -238,12 +414,14 @@ func (f *auto_complete_file) process_select_stmt(a *ast.SelectStmt) {
 	}
 
 	if last_cursor_after != nil {
+		f.enter_stmt_list(last_cursor_after.Colon, f.stmts)
 		if last_cursor_after.Comm != nil {
 			//if lastCursorAfter.Lhs != nil && lastCursorAfter.Tok == token.DEFINE {
 			if astmt, ok := last_cursor_after.Comm.(*ast.AssignStmt); ok && astmt.Tok == token.DEFINE {
 				vname := astmt.Lhs[0].(*ast.Ident).Name
 				v := new_decl_var(vname, nil, astmt.Rhs[0], -1, prevscope)
 				if v != nil {
+					v.pos = astmt.Lhs[0].Pos()
 					f.scope.add_named_decl(v)
 				}
 			}
@@ -270,9 +448,13 @@ func (f *auto_complete_file) process_type_switch_stmt(a *ast.TypeSwitchStmt) {
 		if lhs != nil && len(lhs) == 1 {
 			tvname := lhs[0].(*ast.Ident).Name
 			tv = new_decl_var(tvname, nil, rhs[0], -1, prevscope)
+			if tv != nil {
+				tv.pos = lhs[0].Pos()
+			}
 		}
 	}
 
+	f.enter_stmt_list(a.Body.Lbrace, f.stmts|stmt_in_switch|stmt_in_breakable)
 	var last_cursor_after *ast.CaseClause
 	for _, s := range a.Body.List {
 		if cc := s.(*ast.CaseClause); f.cursor > f.offset(cc.Colon) {
@@ -281,6 +463,7 @@ func (f *auto_complete_file) process_type_switch_stmt(a *ast.TypeSwitchStmt) {
 	}
 
 	if last_cursor_after != nil {
+		f.enter_stmt_list(last_cursor_after.Colon, f.stmts)
 		if tv != nil {
 			if last_cursor_after.List != nil && len(last_cursor_after.List) == 1 {
 				tv.typ = last_cursor_after.List[0]
@@ -301,6 +484,7 @@ func (f *auto_complete_file) process_switch_stmt(a *ast.SwitchStmt) {
 	f.scope, _ = advance_scope(f.scope)
 
 	f.process_stmt(a.Init)
+	f.enter_stmt_list(a.Body.Lbrace, f.stmts|stmt_in_switch|stmt_in_breakable)
 	var last_cursor_after *ast.CaseClause
 	for _, s := range a.Body.List {
 		if cc := s.(*ast.CaseClause); f.cursor > f.offset(cc.Colon) {
@@ -308,6 +492,12 @@ func (f *auto_complete_file) process_switch_stmt(a *ast.SwitchStmt) {
 		}
 	}
 	if last_cursor_after != nil {
+		stmts := f.stmts
+		if last_cursor_after != a.Body.List[len(a.Body.List)-1] {
+			// cannot fall through the last case
+			stmts |= stmt_in_case
+		}
+		f.enter_stmt_list(last_cursor_after.Colon, stmts)
 		for _, s := range last_cursor_after.Body {
 			f.process_stmt(s)
 		}
@@ -325,6 +515,7 @@ func (f *auto_complete_file) process_range_stmt(a *ast.RangeStmt) {
 		if t, ok := a.Key.(*ast.Ident); ok {
 			d := new_decl_var(t.Name, nil, a.X, 0, prevscope)
 			if d != nil {
+				d.pos = t.Pos()
 				d.flags |= decl_rangevar
 				f.scope.add_named_decl(d)
 			}
@@ -334,6 +525,7 @@ func (f *auto_complete_file) process_range_stmt(a *ast.RangeStmt) {
 			if t, ok := a.Value.(*ast.Ident); ok {
 				d := new_decl_var(t.Name, nil, a.X, 1, prevscope)
 				if d != nil {
+					d.pos = t.Pos()
 					d.flags |= decl_rangevar
 					f.scope.add_named_decl(d)
 				}
@@ -341,6 +533,7 @@ func (f *auto_complete_file) process_range_stmt(a *ast.RangeStmt) {
 		}
 	}
 
+	f.stmts |= stmt_in_loop | stmt_in_breakable
 	f.process_block_stmt(a.Body)
 }
 
@@ -369,11 +562,89 @@ func (f *auto_complete_file) process_assign_stmt(a *ast.AssignStmt) {
 		if d == nil {
 			continue
 		}
+		d.pos = name.Pos()
 
 		f.scope.add_named_decl(d)
 	}
 }
 
+// process_type_params declares the type parameters of a generic function or
+// of the receiver of a method of a generic type in a new scope. A type
+// parameter is an alias of its constraint, so that selecting on a value of
+// type parameter type proposes the methods of the constraint.
+func (f *auto_complete_file) process_type_params(decl *ast.FuncDecl) {
+	var fields []*ast.Field
+	recv := false
+	if decl.Recv != nil {
+		names := receiver_type_params(decl.Recv)
+		if len(names) != 0 {
+			// the constraints of a type declared in another file are
+			// known once the other files are processed
+			var tparams *ast.FieldList
+			if d, ok := f.decls[method_of(decl)]; ok && d.tparams != nil {
+				tparams = d.tparams
+			} else {
+				recv = true
+			}
+			fields = append(fields, receiver_type_param_fields(names, tparams)...)
+		}
+	}
+	if decl.Type.TypeParams != nil {
+		fields = append(fields, decl.Type.TypeParams.List...)
+	}
+	if len(fields) == 0 {
+		return
+	}
+
+	f.scope = new_scope(f.scope)
+	if recv {
+		f.recv_method, f.recv_scope = decl, f.scope
+	}
+	declare_type_params(f.scope, fields)
+}
+
+// declare_type_params declares the type parameters fields in scope s, those
+// without a constraint as aliases of any.
+func declare_type_params(s *scope, fields []*ast.Field) {
+	for _, field := range fields {
+		typ := field.Type
+		if typ == nil {
+			typ = ast.NewIdent("any")
+		}
+		for _, name := range field.Names {
+			d := new_decl_full(name.Name, decl_type, decl_alias, typ, nil, -1, s)
+			if d != nil {
+				d.pos = name.Pos()
+				s.add_named_decl(d)
+			}
+		}
+	}
+}
+
+// fixup_receiver_type_params declares the constraints of the type parameters
+// of the receiver of the method at the cursor, the type of which is declared
+// in one of the other files of the package others.
+func (f *auto_complete_file) fixup_receiver_type_params(others []*decl_file_cache) {
+	if f.recv_method == nil {
+		return
+	}
+	name := method_of(f.recv_method)
+	for _, other := range others {
+		d, ok := other.decls[name]
+		if !ok || d.tparams == nil {
+			continue
+		}
+		// the constraints make sense in the file of the type
+		s := new_scope(other.filescope)
+		names := receiver_type_params(f.recv_method.Recv)
+		declare_type_params(s, receiver_type_param_fields(names, d.tparams))
+		for name, d := range s.entities {
+			f.recv_scope.entities[name] = d
+		}
+		return
+	}
+}
+
 func (f *auto_complete_file) process_field_list(field_list *ast.FieldList, s *scope) {
 	if field_list != nil {
 		decls := ast_field_list_to_decls(field_list, decl_var, 0, s, false)
@@ -408,11 +679,24 @@ func (f *auto_complete_file) cursor_in_for_head(s *ast.ForStmt) bool {
 	return false
 }
 
+// enter_stmt_list records that the cursor is in the list of statements
+// started by the '{' or the ':' at pos, which are in statements stmts.
+func (f *auto_complete_file) enter_stmt_list(pos token.Pos, stmts stmt_flags) {
+	f.stmts = stmts
+	f.stmt_start = f.block_beg + f.offset(pos)
+}
+
 func (f *auto_complete_file) cursor_in(block *ast.BlockStmt) bool {
 	if f.cursor == -1 || block == nil {
 		return false
 	}
 
+	// The block is not closed if the statement at the cursor could not be
+	// parsed, like go# or defer#, in which case it ends at the cursor.
+	if !block.Rbrace.IsValid() {
+		return f.cursor > f.offset(block.Lbrace)
+	}
+
 	// The logic for block.End() changed in go1.14.
 	if f.cursor > f.offset(block.Lbrace) && f.cursor <= f.offset(block.End()-1) {
 		return true
@@ -0,0 +1,58 @@
+// Command gocode-lsp is a Language Server Protocol server, which provides
+// the completions of the gocode package over stdin and stdout.
+//
+// The server implements the initialize, shutdown and exit messages, the
+// textDocument/didOpen, didChange and didClose notifications and the
+// textDocument/completion request. Open documents are used instead of
+// the files on disk.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/build"
+	"log"
+	"os"
+	"runtime"
+
+	"github.com/charlievieth/gocode"
+)
+
+func main() {
+	conf := gocode.Config{
+		GOROOT: runtime.GOROOT(),
+		GOPATH: build.Default.GOPATH,
+	}
+	flag.BoolVar(&conf.Builtins, "builtins", false, "propose builtin functions")
+	flag.BoolVar(&conf.Source, "source", false, "load imported packages from source")
+	flag.BoolVar(&conf.AutoBuild, "autobuild", false, "rebuild stale packages")
+	flag.BoolVar(&conf.Docs, "docs", false, "add doc comments to the completions")
+	flag.BoolVar(&conf.UnimportedPackages, "unimported", false, "complete packages that are not imported")
+	flag.BoolVar(&conf.FillStructs, "fillstructs", false, "propose to fill in the fields of struct literals")
+	flag.BoolVar(&conf.Snippets, "snippets", false, "complete the arguments of function calls with snippets")
+	matcher := flag.String("matcher", "", "match and rank the completions: prefix, camel or fuzzy")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	switch *matcher {
+	case "":
+	case "prefix":
+		conf.Matcher = gocode.PrefixMatcher
+	case "camel":
+		conf.Matcher = gocode.CamelCaseMatcher
+	case "fuzzy":
+		conf.Matcher = gocode.FuzzyMatcher
+	default:
+		fmt.Fprintf(os.Stderr, "%s: unknown matcher %q\n", os.Args[0], *matcher)
+		os.Exit(2)
+	}
+
+	log.SetPrefix("gocode-lsp: ")
+	log.SetFlags(0)
+	if err := newServer(os.Stdin, os.Stdout, conf).run(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import "encoding/json"
+
+//-------------------------------------------------------------------------
+// JSON-RPC 2.0 and the subset of the Language Server Protocol the server
+// implements.
+//-------------------------------------------------------------------------
+
+// JSON-RPC and LSP error codes.
+const (
+	codeParseError       = -32700
+	codeInvalidRequest   = -32600
+	codeMethodNotFound   = -32601
+	codeInvalidParams    = -32602
+	codeInternalError    = -32603
+	codeRequestCancelled = -32800
+)
+
+// message is a request or a notification, which has no ID, sent by the
+// client.
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  interface{}      `json:"result"`
+	Error   *responseError   `json:"error,omitempty"`
+}
+
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *responseError) Error() string { return e.Message }
+
+type cancelParams struct {
+	ID json.RawMessage `json:"id"`
+}
+
+type initializeResult struct {
+	Capabilities serverCapabilities `json:"capabilities"`
+	ServerInfo   serverInfo         `json:"serverInfo"`
+}
+
+type serverInfo struct {
+	Name string `json:"name"`
+}
+
+type serverCapabilities struct {
+	TextDocumentSync   int               `json:"textDocumentSync"`
+	CompletionProvider completionOptions `json:"completionProvider"`
+}
+
+// textDocumentSyncFull means that the client sends the whole document on
+// every change.
+const textDocumentSyncFull = 1
+
+type completionOptions struct {
+	TriggerCharacters []string `json:"triggerCharacters"`
+}
+
+type textDocumentItem struct {
+	URI     string `json:"uri"`
+	Version int    `json:"version"`
+	Text    string `json:"text"`
+}
+
+type textDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type didOpenParams struct {
+	TextDocument textDocumentItem `json:"textDocument"`
+}
+
+type didChangeParams struct {
+	TextDocument   textDocumentIdentifier `json:"textDocument"`
+	ContentChanges []contentChange        `json:"contentChanges"`
+}
+
+type contentChange struct {
+	Text string `json:"text"`
+}
+
+type didCloseParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+}
+
+// position is a zero based line and a character offset in UTF-16 code
+// units.
+type position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type rangeType struct {
+	Start position `json:"start"`
+	End   position `json:"end"`
+}
+
+type textDocumentPositionParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+	Position     position               `json:"position"`
+}
+
+type completionList struct {
+	IsIncomplete bool             `json:"isIncomplete"`
+	Items        []completionItem `json:"items"`
+}
+
+type completionItem struct {
+	Label         string   `json:"label"`
+	Kind          int      `json:"kind,omitempty"`
+	Detail        string   `json:"detail,omitempty"`
+	Documentation string   `json:"documentation,omitempty"`
+	SortText      string   `json:"sortText,omitempty"`
+	TextEdit      textEdit `json:"textEdit"`
+
+	InsertTextFormat    int        `json:"insertTextFormat,omitempty"`
+	AdditionalTextEdits []textEdit `json:"additionalTextEdits,omitempty"`
+}
+
+type textEdit struct {
+	Range   rangeType `json:"range"`
+	NewText string    `json:"newText"`
+}
+
+// InsertTextFormat values.
+const (
+	formatPlainText = 1
+	formatSnippet   = 2
+)
+
+// CompletionItemKind values.
+const (
+	kindFunction  = 3
+	kindVariable  = 6
+	kindClass     = 7
+	kindInterface = 8
+	kindModule    = 9
+	kindKeyword   = 14
+	kindSnippet   = 15
+	kindConstant  = 21
+	kindStruct    = 22
+)
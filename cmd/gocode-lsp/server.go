@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/charlievieth/gocode"
+)
+
+// server is a language server that reads requests from in and writes the
+// responses to out. Completion requests are handled concurrently, so that
+// they can be cancelled, while the documents are updated in order.
+type server struct {
+	in   *textproto.Reader
+	out  io.Writer
+	outm sync.Mutex // serializes writes to out
+
+	conf   gocode.Config
+	engine *gocode.Engine
+	sem    chan struct{} // held while the configuration of engine is in use
+
+	mu      sync.Mutex
+	docs    map[string][]byte             // open documents by file name
+	pending map[string]context.CancelFunc // running requests by ID
+	wg      sync.WaitGroup
+}
+
+func newServer(in io.Reader, out io.Writer, conf gocode.Config) *server {
+	return &server{
+		in:      textproto.NewReader(bufio.NewReader(in)),
+		out:     out,
+		conf:    conf,
+		engine:  gocode.NewEngine(&conf),
+		sem:     make(chan struct{}, 1),
+		docs:    make(map[string][]byte),
+		pending: make(map[string]context.CancelFunc),
+	}
+}
+
+// run serves requests until the client sends the exit notification or in
+// is closed.
+func (s *server) run() error {
+	defer s.engine.Close()
+	defer s.wg.Wait()
+	for {
+		msg, err := s.read()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		s.handle(msg)
+	}
+}
+
+// read reads the next message, which is preceded by a header with its
+// Content-Length.
+func (s *server) read() (*message, error) {
+	header, err := s.in.ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	n, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid Content-Length: %q", header.Get("Content-Length"))
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(s.in.R, data); err != nil {
+		return nil, err
+	}
+	msg := new(message)
+	if err := json.Unmarshal(data, msg); err != nil {
+		s.reply(nil, nil, &responseError{codeParseError, err.Error()})
+		return &message{}, nil
+	}
+	return msg, nil
+}
+
+func (s *server) write(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.outm.Lock()
+	defer s.outm.Unlock()
+	if _, err := fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n", len(data)); err != nil {
+		return err
+	}
+	_, err = s.out.Write(data)
+	return err
+}
+
+func (s *server) reply(id *json.RawMessage, result interface{}, err error) {
+	resp := response{JSONRPC: "2.0", ID: id, Result: result}
+	if err != nil {
+		var rerr *responseError
+		if !errors.As(err, &rerr) {
+			rerr = &responseError{codeInternalError, err.Error()}
+		}
+		resp.Result = nil
+		resp.Error = rerr
+	}
+	s.write(resp)
+}
+
+func (s *server) handle(msg *message) {
+	switch msg.Method {
+	case "":
+		if msg.ID != nil {
+			s.reply(msg.ID, nil, &responseError{codeInvalidRequest, "missing method"})
+		}
+	case "initialize":
+		s.reply(msg.ID, initializeResult{
+			Capabilities: serverCapabilities{
+				TextDocumentSync: textDocumentSyncFull,
+				CompletionProvider: completionOptions{
+					TriggerCharacters: []string{"."},
+				},
+			},
+			ServerInfo: serverInfo{Name: "gocode-lsp"},
+		}, nil)
+	case "initialized":
+	case "shutdown":
+		s.wg.Wait()
+		s.reply(msg.ID, nil, nil)
+	case "$/cancelRequest":
+		var p cancelParams
+		if json.Unmarshal(msg.Params, &p) == nil {
+			s.mu.Lock()
+			if cancel := s.pending[string(p.ID)]; cancel != nil {
+				cancel()
+			}
+			s.mu.Unlock()
+		}
+	case "textDocument/didOpen":
+		var p didOpenParams
+		if err := json.Unmarshal(msg.Params, &p); err == nil {
+			s.setDocument(p.TextDocument.URI, []byte(p.TextDocument.Text))
+		}
+	case "textDocument/didChange":
+		var p didChangeParams
+		if err := json.Unmarshal(msg.Params, &p); err == nil && len(p.ContentChanges) != 0 {
+			text := p.ContentChanges[len(p.ContentChanges)-1].Text
+			s.setDocument(p.TextDocument.URI, []byte(text))
+		}
+	case "textDocument/didClose":
+		var p didCloseParams
+		if err := json.Unmarshal(msg.Params, &p); err == nil {
+			s.setDocument(p.TextDocument.URI, nil)
+		}
+	case "textDocument/completion":
+		var p textDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			s.reply(msg.ID, nil, &responseError{codeInvalidParams, err.Error()})
+			return
+		}
+		s.completion(msg.ID, p)
+	default:
+		// notifications that are not understood are ignored
+		if msg.ID != nil && !strings.HasPrefix(msg.Method, "$/") {
+			s.reply(msg.ID, nil, &responseError{codeMethodNotFound, "method not found: " + msg.Method})
+		}
+	}
+}
+
+// setDocument sets the contents of the open document uri, or forgets it if
+// data is nil.
+func (s *server) setDocument(uri string, data []byte) {
+	name, err := uriToFilename(uri)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	if data == nil {
+		delete(s.docs, name)
+	} else {
+		s.docs[name] = data
+	}
+	s.mu.Unlock()
+}
+
+// completion starts completing the document at the position of p. The open
+// documents are passed to the engine as the overlay as of the request.
+func (s *server) completion(id *json.RawMessage, p textDocumentPositionParams) {
+	if id == nil {
+		// a notification, which can't be answered
+		return
+	}
+	name, err := uriToFilename(p.TextDocument.URI)
+	if err != nil {
+		s.reply(id, nil, &responseError{codeInvalidParams, err.Error()})
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	key := string(*id)
+
+	s.mu.Lock()
+	data, ok := s.docs[name]
+	overlay := make(map[string][]byte, len(s.docs))
+	for k, v := range s.docs {
+		overlay[k] = v
+	}
+	s.pending[key] = cancel
+	s.mu.Unlock()
+
+	if !ok {
+		cancel()
+		s.reply(id, nil, &responseError{codeInvalidParams, "document is not open: " + p.TextDocument.URI})
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer func() {
+			s.mu.Lock()
+			delete(s.pending, key)
+			s.mu.Unlock()
+			cancel()
+		}()
+
+		list, err := s.complete(ctx, name, data, overlay, p.Position)
+		if err != nil && ctx.Err() != nil {
+			err = &responseError{codeRequestCancelled, err.Error()}
+		}
+		s.reply(id, list, err)
+	}()
+}
+
+// complete returns the completion items at pos of file name.
+func (s *server) complete(ctx context.Context, name string, data []byte, overlay map[string][]byte, pos position) (*completionList, error) {
+	select {
+	case s.sem <- struct{}{}:
+		defer func() { <-s.sem }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	conf := s.conf
+	conf.Overlay = overlay
+	s.engine.SetConfig(&conf)
+
+	req := gocode.Request{
+		Filename: name,
+		Data:     data,
+		Cursor:   offset(data, pos),
+	}
+	res, err := s.engine.CompleteContext(ctx, req)
+	if err != nil {
+		var gerr *gocode.Error
+		if ctx.Err() != nil || !errors.As(err, &gerr) || gerr.Kind == gocode.InternalError {
+			return nil, err
+		}
+		// the file is being edited, problems are expected
+	}
+
+	list := &completionList{Items: make([]completionItem, len(res.Candidates))}
+	rng := rangeType{
+		Start: position{Line: res.Start.Line - 1, Character: res.Start.UTF16},
+		End:   position{Line: res.End.Line - 1, Character: res.End.UTF16},
+	}
+	for i, c := range res.Candidates {
+		list.Items[i] = completionItem{
+			Label:         c.Name,
+			Kind:          completionKind(c),
+			Detail:        c.Type,
+			Documentation: c.Doc,
+			TextEdit:      textEdit{Range: rng, NewText: c.Name},
+		}
+		if c.Snippet != "" {
+			list.Items[i].TextEdit.NewText = c.Snippet
+			list.Items[i].InsertTextFormat = formatSnippet
+		}
+		if c.Edit != nil {
+			list.Items[i].TextEdit = toTextEdit(*c.Edit)
+		}
+		for _, e := range c.AdditionalEdits {
+			list.Items[i].AdditionalTextEdits = append(list.Items[i].AdditionalTextEdits, toTextEdit(e))
+		}
+		if s.conf.Matcher != nil {
+			// keep the ranking of the candidates
+			list.Items[i].SortText = fmt.Sprintf("%05d", i)
+		}
+	}
+	return list, nil
+}
+
+// toTextEdit converts edit e to an LSP text edit.
+func toTextEdit(e gocode.TextEdit) textEdit {
+	return textEdit{
+		Range: rangeType{
+			Start: position{Line: e.Start.Line - 1, Character: e.Start.UTF16},
+			End:   position{Line: e.End.Line - 1, Character: e.End.UTF16},
+		},
+		NewText: e.Text,
+	}
+}
+
+// completionKind returns the CompletionItemKind of candidate c.
+func completionKind(c gocode.Candidate) int {
+	switch c.Class {
+	case "const":
+		return kindConstant
+	case "func":
+		return kindFunction
+	case "import", "package":
+		return kindModule
+	case "keyword":
+		return kindKeyword
+	case "fields":
+		return kindSnippet
+	case "type":
+		switch {
+		case strings.HasPrefix(c.Type, "struct"):
+			return kindStruct
+		case strings.HasPrefix(c.Type, "interface"):
+			return kindInterface
+		}
+		return kindClass
+	case "var":
+		return kindVariable
+	}
+	return 0
+}
+
+// offset returns the byte offset of pos in data.
+func offset(data []byte, pos position) int {
+	off := 0
+	for line := 0; line < pos.Line; line++ {
+		i := bytes.IndexByte(data[off:], '\n')
+		if i == -1 {
+			return len(data)
+		}
+		off += i + 1
+	}
+	for n := 0; n < pos.Character && off < len(data) && data[off] != '\n'; {
+		r, size := utf8.DecodeRune(data[off:])
+		if r >= 0x10000 {
+			n += 2 // surrogate pair
+		} else {
+			n++
+		}
+		off += size
+	}
+	return off
+}
+
+func uriToFilename(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "file" {
+		return "", fmt.Errorf("unsupported URI scheme: %q", uri)
+	}
+	path := u.Path
+	if len(path) >= 3 && path[0] == '/' && path[2] == ':' {
+		path = path[1:] // Windows drive letter
+	}
+	return filepath.Clean(filepath.FromSlash(path)), nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/charlievieth/gocode"
+)
+
+// client is a fake language client connected to a server through pipes.
+type client struct {
+	t   *testing.T
+	in  *textproto.Reader
+	out io.WriteCloser
+	id  int
+}
+
+func (c *client) send(method string, id int, params interface{}) {
+	msg := map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}
+	if id != 0 {
+		msg["id"] = id
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := fmt.Fprintf(c.out, "Content-Length: %d\r\n\r\n%s", len(data), data); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *client) notify(method string, params interface{}) {
+	c.send(method, 0, params)
+}
+
+// call sends a request and decodes the result of its response into result.
+func (c *client) call(method string, params, result interface{}) {
+	c.id++
+	c.send(method, c.id, params)
+
+	header, err := c.in.ReadMIMEHeader()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	n, _ := strconv.Atoi(header.Get("Content-Length"))
+	data := make([]byte, n)
+	if _, err := io.ReadFull(c.in.R, data); err != nil {
+		c.t.Fatal(err)
+	}
+	var resp struct {
+		ID     int             `json:"id"`
+		Result json.RawMessage `json:"result"`
+		Error  *responseError  `json:"error"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		c.t.Fatal(err)
+	}
+	if resp.ID != c.id || resp.Error != nil {
+		c.t.Fatalf("%s: got response %s", method, data)
+	}
+	if result != nil {
+		if err := json.Unmarshal(resp.Result, result); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+}
+
+func TestServer(t *testing.T) {
+	sr, cw := io.Pipe()
+	cr, sw := io.Pipe()
+	s := newServer(sr, sw, gocode.Config{GOROOT: runtime.GOROOT()})
+	done := make(chan error, 1)
+	go func() { done <- s.run() }()
+	c := &client{t: t, in: textproto.NewReader(bufio.NewReader(cr)), out: cw}
+
+	var init initializeResult
+	c.call("initialize", map[string]interface{}{}, &init)
+	if init.Capabilities.TextDocumentSync != textDocumentSyncFull {
+		t.Errorf("got capabilities %+v", init.Capabilities)
+	}
+	c.notify("initialized", map[string]interface{}{})
+
+	uri := "file://" + filepath.ToSlash(filepath.Join(t.TempDir(), "main.go"))
+	c.notify("textDocument/didOpen", didOpenParams{
+		TextDocument: textDocumentItem{URI: uri, Version: 1, Text: "package main\n"},
+	})
+	c.notify("textDocument/didChange", didChangeParams{
+		TextDocument: textDocumentIdentifier{URI: uri},
+		ContentChanges: []contentChange{
+			{Text: "package main\n\nimport \"strings\"\n\nfunc main() {\n\tvar _ = \"é\" + strings.Builde\n}\n"},
+		},
+	})
+
+	// completions without an id are dropped, the response read next is
+	// that of the request
+	c.notify("textDocument/completion", textDocumentPositionParams{
+		TextDocument: textDocumentIdentifier{URI: uri},
+		Position:     position{Line: 5, Character: 29},
+	})
+
+	var list completionList
+	c.call("textDocument/completion", textDocumentPositionParams{
+		TextDocument: textDocumentIdentifier{URI: uri},
+		Position:     position{Line: 5, Character: 29},
+	}, &list)
+	want := completionItem{
+		Label:  "Builder",
+		Kind:   kindStruct,
+		Detail: "struct",
+		TextEdit: textEdit{
+			Range:   rangeType{Start: position{5, 23}, End: position{5, 29}},
+			NewText: "Builder",
+		},
+	}
+	if len(list.Items) != 1 || !reflect.DeepEqual(list.Items[0], want) {
+		t.Errorf("got completions %+v want %+v", list.Items, want)
+	}
+
+	c.notify("textDocument/didClose", didCloseParams{TextDocument: textDocumentIdentifier{URI: uri}})
+	c.call("shutdown", nil, nil)
+	c.notify("exit", nil)
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+	if len(s.docs) != 0 {
+		t.Errorf("got open documents %v after didClose", s.docs)
+	}
+}
+
+func TestOffset(t *testing.T) {
+	data := []byte("a\n\"😀é\"x\n")
+	tests := []struct {
+		pos position
+		off int
+	}{
+		{position{0, 0}, 0},
+		{position{1, 0}, 2},
+		{position{1, 3}, 7},
+		{position{1, 5}, 10},
+		{position{1, 99}, 11},
+		{position{9, 0}, len(data)},
+	}
+	for _, x := range tests {
+		if off := offset(data, x.pos); off != x.off {
+			t.Errorf("offset(%+v): got %d want %d", x.pos, off, x.off)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"net/rpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+func do_client() int {
+	if flag.NArg() == 0 {
+		show_usage()
+		return 2
+	}
+	cmd := flag.Arg(0)
+
+	client, err := connect(cmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gocode: %v\n", err)
+		return 1
+	}
+	if client == nil {
+		return 0 // close without a daemon
+	}
+	defer client.Close()
+
+	switch cmd {
+	case "autocomplete":
+		err = cmd_auto_complete(client, os.Stdout)
+	case "close":
+		err = client.Call("Server.Close", 0, new(int))
+	case "status":
+		err = cmd_print(client, "Server.Status", 0)
+	case "drop-cache":
+		err = client.Call("Server.DropCache", 0, new(int))
+	case "set":
+		args := flag.Args()[1:]
+		if len(args) > 2 {
+			args = args[:2]
+		}
+		var a SetArgs
+		switch len(args) {
+		case 2:
+			a.Value = args[1]
+			fallthrough
+		case 1:
+			a.Name = args[0]
+		}
+		err = cmd_print(client, "Server.Set", &a)
+	default:
+		fmt.Fprintf(os.Stderr, "gocode: unknown command: %s\n", cmd)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gocode: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// connect returns a client connected to the daemon, which is started if it
+// is not running, unless the command is close. With -sock=none the commands
+// are served in-process.
+func connect(cmd string) (*rpc.Client, error) {
+	if *g_sock == "none" {
+		s := new_server(default_options(), "", *g_debug)
+		s.opts.load(options_file())
+		return rpc.NewClient(s.serve_pipe()), nil
+	}
+	network, addr := socket_address()
+	client, err := rpc.Dial(network, addr)
+	if err == nil {
+		return client, nil
+	}
+	if cmd == "close" {
+		return nil, nil
+	}
+
+	if err := start_server(); err != nil {
+		return nil, err
+	}
+	for i := 0; i < 50; i++ {
+		time.Sleep(10 * time.Millisecond)
+		if client, err = rpc.Dial(network, addr); err == nil {
+			return client, nil
+		}
+	}
+	return nil, err
+}
+
+// start_server starts the daemon in the background.
+func start_server() error {
+	path, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	args := []string{"-s", "-sock", *g_sock, "-addr", *g_addr}
+	if *g_debug {
+		args = append(args, "-debug")
+	}
+	cmd := exec.Command(path, args...)
+	if *g_debug {
+		cmd.Stderr = os.Stderr
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+func cmd_print(client *rpc.Client, method string, args interface{}) error {
+	var reply string
+	if err := client.Call(method, args, &reply); err != nil {
+		return err
+	}
+	fmt.Print(reply)
+	return nil
+}
+
+func cmd_auto_complete(client *rpc.Client, w io.Writer) error {
+	f, ok := formatters[*g_format]
+	if !ok {
+		return fmt.Errorf("unknown format: %s", *g_format)
+	}
+
+	var (
+		file []byte
+		err  error
+	)
+	if *g_input != "" {
+		file, err = ioutil.ReadFile(*g_input)
+	} else {
+		file, err = ioutil.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return err
+	}
+
+	filename := *g_input
+	offset := ""
+	switch flag.NArg() {
+	case 2:
+		offset = flag.Arg(1)
+	case 3:
+		filename = flag.Arg(1) // override the name of the input file
+		offset = flag.Arg(2)
+	}
+	cursor := -1
+	if offset != "" {
+		if offset[0] == 'c' || offset[0] == 'C' {
+			cursor, _ = strconv.Atoi(offset[1:])
+			cursor = char_to_byte_offset(file, cursor)
+		} else {
+			cursor, _ = strconv.Atoi(offset)
+		}
+	}
+	if filename != "" && !filepath.IsAbs(filename) {
+		if cwd, err := os.Getwd(); err == nil {
+			filename = filepath.Join(cwd, filename)
+		}
+	}
+
+	args := AutoCompleteArgs{
+		File:     file,
+		Filename: filename,
+		Cursor:   cursor,
+		GOROOT:   build.Default.GOROOT,
+		GOPATH:   build.Default.GOPATH,
+	}
+	var reply AutoCompleteReply
+	if err := client.Call("Server.AutoComplete", &args, &reply); err != nil {
+		return err
+	}
+	f.write_candidates(w, reply.Candidates, reply.Len)
+	return nil
+}
+
+// char_to_byte_offset returns the byte offset of character offset
+// cursor of s.
+func char_to_byte_offset(s []byte, cursor int) int {
+	offset := 0
+	for ; cursor > 0 && offset < len(s); cursor-- {
+		_, size := utf8.DecodeRune(s[offset:])
+		offset += size
+	}
+	return offset
+}
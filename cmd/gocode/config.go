@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+//-------------------------------------------------------------------------
+// options
+//
+// Options of the server, which the set command changes. They are stored in
+// the config.json file of the gocode directory in the user's config
+// directory, which is compatible with the one of nsf/gocode.
+//-------------------------------------------------------------------------
+
+// The options of nsf/gocode that do not apply are kept, so that the editor
+// plugins setting them keep working, but have no effect: lib-path,
+// custom-pkg-prefix, custom-vendor-dir, package-lookup-mode, partials,
+// ignore-case and class-filtering.
+type options struct {
+	ProposeBuiltins    bool   `json:"propose-builtins"`
+	LibPath            string `json:"lib-path"`
+	CustomPkgPrefix    string `json:"custom-pkg-prefix"`
+	CustomVendorDir    string `json:"custom-vendor-dir"`
+	Autobuild          bool   `json:"autobuild"`
+	ForceDebugOutput   string `json:"force-debug-output"`
+	PackageLookupMode  string `json:"package-lookup-mode"`
+	CloseTimeout       int    `json:"close-timeout"`
+	UnimportedPackages bool   `json:"unimported-packages"`
+	Partials           bool   `json:"partials"`
+	IgnoreCase         bool   `json:"ignore-case"`
+	ClassFiltering     bool   `json:"class-filtering"`
+	Source             bool   `json:"source"`
+}
+
+func default_options() options {
+	return options{
+		PackageLookupMode: "go",
+		CloseTimeout:      1800,
+		Partials:          true,
+		ClassFiltering:    true,
+	}
+}
+
+// option_names are the names of the options in the order they are listed,
+// which is that of nsf/gocode.
+var option_names = []string{
+	"propose-builtins",
+	"lib-path",
+	"custom-pkg-prefix",
+	"custom-vendor-dir",
+	"autobuild",
+	"force-debug-output",
+	"package-lookup-mode",
+	"close-timeout",
+	"unimported-packages",
+	"partials",
+	"ignore-case",
+	"class-filtering",
+	"source",
+}
+
+func options_file() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "gocode", "config.json")
+}
+
+// load reads the options from file name, missing options keep their
+// values.
+func (o *options) load(name string) error {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, o)
+}
+
+func (o *options) save(name string) error {
+	data, err := json.MarshalIndent(o, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, append(data, '\n'), 0644)
+}
+
+func (o *options) get(name string) (string, error) {
+	switch name {
+	case "propose-builtins":
+		return strconv.FormatBool(o.ProposeBuiltins), nil
+	case "lib-path":
+		return strconv.Quote(o.LibPath), nil
+	case "custom-pkg-prefix":
+		return strconv.Quote(o.CustomPkgPrefix), nil
+	case "custom-vendor-dir":
+		return strconv.Quote(o.CustomVendorDir), nil
+	case "autobuild":
+		return strconv.FormatBool(o.Autobuild), nil
+	case "force-debug-output":
+		return strconv.Quote(o.ForceDebugOutput), nil
+	case "package-lookup-mode":
+		return strconv.Quote(o.PackageLookupMode), nil
+	case "close-timeout":
+		return strconv.Itoa(o.CloseTimeout), nil
+	case "unimported-packages":
+		return strconv.FormatBool(o.UnimportedPackages), nil
+	case "partials":
+		return strconv.FormatBool(o.Partials), nil
+	case "ignore-case":
+		return strconv.FormatBool(o.IgnoreCase), nil
+	case "class-filtering":
+		return strconv.FormatBool(o.ClassFiltering), nil
+	case "source":
+		return strconv.FormatBool(o.Source), nil
+	}
+	return "", fmt.Errorf("unknown option: %s", name)
+}
+
+func (o *options) set(name, value string) error {
+	var err error
+	switch name {
+	case "propose-builtins":
+		o.ProposeBuiltins, err = strconv.ParseBool(value)
+	case "lib-path":
+		o.LibPath = value
+	case "custom-pkg-prefix":
+		o.CustomPkgPrefix = value
+	case "custom-vendor-dir":
+		o.CustomVendorDir = value
+	case "autobuild":
+		o.Autobuild, err = strconv.ParseBool(value)
+	case "force-debug-output":
+		o.ForceDebugOutput = value
+	case "package-lookup-mode":
+		o.PackageLookupMode = value
+	case "close-timeout":
+		o.CloseTimeout, err = strconv.Atoi(value)
+	case "unimported-packages":
+		o.UnimportedPackages, err = strconv.ParseBool(value)
+	case "partials":
+		o.Partials, err = strconv.ParseBool(value)
+	case "ignore-case":
+		o.IgnoreCase, err = strconv.ParseBool(value)
+	case "class-filtering":
+		o.ClassFiltering, err = strconv.ParseBool(value)
+	case "source":
+		o.Source, err = strconv.ParseBool(value)
+	default:
+		return fmt.Errorf("unknown option: %s", name)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %q", name, value)
+	}
+	return nil
+}
+
+// list returns the lines "name value" of the options names, all of them if
+// names is empty.
+func (o *options) list(names ...string) (string, error) {
+	if len(names) == 0 {
+		names = option_names
+	}
+	var buf bytes.Buffer
+	for _, name := range names {
+		v, err := o.get(name)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&buf, "%s %s\n", name, v)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/charlievieth/gocode"
+)
+
+//-------------------------------------------------------------------------
+// formatter
+//
+// Writes the candidates of an autocomplete request in the format an editor
+// plugin expects, num is the length of the partial identifier the
+// candidates replace.
+//-------------------------------------------------------------------------
+
+type formatter interface {
+	write_candidates(w io.Writer, candidates []gocode.Candidate, num int)
+}
+
+var formatters = map[string]formatter{
+	"nice":  nice_formatter{},
+	"vim":   vim_formatter{},
+	"godit": godit_formatter{},
+	"emacs": emacs_formatter{},
+	"csv":   csv_formatter{},
+	"json":  json_formatter{},
+}
+
+// abbr returns the description of c, "class name type".
+func abbr(c gocode.Candidate) string {
+	if c.Class == "func" && strings.HasPrefix(c.Type, "func") {
+		return c.Class + " " + c.Name + c.Type[len("func"):]
+	}
+	return c.Class + " " + c.Name + " " + c.Type
+}
+
+// word returns the text inserted for c, which opens the parentheses of
+// functions and closes them if there are no arguments.
+func word(c gocode.Candidate) string {
+	if c.Class != "func" {
+		return c.Name
+	}
+	if strings.HasPrefix(c.Type, "func()") {
+		return c.Name + "()"
+	}
+	return c.Name + "("
+}
+
+type nice_formatter struct{}
+
+func (nice_formatter) write_candidates(w io.Writer, candidates []gocode.Candidate, num int) {
+	if len(candidates) == 0 {
+		fmt.Fprintf(w, "Nothing to complete.\n")
+		return
+	}
+	fmt.Fprintf(w, "Found %d candidates:\n", len(candidates))
+	for _, c := range candidates {
+		fmt.Fprintf(w, "  %s\n", abbr(c))
+	}
+}
+
+type vim_formatter struct{}
+
+func (vim_formatter) write_candidates(w io.Writer, candidates []gocode.Candidate, num int) {
+	if len(candidates) == 0 {
+		fmt.Fprint(w, "[0, []]")
+		return
+	}
+	quote := strings.NewReplacer("'", "''").Replace
+	fmt.Fprintf(w, "[%d, [", num)
+	for i, c := range candidates {
+		if i != 0 {
+			fmt.Fprintf(w, ", ")
+		}
+		a := quote(abbr(c))
+		fmt.Fprintf(w, "{'word': '%s', 'abbr': '%s', 'info': '%s'}", quote(word(c)), a, a)
+	}
+	fmt.Fprintf(w, "]]")
+}
+
+type godit_formatter struct{}
+
+func (godit_formatter) write_candidates(w io.Writer, candidates []gocode.Candidate, num int) {
+	if len(candidates) == 0 {
+		fmt.Fprint(w, "0,,0\n")
+		return
+	}
+	fmt.Fprintf(w, "%d,,%d\n", num, len(candidates))
+	for _, c := range candidates {
+		fmt.Fprintf(w, "%s,,%s\n", abbr(c), word(c))
+	}
+}
+
+type emacs_formatter struct{}
+
+func (emacs_formatter) write_candidates(w io.Writer, candidates []gocode.Candidate, num int) {
+	for _, c := range candidates {
+		var hint string
+		switch {
+		case c.Class == "func":
+			hint = c.Type
+		case c.Type == "":
+			hint = c.Class
+		default:
+			hint = c.Class + " " + c.Type
+		}
+		fmt.Fprintf(w, "%s,,%s\n", c.Name, hint)
+	}
+}
+
+type csv_formatter struct{}
+
+func (csv_formatter) write_candidates(w io.Writer, candidates []gocode.Candidate, num int) {
+	for _, c := range candidates {
+		fmt.Fprintf(w, "%s,,%s,,%s\n", c.Class, c.Name, c.Type)
+	}
+}
+
+type json_formatter struct{}
+
+// json_candidate is a candidate as nsf/gocode writes it.
+type json_candidate struct {
+	Class   string `json:"class"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Package string `json:"package"`
+}
+
+func (json_formatter) write_candidates(w io.Writer, candidates []gocode.Candidate, num int) {
+	if len(candidates) == 0 {
+		fmt.Fprint(w, "[]")
+		return
+	}
+	list := make([]json_candidate, len(candidates))
+	for i, c := range candidates {
+		list[i] = json_candidate{Class: c.Class, Name: c.Name, Type: c.Type, Package: c.Package}
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintf(w, "[%d, %s]", num, data)
+}
@@ -0,0 +1,78 @@
+// Command gocode is a daemon and client compatible with nsf/gocode, which
+// lets existing editor plugins use the gocode package.
+//
+// The client starts the daemon when it is not running and passes it the
+// commands:
+//
+//	autocomplete [<path>] <offset>  complete the file read from stdin or -in
+//	                                at byte offset, or character offset cN
+//	status                          print the status of the daemon
+//	close                           close the daemon
+//	drop-cache                      drop the caches of the daemon
+//	set [<name> [<value>]]          list, print or change the options
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+var (
+	g_is_server = flag.Bool("s", false, "run a server instead of a client")
+	g_format    = flag.String("f", "nice", "output format (vim | emacs | nice | csv | godit | json)")
+	g_input     = flag.String("in", "", "use this file instead of stdin input")
+	g_sock      = flag.String("sock", default_socket_type(), "socket type (unix | tcp | none)")
+	g_addr      = flag.String("addr", "127.0.0.1:37373", "address for tcp socket")
+	g_debug     = flag.Bool("debug", false, "enable server-side debug mode")
+)
+
+func default_socket_type() string {
+	if runtime.GOOS == "windows" {
+		return "tcp"
+	}
+	return "unix"
+}
+
+// socket_address returns the network and the address of the daemon.
+func socket_address() (string, string) {
+	if *g_sock == "unix" {
+		user := os.Getenv("USER")
+		if user == "" {
+			user = "all"
+		}
+		return "unix", filepath.Join(os.TempDir(), fmt.Sprintf("gocode-daemon.%s", user))
+	}
+	return "tcp", *g_addr
+}
+
+func show_usage() {
+	fmt.Fprintf(os.Stderr,
+		"Usage: %s [-s] [-f=<format>] [-in=<path>] [-sock=<type>] [-addr=<addr>]\n"+
+			"       <command> [<args>]\n\n",
+		os.Args[0])
+	fmt.Fprintf(os.Stderr, "Flags:\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr,
+		"\nCommands:\n"+
+			"  autocomplete [<path>] <offset>     main autocompletion command\n"+
+			"  close                              close the gocode daemon\n"+
+			"  drop-cache                         drop gocode daemon's cache\n"+
+			"  set [<name> [<value>]]             list, show or set config options\n"+
+			"  status                             gocode daemon status report\n")
+}
+
+func main() {
+	flag.Usage = show_usage
+	flag.Parse()
+
+	var code int
+	if *g_is_server {
+		code = do_server()
+	} else {
+		code = do_client()
+	}
+	os.Exit(code)
+}
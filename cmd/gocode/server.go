@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/rpc"
+	"os"
+	"os/signal"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/charlievieth/gocode"
+)
+
+//-------------------------------------------------------------------------
+// server
+//
+// The daemon, which keeps the caches of the engine between the requests of
+// the clients. Its methods are called by the clients through net/rpc.
+//-------------------------------------------------------------------------
+
+type AutoCompleteArgs struct {
+	File     []byte
+	Filename string
+	Cursor   int
+
+	// environment of the client
+	GOROOT string
+	GOPATH string
+}
+
+type AutoCompleteReply struct {
+	Candidates []gocode.Candidate
+	Len        int // length of the partial identifier
+}
+
+type SetArgs struct {
+	Name  string
+	Value string
+}
+
+type server struct {
+	engine  *gocode.Engine
+	file    string // options file, if any
+	started time.Time
+
+	mu    sync.Mutex
+	opts  options
+	debug *log.Logger // nil unless debugging
+
+	activity chan struct{} // signaled on every request
+	closing  chan struct{} // closed by Close
+	once     sync.Once
+}
+
+func new_server(opts options, file string, debug bool) *server {
+	s := &server{
+		engine:   gocode.NewEngine(nil),
+		file:     file,
+		started:  time.Now(),
+		opts:     opts,
+		activity: make(chan struct{}, 1),
+		closing:  make(chan struct{}),
+	}
+	if debug {
+		s.debug = log.New(os.Stderr, "gocode: ", log.LstdFlags)
+	}
+	s.set_debug_output()
+	return s
+}
+
+// set_debug_output redirects the debug output to the force-debug-output
+// file, which forces debugging.
+func (s *server) set_debug_output() {
+	if s.opts.ForceDebugOutput == "" {
+		return
+	}
+	f, err := os.OpenFile(s.opts.ForceDebugOutput, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		s.logf("force-debug-output: %v", err)
+		return
+	}
+	s.debug = log.New(f, "gocode: ", log.LstdFlags)
+}
+
+// logf writes to the debug output, s.mu must be held.
+func (s *server) logf(format string, args ...interface{}) {
+	if s.debug != nil {
+		s.debug.Printf(format, args...)
+	}
+}
+
+func (s *server) touch() {
+	select {
+	case s.activity <- struct{}{}:
+	default:
+	}
+}
+
+func (s *server) AutoComplete(args *AutoCompleteArgs, reply *AutoCompleteReply) error {
+	s.touch()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.engine.SetConfig(&gocode.Config{
+		GOROOT:    args.GOROOT,
+		GOPATH:    args.GOPATH,
+		Builtins:  s.opts.ProposeBuiltins,
+		AutoBuild: s.opts.Autobuild,
+		Source:    s.opts.Source,
+
+		UnimportedPackages: s.opts.UnimportedPackages,
+	})
+	start := time.Now()
+	res := s.engine.Complete(args.File, args.Filename, args.Cursor)
+	reply.Candidates = res.Candidates
+	reply.Len = res.End.Offset - res.Start.Offset
+	s.logf("autocomplete %s:%d: %d candidates in %v", args.Filename, args.Cursor,
+		len(res.Candidates), time.Since(start))
+	return nil
+}
+
+func (s *server) Status(_ int, reply *string) error {
+	s.touch()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	opts, _ := s.opts.list()
+	*reply = fmt.Sprintf("Server's GOMAXPROCS == %d\nServer's uptime: %v\n\nOptions:\n%s",
+		runtime.GOMAXPROCS(0), time.Since(s.started).Round(time.Second), opts)
+	return nil
+}
+
+func (s *server) DropCache(_ int, _ *int) error {
+	s.touch()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.engine.DropCache()
+	s.logf("drop-cache")
+	return nil
+}
+
+// Set lists, reads or changes the options, depending on whether the name and
+// the value of args are set.
+func (s *server) Set(args *SetArgs, reply *string) error {
+	s.touch()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if args.Name == "" {
+		var err error
+		*reply, err = s.opts.list()
+		return err
+	}
+	if args.Value != "" {
+		if err := s.opts.set(args.Name, args.Value); err != nil {
+			return err
+		}
+		if args.Name == "force-debug-output" {
+			s.set_debug_output()
+		}
+		if s.file != "" {
+			if err := s.opts.save(s.file); err != nil {
+				return err
+			}
+		}
+	}
+	var err error
+	*reply, err = s.opts.list(args.Name)
+	return err
+}
+
+func (s *server) Close(_ int, _ *int) error {
+	s.once.Do(func() { close(s.closing) })
+	return nil
+}
+
+// rpc_server returns the RPC server serving the methods of s.
+func (s *server) rpc_server() *rpc.Server {
+	rs := rpc.NewServer()
+	if err := rs.RegisterName("Server", s); err != nil {
+		panic(err)
+	}
+	return rs
+}
+
+// serve accepts connections from ln until the server is closed or has been
+// idle for the close-timeout.
+func (s *server) serve(ln net.Listener) error {
+	rs := s.rpc_server()
+	var wg sync.WaitGroup
+	errc := make(chan error, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				errc <- err
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				rs.ServeConn(conn)
+			}()
+		}
+	}()
+	defer func() {
+		// let the clients receive the replies of their last calls
+		ln.Close()
+		<-done
+		wg.Wait()
+		s.engine.Close()
+	}()
+
+	s.mu.Lock()
+	timeout := time.Duration(s.opts.CloseTimeout) * time.Second
+	s.mu.Unlock()
+	var idle <-chan time.Time // nil if the server is never closed
+	if timeout > 0 {
+		idle = time.After(timeout)
+	}
+	for {
+		select {
+		case <-s.activity:
+			if timeout > 0 {
+				idle = time.After(timeout)
+			}
+		case <-idle:
+			s.mu.Lock()
+			s.logf("closing after %v of inactivity", timeout)
+			s.mu.Unlock()
+			return nil
+		case <-s.closing:
+			return nil
+		case err := <-errc:
+			return err
+		}
+	}
+}
+
+// serve_pipe serves the methods of s in-process, it returns the connection
+// of the client.
+func (s *server) serve_pipe() io.ReadWriteCloser {
+	c1, c2 := net.Pipe()
+	go s.rpc_server().ServeConn(c1)
+	return c2
+}
+
+func do_server() int {
+	opts := default_options()
+	file := options_file()
+	if err := opts.load(file); err != nil && !os.IsNotExist(err) {
+		log.Printf("gocode: %s: %v", file, err)
+	}
+
+	network, addr := socket_address()
+	if network == "unix" {
+		if _, err := os.Stat(addr); err == nil {
+			log.Printf("gocode: unix socket %s already exists", addr)
+			return 1
+		}
+	}
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		log.Printf("gocode: %v", err)
+		return 1
+	}
+	if network == "unix" {
+		defer os.Remove(addr)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	s := new_server(opts, file, *g_debug)
+	go func() {
+		<-sigs
+		s.Close(0, nil)
+	}()
+	if err := s.serve(ln); err != nil {
+		log.Printf("gocode: %v", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"go/build"
+	"net/rpc"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/charlievieth/gocode"
+)
+
+func TestServer(t *testing.T) {
+	s := new_server(default_options(), "", false)
+	client := rpc.NewClient(s.serve_pipe())
+	defer client.Close()
+
+	src := "package main\n\nimport \"strings\"\n\nfunc main() {\n\tstrings.Builde\n}\n"
+	args := AutoCompleteArgs{
+		File:     []byte(src),
+		Filename: filepath.Join(t.TempDir(), "main.go"),
+		Cursor:   strings.Index(src, "Builde") + len("Builde"),
+		GOROOT:   build.Default.GOROOT,
+		GOPATH:   build.Default.GOPATH,
+	}
+	var reply AutoCompleteReply
+	if err := client.Call("Server.AutoComplete", &args, &reply); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	formatters["json"].write_candidates(&buf, reply.Candidates, reply.Len)
+	if want := `[6, [{"class":"type","name":"Builder","type":"struct","package":"strings"}]]`; buf.String() != want {
+		t.Errorf("autocomplete: got %s want %s", buf.String(), want)
+	}
+
+	var out string
+	if err := client.Call("Server.Set", &SetArgs{Name: "propose-builtins", Value: "true"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out != "propose-builtins true\n" {
+		t.Errorf("set: got %q", out)
+	}
+	if err := client.Call("Server.Set", &SetArgs{Name: "bogus", Value: "1"}, &out); err == nil {
+		t.Error("set: expected an error for an unknown option")
+	}
+	if err := client.Call("Server.DropCache", 0, new(int)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The editor plugins of nsf/gocode set its options on startup.
+func TestOptions(t *testing.T) {
+	nsf := map[string]string{
+		"propose-builtins":    "true",
+		"lib-path":            "/tmp/lib",
+		"custom-pkg-prefix":   "example.com",
+		"custom-vendor-dir":   "vendor",
+		"autobuild":           "true",
+		"force-debug-output":  "",
+		"package-lookup-mode": "gb",
+		"close-timeout":       "60",
+		"unimported-packages": "true",
+		"partials":            "false",
+		"ignore-case":         "true",
+		"class-filtering":     "false",
+	}
+	o := default_options()
+	for name, value := range nsf {
+		if err := o.set(name, value); err != nil {
+			t.Errorf("set %s: %v", name, err)
+			continue
+		}
+		got, err := o.get(name)
+		if err != nil {
+			t.Errorf("get %s: %v", name, err)
+			continue
+		}
+		if v, err := strconv.Unquote(got); err == nil {
+			got = v
+		}
+		if got != value {
+			t.Errorf("%s: got %s want %s", name, got, value)
+		}
+	}
+	if _, err := o.list(); err != nil {
+		t.Error(err)
+	}
+	if !o.UnimportedPackages {
+		t.Error("unimported-packages is not set")
+	}
+}
+
+func TestFormatters(t *testing.T) {
+	candidates := []gocode.Candidate{
+		{Name: "Println", Type: "func(a ...any) (n int, err error)", Class: "func"},
+		{Name: "Stringer", Type: "interface", Class: "type"},
+	}
+	tests := map[string]string{
+		"nice":  "Found 2 candidates:\n  func Println(a ...any) (n int, err error)\n  type Stringer interface\n",
+		"vim":   "[3, [{'word': 'Println(', 'abbr': 'func Println(a ...any) (n int, err error)', 'info': 'func Println(a ...any) (n int, err error)'}, {'word': 'Stringer', 'abbr': 'type Stringer interface', 'info': 'type Stringer interface'}]]",
+		"godit": "3,,2\nfunc Println(a ...any) (n int, err error),,Println(\ntype Stringer interface,,Stringer\n",
+		"emacs": "Println,,func(a ...any) (n int, err error)\nStringer,,type interface\n",
+		"csv":   "func,,Println,,func(a ...any) (n int, err error)\ntype,,Stringer,,interface\n",
+	}
+	for name, want := range tests {
+		var buf bytes.Buffer
+		formatters[name].write_candidates(&buf, candidates, 3)
+		if buf.String() != want {
+			t.Errorf("%s: got %q want %q", name, buf.String(), want)
+		}
+	}
+}
@@ -5,16 +5,21 @@ import "sync"
 //-------------------------------------------------------------------------
 // config
 //
-// Structure represents persistent config storage of the gocode daemon. Usually
-// the config is located somewhere in ~/.config/gocode directory.
+// Structure represents the config of an Engine, which shares it with its
+// caches through package_lookup_context.
 //-------------------------------------------------------------------------
 
 type config struct {
 	proposeBuiltins    bool
 	libPath            string
 	autobuild          bool
+	sourceImporter     bool
 	forceDebugOutput   string
 	unimportedPackages bool
+	docs               bool
+	matcher            Matcher
+	snippets           bool
+	fillStructs        bool
 	mu                 sync.RWMutex
 
 	// Excludes: PackageLookupMode, used to enable 'gb' lookup.
@@ -33,6 +38,58 @@ func (c *config) SetUnimportedPackages(b bool) {
 	c.mu.Unlock()
 }
 
+func (c *config) Docs() (b bool) {
+	c.mu.RLock()
+	b = c.docs
+	c.mu.RUnlock()
+	return
+}
+
+func (c *config) SetDocs(b bool) {
+	c.mu.Lock()
+	c.docs = b
+	c.mu.Unlock()
+}
+
+func (c *config) Matcher() (m Matcher) {
+	c.mu.RLock()
+	m = c.matcher
+	c.mu.RUnlock()
+	return
+}
+
+func (c *config) SetMatcher(m Matcher) {
+	c.mu.Lock()
+	c.matcher = m
+	c.mu.Unlock()
+}
+
+func (c *config) Snippets() (b bool) {
+	c.mu.RLock()
+	b = c.snippets
+	c.mu.RUnlock()
+	return
+}
+
+func (c *config) SetSnippets(b bool) {
+	c.mu.Lock()
+	c.snippets = b
+	c.mu.Unlock()
+}
+
+func (c *config) FillStructs() (b bool) {
+	c.mu.RLock()
+	b = c.fillStructs
+	c.mu.RUnlock()
+	return
+}
+
+func (c *config) SetFillStructs(b bool) {
+	c.mu.Lock()
+	c.fillStructs = b
+	c.mu.Unlock()
+}
+
 func (c *config) ProposeBuiltins() (b bool) {
 	c.mu.RLock()
 	b = c.proposeBuiltins
@@ -52,6 +109,19 @@ func (c *config) SetAutoBuild(b bool) {
 	c.mu.Unlock()
 }
 
+func (c *config) SetSourceImporter(b bool) {
+	c.mu.Lock()
+	c.sourceImporter = b
+	c.mu.Unlock()
+}
+
+func (c *config) SourceImporter() (b bool) {
+	c.mu.RLock()
+	b = c.sourceImporter
+	c.mu.RUnlock()
+	return
+}
+
 func (c *config) LibPath() (s string) {
 	c.mu.RLock()
 	s = c.libPath
@@ -72,11 +142,3 @@ func (c *config) ForceDebugOutput() (s string) {
 	c.mu.RUnlock()
 	return
 }
-
-var g_config = config{
-	proposeBuiltins:  false,
-	libPath:          "",
-	autobuild:        false,
-	forceDebugOutput: "",
-	mu:               sync.RWMutex{},
-}
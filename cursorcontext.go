@@ -19,6 +19,11 @@ type cursor_context struct {
 	// if decl is nil, then deduction failed, we could try to resolve it to
 	// unimported package instead
 	expr ast.Expr
+
+	// type expected for the expression at the cursor and its scope, if
+	// known, see deduce_expected_type
+	expected       ast.Expr
+	expected_scope *scope
 }
 
 type token_iterator struct {
@@ -116,6 +121,61 @@ func (this *token_iterator) skip_to_left_curly() bool {
 	return this.skip_to_left(token.LBRACE, token.RBRACE)
 }
 
+// Move the cursor to the open parenthesis of the call the cursor is in and
+// return the index of the argument at the cursor. Fails if the cursor is in
+// a block, a composite literal or an index expression first.
+func (ti *token_iterator) skip_to_call() (int, bool) {
+	if len(ti.tokens) == 0 {
+		return 0, false
+	}
+	arg := 0
+	for {
+		switch ti.token().tok {
+		case token.LPAREN:
+			return arg, true
+		case token.COMMA:
+			arg++
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			if !ti.skip_to_balanced_pair() {
+				return 0, false
+			}
+		case token.LBRACK, token.LBRACE, token.SEMICOLON:
+			return 0, false
+		}
+		if !ti.go_back() {
+			return 0, false
+		}
+	}
+}
+
+// Move the cursor to the return keyword of the return statement the cursor
+// is in and return the index of the result at the cursor. Fails if the cursor
+// is in brackets or in another statement first.
+func (ti *token_iterator) skip_to_return() (int, bool) {
+	if len(ti.tokens) == 0 {
+		return 0, false
+	}
+	result := 0
+	for {
+		switch ti.token().tok {
+		case token.RETURN:
+			return result, true
+		case token.COMMA:
+			result++
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			if !ti.skip_to_balanced_pair() {
+				return 0, false
+			}
+		case token.LPAREN, token.LBRACK, token.LBRACE, token.SEMICOLON,
+			token.ASSIGN, token.DEFINE, token.COLON:
+			return 0, false
+		}
+		if !ti.go_back() {
+			return 0, false
+		}
+	}
+}
+
 func (ti *token_iterator) extract_type_alike() string {
 	if ti.token().tok != token.IDENT { // not Foo, return nothing
 		return ""
@@ -394,6 +454,16 @@ func (c *auto_complete_context) deduce_cursor_context(file []byte, cursor int) (
 		default:
 			return cursor_context{partial: partial}, true
 		}
+	case token.BREAK, token.CASE, token.CHAN, token.CONTINUE, token.DEFAULT,
+		token.DEFER, token.ELSE, token.FALLTHROUGH, token.FOR, token.GO,
+		token.GOTO, token.IF, token.IMPORT, token.INTERFACE, token.MAP,
+		token.RANGE, token.RETURN, token.SELECT, token.STRUCT, token.SWITCH:
+		// we're '<keyword>', which is the partial of a keyword candidate
+		partial_len := cursor - tok.off
+		if partial_len > len(tok.literal()) {
+			return cursor_context{}, true
+		}
+		return cursor_context{partial: tok.literal()[:partial_len]}, true
 	case token.COMMA, token.LBRACE:
 		// Try to parse the current expression as a structure initialization.
 		decl := c.deduce_struct_type_decl(&iter)
@@ -408,29 +478,31 @@ func (c *auto_complete_context) deduce_cursor_context(file []byte, cursor int) (
 }
 
 // Decl deduction failed, but we're on "<ident>.", this ident can be an
-// unexported package, let's try to match the ident against a set of known
-// packages and if it matches try to import it.
-// TODO: Right now I've made a static list of built-in packages, but in theory
-// we could scan all GOPATH packages as well. Now, don't forget that default
-// package name has nothing to do with package file name, that's why we need to
-// scan the packages. And many of them will have conflicts. Can we make a smart
-// prediction algorithm which will prefer certain packages over another ones?
-func resolveKnownPackageIdent(ident string, filename string, context *package_lookup_context) *decl {
-	importPath, ok := knownPackageIdents[ident]
-	if !ok {
-		return nil
-	}
-
-	path, ok := abs_path_for_package(filename, importPath, context)
-	if !ok {
-		return nil
+// unimported package, let's try to match the ident against the names of the
+// packages of the package index, best ranked first, and if it matches try to
+// import it. It returns the package and its import path. The packages are
+// loaded into the package cache, along with their dependencies.
+func (c *auto_complete_context) resolveKnownPackageIdent(ident string) (*decl, string) {
+	ctxt := c.declcache.context
+	ctx := ctxt.request_context()
+	for _, importPath := range ctxt.index.lookup(ident, ctxt) {
+		path, ok := abs_path_for_package(c.current.name, importPath, ctxt)
+		if !ok {
+			continue
+		}
+		ps := make(map[string]*package_file_cache, 1)
+		c.pcache.append_packages(ps, []package_import{{abspath: path, path: importPath}}, ctxt)
+		update_packages(ctx, ps)
+		c.pcache.update_dependencies(ctx, ps, ctxt)
+		if p := c.pcache[path]; p.main != nil {
+			return p.main, importPath
+		}
 	}
-
-	p := new_package_file_cache(path, path)
-	p.update_cache()
-	return p.main
+	return nil, ""
 }
 
+// knownPackageIdents are the preferred packages among those with the same
+// name.
 var knownPackageIdents = map[string]string{
 	"adler32":         "hash/adler32",
 	"aes":             "crypto/aes",
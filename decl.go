@@ -23,6 +23,8 @@ const (
 	decl_package
 	decl_type
 	decl_var
+	decl_keyword
+	decl_fields // fill-in of the fields of a struct literal
 
 	// this one serves as a temporary type for those methods that were
 	// declared before their actual owner
@@ -45,6 +47,10 @@ func (this decl_class) String() string {
 		return "type"
 	case decl_var:
 		return "var"
+	case decl_keyword:
+		return "keyword"
+	case decl_fields:
+		return "fields"
 	case decl_methods_stub:
 		return "IF YOU SEE THIS, REPORT A BUG" // :D
 	}
@@ -68,6 +74,9 @@ const (
 	decl_visited
 
 	decl_visited_find_child_and_in_embedded
+
+	// decl of decl_func class is a method with a pointer receiver
+	decl_pointer_recv
 )
 
 //-------------------------------------------------------------------------
@@ -102,6 +111,20 @@ type decl struct {
 	// scope where this Decl was declared in (not its visibilty scope!)
 	// Decl uses it for type inference
 	scope *scope
+
+	// type parameters of a generic type or of the receiver of its methods,
+	// the latter have no constraints
+	tparams *ast.FieldList
+
+	// generic type of an instance created by instantiate, instances share
+	// the visited flag of their generic type
+	origin *decl
+
+	// position of the name of the declaration, resolved by its scope
+	pos token.Pos
+
+	// doc comment, only collected if enabled, see Config.Docs
+	doc string
 }
 
 func ast_decl_type(d ast.Decl) ast.Expr {
@@ -119,7 +142,6 @@ func ast_decl_type(d ast.Decl) ast.Expr {
 		return t.Type
 	}
 	panic("unreachable")
-	return nil
 }
 
 func ast_decl_flags(d ast.Decl) decl_flags {
@@ -131,6 +153,12 @@ func ast_decl_flags(d ast.Decl) decl_flags {
 				return decl_alias
 			}
 		}
+	case *ast.FuncDecl:
+		if t.Recv != nil && len(t.Recv.List) != 0 {
+			if _, ok := strip_type_args(t.Recv.List[0].Type).(*ast.StarExpr); ok {
+				return decl_pointer_recv
+			}
+		}
 	}
 	return 0
 }
@@ -184,6 +212,8 @@ func ast_field_list_to_decls(f *ast.FieldList, class decl_class, flags decl_flag
 				flags:       flags,
 				scope:       scope,
 				value_index: -1,
+				pos:         name.Pos(),
+				doc:         field_doc(field),
 			}
 			decls[d.name] = d
 		}
@@ -201,6 +231,7 @@ func ast_field_list_to_decls(f *ast.FieldList, class decl_class, flags decl_flag
 				flags:       flags,
 				scope:       scope,
 				value_index: -1,
+				pos:         field.Type.Pos(),
 			}
 			decls[d.name] = d
 		}
@@ -342,12 +373,12 @@ func new_decl_var(name string, typ ast.Expr, value ast.Expr, vindex int, scope *
 func method_of(d ast.Decl) string {
 	if t, ok := d.(*ast.FuncDecl); ok {
 		if t.Recv != nil && len(t.Recv.List) != 0 {
-			switch t := t.Recv.List[0].Type.(type) {
+			switch t := strip_type_args(t.Recv.List[0].Type).(type) {
 			case *ast.StarExpr:
-				if se, ok := t.X.(*ast.SelectorExpr); ok {
+				if se, ok := strip_type_args(t.X).(*ast.SelectorExpr); ok {
 					return se.Sel.Name
 				}
-				if ident, ok := t.X.(*ast.Ident); ok {
+				if ident, ok := strip_type_args(t.X).(*ast.Ident); ok {
 					return ident.Name
 				}
 				return ""
@@ -379,9 +410,19 @@ func (other *decl) deep_copy() *decl {
 		embedded:    append(([]ast.Expr)(nil), other.embedded...),
 		children:    children,
 		scope:       other.scope,
+		tparams:     other.tparams,
+		origin:      other.origin,
+		pos:         other.pos,
+		doc:         other.doc,
 	}
 }
 
+// position returns the position of the name of d, the position is invalid if
+// it is not known.
+func (d *decl) position() token.Position {
+	return d.scope.position(d.pos)
+}
+
 func (d *decl) is_rangevar() bool {
 	return d.flags&decl_rangevar != 0
 }
@@ -391,6 +432,9 @@ func (d *decl) is_alias() bool {
 }
 
 func (d *decl) is_visited() bool {
+	if d.origin != nil {
+		return d.origin.is_visited()
+	}
 	return d.flags&decl_visited != 0
 }
 
@@ -399,6 +443,15 @@ func (d *decl) is_visited_find_child_and_in_embedded() bool {
 }
 
 func (d *decl) set_visited() {
+	// the universe scope is shared by all engines, its declarations are
+	// never part of a loop and are not marked to avoid data races
+	if d.scope == g_universe_scope {
+		return
+	}
+	if d.origin != nil {
+		d.origin.set_visited()
+		return
+	}
 	d.flags |= decl_visited
 }
 
@@ -407,6 +460,13 @@ func (d *decl) set_visited_find_child_and_in_embedded() {
 }
 
 func (d *decl) clear_visited() {
+	if d.scope == g_universe_scope {
+		return
+	}
+	if d.origin != nil {
+		d.origin.clear_visited()
+		return
+	}
 	d.flags &^= decl_visited
 }
 
@@ -424,6 +484,10 @@ func (d *decl) expand_or_replace(other *decl) {
 		d.typ = other.typ
 		d.class = other.class
 		d.flags = other.flags
+		d.tparams = other.tparams
+		d.scope = other.scope
+		d.pos = other.pos
+		d.doc = other.doc
 	}
 
 	if other.children != nil {
@@ -567,10 +631,33 @@ func get_type_path(e ast.Expr) (r type_path) {
 			r.pkg = ident.Name
 		}
 		r.name = t.Sel.Name
+	case *ast.IndexExpr, *ast.IndexListExpr:
+		r = get_type_path(strip_type_args(t))
 	}
 	return
 }
 
+// new_index_expr returns the instantiation of generic type or function x
+// with type arguments args: x[args[0], args[1], ...].
+func new_index_expr(x ast.Expr, args []ast.Expr) ast.Expr {
+	if len(args) == 1 {
+		return &ast.IndexExpr{X: x, Index: args[0]}
+	}
+	return &ast.IndexListExpr{X: x, Indices: args}
+}
+
+// strip_type_args returns the generic type of instantiation e or e itself
+// if it is not an instantiation: List[int] => List.
+func strip_type_args(e ast.Expr) ast.Expr {
+	switch t := e.(type) {
+	case *ast.IndexExpr:
+		return t.X
+	case *ast.IndexListExpr:
+		return t.X
+	}
+	return e
+}
+
 func lookup_path(tp type_path, scope *scope) *decl {
 	if tp.is_nil() {
 		return nil
@@ -617,6 +704,11 @@ func type_to_decl(t ast.Expr, scope *scope) *decl {
 		// weird variable declaration pointing to itself
 		return nil
 	}
+	if d != nil && d.tparams != nil {
+		if args := type_args(t); args != nil {
+			return d.instantiate(args, scope)
+		}
+	}
 	return d
 }
 
@@ -828,11 +920,19 @@ func infer_type(v ast.Expr, scope *scope, index int) (ast.Expr, *scope, bool) {
 			return it, s, false
 		}
 	case *ast.IndexExpr:
-		// something[another] always returns a value and it works on a value too
-		it, s, _ := infer_type(t.X, scope, -1)
+		// something[another] always returns a value and it works on a value too,
+		// unless it is an instantiation of a generic type or function
+		it, s, is_type := infer_type(t.X, scope, -1)
 		if it == nil {
 			break
 		}
+		if is_type {
+			return t, scope, true
+		}
+		if ft, ok := it.(*ast.FuncType); ok && ft.TypeParams != nil {
+			ft, s := instantiate_func(ft, s, explicit_type_args(ft, []ast.Expr{t.Index}, scope))
+			return ft, s, false
+		}
 		it, s = advance_to_type(index_predicate, it, s)
 		switch t := it.(type) {
 		case *ast.ArrayType:
@@ -847,6 +947,19 @@ func infer_type(v ast.Expr, scope *scope, index int) (ast.Expr, *scope, bool) {
 				return ast.NewIdent("bool"), g_universe_scope, false
 			}
 		}
+	case *ast.IndexListExpr:
+		// an instantiation of a generic type or function: Map[int, string]
+		it, s, is_type := infer_type(t.X, scope, -1)
+		if it == nil {
+			break
+		}
+		if is_type {
+			return t, scope, true
+		}
+		if ft, ok := it.(*ast.FuncType); ok && ft.TypeParams != nil {
+			ft, s := instantiate_func(ft, s, explicit_type_args(ft, t.Indices, scope))
+			return ft, s, false
+		}
 	case *ast.SliceExpr:
 		// something[start : end] always returns a value
 		it, s, _ := infer_type(t.X, scope, -1)
@@ -894,8 +1007,13 @@ func infer_type(v ast.Expr, scope *scope, index int) (ast.Expr, *scope, bool) {
 			}
 
 			// then check for an ordinary function call
-			it, scope = advance_to_type(func_predicate, it, s)
+			it, fs := advance_to_type(func_predicate, it, s)
 			if ct, ok := it.(*ast.FuncType); ok {
+				if ct.TypeParams != nil {
+					// a call of a generic function, infer its type arguments
+					targs := infer_type_args(ct, fs, t.Args, scope)
+					ct, s = instantiate_func(ct, fs, targs)
+				}
 				return func_return_type(ct, index), s, false
 			}
 		}
@@ -1180,8 +1298,39 @@ func pretty_print_type_expr(out *bytes.Buffer, e ast.Expr, canonical_aliases map
 		pretty_print_type_expr(out, t.X, canonical_aliases)
 		out.WriteByte('.')
 		out.WriteString(t.Sel.Name)
+	case *ast.IndexExpr:
+		pretty_print_type_expr(out, t.X, canonical_aliases)
+		out.WriteByte('[')
+		pretty_print_type_expr(out, t.Index, canonical_aliases)
+		out.WriteByte(']')
+	case *ast.IndexListExpr:
+		pretty_print_type_expr(out, t.X, canonical_aliases)
+		out.WriteByte('[')
+		for i, index := range t.Indices {
+			if i != 0 {
+				out.WriteString(", ")
+			}
+			pretty_print_type_expr(out, index, canonical_aliases)
+		}
+		out.WriteByte(']')
+	case *ast.BinaryExpr:
+		// union of a constraint's type set
+		pretty_print_type_expr(out, t.X, canonical_aliases)
+		out.WriteString(" | ")
+		pretty_print_type_expr(out, t.Y, canonical_aliases)
+	case *ast.UnaryExpr:
+		if t.Op == token.TILDE {
+			out.WriteByte('~')
+			pretty_print_type_expr(out, t.X, canonical_aliases)
+		}
 	case *ast.FuncType:
-		out.WriteString("func(")
+		out.WriteString("func")
+		if t.TypeParams != nil && len(t.TypeParams.List) != 0 {
+			out.WriteByte('[')
+			pretty_print_func_field_list(out, t.TypeParams, canonical_aliases)
+			out.WriteByte(']')
+		}
+		out.WriteString("(")
 		pretty_print_func_field_list(out, t.Params, canonical_aliases)
 		out.WriteByte(')')
 
@@ -1438,6 +1587,8 @@ func init() {
 	add_type("uint")
 	add_type("uintptr")
 	add_type("rune")
+	add_type("any")
+	add_type("comparable")
 
 	add_const := func(name string) {
 		d := new_decl(name, decl_const, g_universe_scope)
@@ -1,6 +1,8 @@
 package gocode
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"go/ast"
 	"go/build"
@@ -13,7 +15,9 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/charlievieth/buildutil"
 	"github.com/charlievieth/gocode/fs"
 )
 
@@ -78,6 +82,15 @@ func new_decl_file_cache(name string, context *package_lookup_context) *decl_fil
 }
 
 func (f *decl_file_cache) update() {
+	if data, ok := f.context.overlay[f.name]; ok {
+		// unsaved contents, forget the mtime so that the file is read
+		// again once the overlay is gone
+		f.error = nil
+		f.mtime = 0
+		f.update_data(data)
+		return
+	}
+
 	stat, err := fs.Stat(f.name)
 	if err != nil {
 		f.decls = nil
@@ -91,19 +104,22 @@ func (f *decl_file_cache) update() {
 		return
 	}
 
-	data, err := file_reader.read_file(f.name)
+	data, err := f.context.reader.read_file(f.name)
 	if err != nil {
 		f.error = err
 		return
 	}
 	f.error = nil
 	f.mtime = statmtime
+	f.update_data(data)
+}
 
+func (f *decl_file_cache) update_data(data []byte) {
 	sum := crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli))
-	if f.checksum == sum && f.size == stat.Size() {
+	if f.checksum == sum && f.size == int64(len(data)) {
 		return
 	}
-	f.size = stat.Size()
+	f.size = int64(len(data))
 	f.checksum = sum
 
 	data, _ = filter_out_shebang(data)
@@ -113,8 +129,9 @@ func (f *decl_file_cache) update() {
 func (f *decl_file_cache) process_data(data []byte) {
 	var file *ast.File
 	f.fset = token.NewFileSet()
-	file, f.error = parser.ParseFile(f.fset, "", data, 0)
+	file, f.error = parser.ParseFile(f.fset, f.name, data, f.context.parse_mode())
 	f.filescope = new_scope(nil)
+	f.filescope.positions = f.fset.Position
 	for _, d := range file.Decls {
 		anonymify_ast(d, 0, f.filescope)
 	}
@@ -135,6 +152,9 @@ func append_to_top_decls(decls map[string]*decl, decl ast.Decl, scope *scope) {
 			if d == nil {
 				return
 			}
+			d.pos = name.Pos()
+			d.tparams = ast_decl_type_params(data.decl)
+			d.doc = ast_decl_doc(data.decl)
 
 			methodof := method_of(decl)
 			if methodof != "" {
@@ -169,6 +189,32 @@ func abs_path_for_package(filename, p string, context *package_lookup_context) (
 	return find_global_file(p, context)
 }
 
+// unresolved_imports returns the paths of the imports of decls that are
+// missing from pkgs, the imports collect_package_imports resolved.
+func unresolved_imports(decls []ast.Decl, pkgs []package_import) []string {
+	var paths []string
+	for _, decl := range decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.IMPORT {
+			break
+		}
+	specs:
+		for _, spec := range gd.Specs {
+			path, alias := path_and_alias(spec.(*ast.ImportSpec))
+			if path == "C" || alias == "_" {
+				continue
+			}
+			for _, p := range pkgs {
+				if p.path == path {
+					continue specs
+				}
+			}
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
 func path_and_alias(imp *ast.ImportSpec) (string, string) {
 	path := ""
 	if imp.Path != nil && len(imp.Path.Value) > 0 {
@@ -184,36 +230,44 @@ func path_and_alias(imp *ast.ImportSpec) (string, string) {
 
 // autobuild compares the mod time of the source files of the package, and if any of them is newer
 // than the package object file will rebuild it.
-func autobuild(p *build.Package) error {
+func autobuild(p *build.Package, context *package_lookup_context) error {
 	if p.Dir == "" {
 		return fmt.Errorf("no files to build")
 	}
 	ps, err := fs.Stat(p.PkgObj)
 	if err != nil {
 		// Assume package file does not exist and build for the first time.
-		return build_package(p)
+		return build_package(p, context)
 	}
-	pt := ps.ModTime()
-	fs, err := readdir_lstat(p.Dir)
+	stale, err := modified_after(p.Dir, ps.ModTime(), context.dirs)
 	if err != nil {
 		return err
 	}
+	if stale {
+		// Source file is newer than package file; rebuild.
+		return build_package(p, context)
+	}
+	return nil
+}
+
+// modified_after reports if any file in directory dir was modified after t.
+func modified_after(dir string, t time.Time, dirs *DirCache) (bool, error) {
+	fs, err := dirs.readdir_lstat(dir)
+	if err != nil {
+		return false, err
+	}
 	for _, f := range fs {
-		if f.IsDir() {
-			continue
-		}
-		if f.ModTime().After(pt) {
-			// Source file is newer than package file; rebuild.
-			return build_package(p)
+		if !f.IsDir() && f.ModTime().After(t) {
+			return true, nil
 		}
 	}
-	return nil
+	return false, nil
 }
 
 // build_package builds the package by calling `go install package/import`. If everything compiles
 // correctly, the newly compiled package should then be in the usual place in the `$GOPATH/pkg`
 // directory, and gocode will pick it up from there.
-func build_package(p *build.Package) error {
+func build_package(p *build.Package, context *package_lookup_context) error {
 	if g_debug {
 		log.Printf("-------------------")
 		log.Printf("rebuilding package %s", p.Name)
@@ -221,19 +275,19 @@ func build_package(p *build.Package) error {
 		log.Printf("package object: %s", p.PkgObj)
 		log.Printf("package source dir: %s", p.Dir)
 		log.Printf("package source files: %v", p.GoFiles)
-		log.Printf("GOPATH: %v", gocodeDaemon.context.GOPATH)
-		log.Printf("GOROOT: %v", gocodeDaemon.context.GOROOT)
+		log.Printf("GOPATH: %v", context.GOPATH)
+		log.Printf("GOROOT: %v", context.GOROOT)
 	}
 	env := os.Environ()
 	for i, v := range env {
 		if strings.HasPrefix(v, "GOPATH=") {
-			env[i] = "GOPATH=" + gocodeDaemon.context.GOPATH
+			env[i] = "GOPATH=" + context.GOPATH
 		} else if strings.HasPrefix(v, "GOROOT=") {
-			env[i] = "GOROOT=" + gocodeDaemon.context.GOROOT
+			env[i] = "GOROOT=" + context.GOROOT
 		}
 	}
 
-	cmd := exec.Command("go", "install", p.ImportPath)
+	cmd := exec.CommandContext(context.request_context(), "go", "install", p.ImportPath)
 	cmd.Env = env
 
 	// TODO: Should read STDERR rather than STDOUT.
@@ -249,15 +303,81 @@ func build_package(p *build.Package) error {
 
 // executes autobuild function if autobuild option is enabled, logs error and
 // ignores it
-func try_autobuild(p *build.Package) {
-	if g_config.Autobuild() {
-		err := autobuild(p)
+func try_autobuild(p *build.Package, context *package_lookup_context) {
+	if context.config.Autobuild() {
+		err := autobuild(p, context)
 		if err != nil && g_debug {
 			log.Printf("Autobuild error: %s\n", err)
 		}
 	}
 }
 
+// module_export returns the export data file of module package imp, whose
+// sources are located in dir. Builds in module mode do not install package
+// archives, so if autobuild is enabled the package is compiled with
+// `go list -export` and the export data is read from the build cache.
+func module_export(imp, dir string, context *package_lookup_context) (string, bool) {
+	if !context.config.Autobuild() || context.modules == nil {
+		return "", false
+	}
+	if name, ok := context.modules.export(dir, context.dirs); ok {
+		return name, true
+	}
+	name, err := list_export(imp, context)
+	if err != nil {
+		if g_debug {
+			log.Printf("Autobuild error: %s\n", err)
+		}
+		return "", false
+	}
+	context.modules.set_export(dir, name)
+	return name, true
+}
+
+// list_export runs `go list -export` for package imp from the root of the
+// current module and returns the path of its export data.
+func list_export(imp string, context *package_lookup_context) (string, error) {
+	cmd := exec.CommandContext(context.request_context(), "go", "list", "-export", "-f", "{{.Export}}", "--", imp)
+	cmd.Dir = context.CurrentModule.root
+	cmd.Env = go_command_env(context)
+	out, err := cmd.Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) != 0 {
+			return "", fmt.Errorf("go list %s: %s", imp, bytes.TrimSpace(ee.Stderr))
+		}
+		return "", err
+	}
+	name := string(bytes.TrimSpace(out))
+	if name == "" {
+		return "", fmt.Errorf("go list %s: no export data", imp)
+	}
+	return name, nil
+}
+
+// go_command_env returns the environment for running the go command with
+// the GOPATH and GOROOT of the lookup context.
+func go_command_env(context *package_lookup_context) []string {
+	env := os.Environ()
+	n := 0
+	for _, v := range env {
+		if !strings.HasPrefix(v, "GOPATH=") && !strings.HasPrefix(v, "GOROOT=") {
+			env[n] = v
+			n++
+		}
+	}
+	env = env[:n]
+	if context.GOPATH != "" {
+		env = append(env, "GOPATH="+context.GOPATH)
+	}
+	if context.GOROOT != "" {
+		env = append(env, "GOROOT="+context.GOROOT)
+	}
+	if context.GOMODCACHE != "" {
+		env = append(env, "GOMODCACHE="+context.GOMODCACHE)
+	}
+	return env
+}
+
 func log_found_package_maybe(imp, pkgpath string) {
 	if g_debug {
 		log.Printf("Found %q at %q\n", imp, pkgpath)
@@ -271,7 +391,11 @@ func log_build_context(context *package_lookup_context) {
 	log.Printf(" GOARCH: %s\n", context.GOARCH)
 	log.Printf(" BzlProjectRoot: %q\n", context.BzlProjectRoot)
 	log.Printf(" GBProjectRoot: %q\n", context.GBProjectRoot)
-	log.Printf(" lib-path: %q\n", g_config.LibPath())
+	log.Printf(" GOMODCACHE: %q\n", context.GOMODCACHE)
+	if context.CurrentModule != nil {
+		log.Printf(" module: %q (%s)\n", context.CurrentModule.path, context.CurrentModule.root)
+	}
+	log.Printf(" lib-path: %q\n", context.config.LibPath())
 }
 
 // find_global_file returns the file path of the compiled package corresponding to the specified
@@ -288,10 +412,11 @@ func find_global_file(imp string, context *package_lookup_context) (string, bool
 	}
 
 	pkgfile := fmt.Sprintf("%s.a", imp)
+	source := context.config.SourceImporter()
 
 	// if lib-path is defined, use it
-	if g_config.LibPath() != "" {
-		for _, p := range filepath.SplitList(g_config.LibPath()) {
+	if context.config.LibPath() != "" && !source {
+		for _, p := range filepath.SplitList(context.config.LibPath()) {
 			pkg_path := filepath.Join(p, pkgfile)
 			if file_exists(pkg_path) {
 				log_found_package_maybe(imp, pkg_path)
@@ -351,6 +476,20 @@ func find_global_file(imp string, context *package_lookup_context) (string, bool
 	// 	}
 	// }
 
+	if m := context.CurrentModule; m != nil {
+		if dir, ok := m.package_dir(imp, context.GOMODCACHE); ok {
+			if !source {
+				if pkgobj, ok := module_export(imp, dir, context); ok {
+					log_found_package_maybe(imp, pkgobj)
+					return pkgobj, true
+				}
+			}
+			// load the package from source
+			log_found_package_maybe(imp, dir)
+			return dir, true
+		}
+	}
+
 	if context.CurrentPackagePath != "" {
 		// Try vendor path first, see GO15VENDOREXPERIMENT.
 		// We don't check this environment variable however, seems like there is
@@ -364,10 +503,9 @@ func find_global_file(imp string, context *package_lookup_context) (string, bool
 		for {
 			limp := filepath.Join(package_path, "vendor", imp)
 			if p, err := context.Import(limp, "", build.AllowBinary|build.FindOnly); err == nil {
-				try_autobuild(p)
-				if file_exists(p.PkgObj) {
-					log_found_package_maybe(imp, p.PkgObj)
-					return p.PkgObj, true
+				if pkgpath, ok := package_data_path(p, source, context); ok {
+					log_found_package_maybe(imp, pkgpath)
+					return pkgpath, true
 				}
 			}
 			if package_path == "" {
@@ -383,10 +521,9 @@ func find_global_file(imp string, context *package_lookup_context) (string, bool
 	}
 
 	if p, err := context.Import(imp, "", build.AllowBinary|build.FindOnly); err == nil {
-		try_autobuild(p)
-		if file_exists(p.PkgObj) {
-			log_found_package_maybe(imp, p.PkgObj)
-			return p.PkgObj, true
+		if pkgpath, ok := package_data_path(p, source, context); ok {
+			log_found_package_maybe(imp, pkgpath)
+			return pkgpath, true
 		}
 	}
 
@@ -398,6 +535,21 @@ func find_global_file(imp string, context *package_lookup_context) (string, bool
 	return "", false
 }
 
+// package_data_path returns the package object of p or, if it does not exist
+// or source is true, the directory containing the package source files.
+func package_data_path(p *build.Package, source bool, context *package_lookup_context) (string, bool) {
+	if !source {
+		try_autobuild(p, context)
+		if file_exists(p.PkgObj) {
+			return p.PkgObj, true
+		}
+	}
+	if p.Dir != "" && is_dir(p.Dir) {
+		return p.Dir, true
+	}
+	return "", false
+}
+
 func package_name(file *ast.File) string {
 	if file.Name != nil {
 		return file.Name.Name
@@ -416,6 +568,69 @@ type package_lookup_context struct {
 	BzlProjectRoot     string
 	GBProjectRoot      string
 	CurrentPackagePath string
+	CurrentModule      *go_module // module of the current package, if any
+	GOMODCACHE         string
+
+	// contents of unsaved files keyed by their absolute names, these are
+	// used instead of the files on disk
+	overlay map[string][]byte
+
+	// context of the request being processed, see Engine.run
+	ctx context.Context
+
+	// owned by the Engine using the context
+	config  *config
+	dirs    *DirCache
+	reader  *file_reader_type
+	modules *module_cache
+	index   *package_index
+}
+
+// request_context returns the context of the request being processed, which
+// stops the commands run for it once done.
+func (ctxt *package_lookup_context) request_context() context.Context {
+	if ctxt.ctx == nil {
+		return context.Background()
+	}
+	return ctxt.ctx
+}
+
+// parse_mode returns the mode for parsing the files of the current package
+// and the sources of imported packages, which have their comments parsed if
+// the docs are enabled.
+func (ctxt *package_lookup_context) parse_mode() parser.Mode {
+	if ctxt.config.Docs() {
+		return parser.ParseComments
+	}
+	return 0
+}
+
+// read_file returns the contents of file name, from the overlay if present.
+func (ctxt *package_lookup_context) read_file(name string) ([]byte, error) {
+	if data, ok := ctxt.overlay[name]; ok {
+		return data, nil
+	}
+	return ctxt.reader.read_file(name)
+}
+
+// set_current_package sets the current package and module from the directory
+// of the file being edited.
+func (ctxt *package_lookup_context) set_current_package(dir string) {
+	ctxt.CurrentPackagePath = ""
+	ctxt.CurrentModule = nil
+	if ctxt.modules != nil && modules_enabled() {
+		if m := ctxt.modules.find(dir); m != nil {
+			ctxt.CurrentModule = m
+			if importPath, ok := m.import_path(dir); ok {
+				ctxt.CurrentPackagePath = importPath
+				return
+			}
+		}
+	}
+	importPath, err := buildutil.ImportPath(&ctxt.Context, dir)
+	if err == nil {
+		ctxt.CurrentPackagePath = importPath
+	}
 }
 
 // gopath returns the list of Go path directories.
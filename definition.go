@@ -0,0 +1,140 @@
+package gocode
+
+import (
+	"context"
+	"go/scanner"
+	"go/token"
+	"unicode"
+	"unicode/utf8"
+)
+
+//-------------------------------------------------------------------------
+// definition
+//
+// Finds the declaration of the identifier at the cursor. The declarations
+// know their positions: local ones through the file sets of the parsed
+// files and imported ones through the positions of the export data.
+//-------------------------------------------------------------------------
+
+// Definition returns the position of the declaration of the identifier at
+// offset cursor of file name, the contents of which are file. The cursor
+// may be anywhere in the identifier, which is either a plain name or the
+// selector of a package member, a field or a method. It reports false if the
+// declaration or its position is unknown, like for the predeclared
+// identifiers. The column of the position is in bytes, and the offset is
+// only set for declarations in Go source files. See Complete for the engine
+// that is used.
+func (c *Config) Definition(file []byte, name string, cursor int) (token.Position, bool) {
+	return default_engine.definition(file, name, cursor, c)
+}
+
+// Definition is like Config.Definition, but uses the configuration of the
+// engine.
+func (e *Engine) Definition(file []byte, name string, cursor int) (token.Position, bool) {
+	return e.definition(file, name, cursor, nil)
+}
+
+func (e *Engine) definition(file []byte, name string, cursor int, conf *Config) (pos token.Position, ok bool) {
+	if cursor < 0 || cursor > len(file) {
+		return pos, false
+	}
+	e.run(context.Background(), name, conf, func() {
+		pos, ok = e.autocomplete.definition(file, name, cursor)
+	})
+	return pos, ok
+}
+
+func (c *auto_complete_context) definition(file []byte, filename string, cursor int) (token.Position, bool) {
+	d, _ := c.ident_decl(file, filename, cursor)
+	if d == nil {
+		return token.Position{}, false
+	}
+	pos := d.position()
+	return pos, pos.IsValid()
+}
+
+// ident_decl processes the file and returns the identifier at the cursor and
+// its declaration, if known.
+func (c *auto_complete_context) ident_decl(file []byte, filename string, cursor int) (*decl, string) {
+	// look at the whole identifier, as if the cursor was at its end
+	cursor = ident_end(file, cursor)
+	c.process(context.Background(), file, filename, cursor)
+	return c.cursor_ident_decl(file, cursor, func(cursor int) {
+		c.process(context.Background(), file, filename, cursor)
+	})
+}
+
+// cursor_ident_decl returns the identifier that ends at offset cursor of the
+// processed file and its declaration, if known. move processes the file
+// with the cursor at another offset, for the identifiers declared by the
+// statement at the cursor.
+func (c *auto_complete_context) cursor_ident_decl(file []byte, cursor int, move func(cursor int)) (*decl, string) {
+	iter := new_token_iterator(file, cursor)
+	if len(iter.tokens) == 0 {
+		return nil, ""
+	}
+	tok := iter.token()
+	if tok.tok != token.IDENT || tok.off+len(tok.lit) != cursor {
+		return nil, ""
+	}
+
+	if iter.go_back() && iter.token().tok == token.PERIOD {
+		// <expr>.<ident>
+		if x, _ := c.deduce_cursor_decl(&iter); x != nil {
+			return x.find_child_and_in_embedded(tok.lit), tok.lit
+		}
+		return nil, tok.lit
+	}
+	d := c.current.scope.lookup(tok.lit)
+	if d == nil {
+		// the identifier may be declared by the statement at the cursor
+		if end := stmt_end(file, tok.off); end != cursor {
+			move(end)
+			d = c.current.scope.lookup(tok.lit)
+		}
+	}
+	return d, tok.lit
+}
+
+// stmt_end returns the offset of the end of the statement at offset of src,
+// or that of the beginning of the block the statement starts, like the body
+// of a range statement.
+func stmt_end(src []byte, offset int) int {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src)-offset)
+	var s scanner.Scanner
+	s.Init(file, src[offset:], nil, 0)
+	depth := 0
+	for {
+		pos, tok, _ := s.Scan()
+		switch tok {
+		case token.EOF:
+			return len(src)
+		case token.LPAREN, token.LBRACK:
+			depth++
+		case token.RPAREN, token.RBRACK:
+			depth--
+		case token.SEMICOLON:
+			if depth <= 0 {
+				return offset + file.Offset(pos)
+			}
+		case token.LBRACE:
+			if depth <= 0 {
+				return offset + file.Offset(pos) + 1
+			}
+		}
+	}
+}
+
+// ident_end returns the offset of the end of the identifier of file at offset
+// cursor, or cursor if it is not in an identifier.
+func ident_end(file []byte, cursor int) int {
+	for cursor < len(file) {
+		r, size := utf8.DecodeRune(file[cursor:])
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			break
+		}
+		cursor += size
+	}
+	return cursor
+}
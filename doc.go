@@ -0,0 +1,200 @@
+package gocode
+
+import (
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+)
+
+//-------------------------------------------------------------------------
+// doc comments
+//
+// The declarations keep their doc comments if these are enabled (see
+// Config.Docs), in which case the files are parsed with their comments and
+// the docs of packages read from export data are read from their source
+// directories. Otherwise the doc comment of a declaration can be read from
+// the Go source file at its position.
+//-------------------------------------------------------------------------
+
+// ast_decl_doc returns the doc comment of d, a declaration split by
+// ast_decl_split.
+func ast_decl_doc(d ast.Decl) string {
+	switch t := d.(type) {
+	case *ast.FuncDecl:
+		return t.Doc.Text()
+	case *ast.GenDecl:
+		if len(t.Specs) == 1 {
+			return spec_doc(t, t.Specs[0]).Text()
+		}
+	}
+	return ""
+}
+
+// spec_doc returns the doc comment of spec of declaration d, which is that
+// of the declaration unless it is a group, or else the line comment.
+func spec_doc(d *ast.GenDecl, spec ast.Spec) *ast.CommentGroup {
+	var doc, comment *ast.CommentGroup
+	switch s := spec.(type) {
+	case *ast.TypeSpec:
+		doc, comment = s.Doc, s.Comment
+	case *ast.ValueSpec:
+		doc, comment = s.Doc, s.Comment
+	}
+	if doc == nil && !d.Lparen.IsValid() {
+		doc = d.Doc
+	}
+	if doc == nil {
+		doc = comment
+	}
+	return doc
+}
+
+// field_doc returns the doc comment of a field or an interface method, or
+// else its line comment.
+func field_doc(f *ast.Field) string {
+	if f.Doc != nil {
+		return f.Doc.Text()
+	}
+	return f.Comment.Text()
+}
+
+// set_field_docs sets the doc comments of the children of d, the fields or
+// the methods of struct or interface type typ.
+func set_field_docs(d *decl, typ ast.Expr) {
+	var fields *ast.FieldList
+	switch t := typ.(type) {
+	case *ast.StructType:
+		fields = t.Fields
+	case *ast.InterfaceType:
+		fields = t.Methods
+	}
+	if fields == nil {
+		return
+	}
+	for _, field := range fields.List {
+		for _, name := range field.Names {
+			if c, ok := d.children[name.Name]; ok && c.doc == "" {
+				c.doc = field_doc(field)
+			}
+		}
+	}
+}
+
+// add_docs sets the doc comments of the members of a package read from
+// export data, which has none, from the source files of the package.
+func (m *package_file_cache) add_docs() {
+	dir, ok := m.source_dir()
+	if !ok {
+		return
+	}
+	files, err := source_package_files(dir, m.context)
+	if err != nil {
+		return
+	}
+	fset := token.NewFileSet()
+	for _, name := range files {
+		data, err := m.context.read_file(name)
+		if err != nil {
+			continue
+		}
+		file, _ := parser.ParseFile(fset, name, data, parser.ParseComments|parser.SkipObjectResolution)
+		if file == nil {
+			continue
+		}
+		for _, decl := range file.Decls {
+			foreach_decl(decl, func(data *foreach_decl_struct) {
+				parent := m.main
+				if methodof := method_of(data.decl); methodof != "" {
+					parent = m.main.children[methodof]
+				}
+				if parent == nil {
+					return
+				}
+				for _, name := range data.names {
+					d, ok := parent.children[name.Name]
+					if !ok {
+						continue
+					}
+					d.doc = ast_decl_doc(data.decl)
+					if d.class == decl_type {
+						set_field_docs(d, data.typ)
+					}
+				}
+			})
+		}
+	}
+}
+
+// source_dir returns the source directory of the package, which is found
+// like the packages loaded from source are.
+func (m *package_file_cache) source_dir() (string, bool) {
+	if mod := m.context.CurrentModule; mod != nil {
+		if dir, ok := mod.package_dir(m.import_name, m.context.GOMODCACHE); ok {
+			return dir, true
+		}
+	}
+	p, err := m.context.Import(m.import_name, "", build.FindOnly)
+	if err != nil || p.Dir == "" {
+		return "", false
+	}
+	return p.Dir, true
+}
+
+// doc_comment returns the doc comment of the declaration, the field or the
+// method, the name of which is at pos of the Go source src. A column of 0
+// matches any name on the line, the positions of old export data formats have
+// no columns.
+func doc_comment(src []byte, pos token.Position) string {
+	fset := token.NewFileSet()
+	file, _ := parser.ParseFile(fset, "", src, parser.ParseComments|parser.SkipObjectResolution)
+	if file == nil {
+		return ""
+	}
+	at := func(names ...*ast.Ident) bool {
+		for _, name := range names {
+			p := fset.Position(name.Pos())
+			if p.Line == pos.Line && (pos.Column == 0 || p.Column == pos.Column) {
+				return true
+			}
+		}
+		return false
+	}
+
+	doc := ""
+	found := false
+	ast.Inspect(file, func(n ast.Node) bool {
+		if found || n == nil {
+			return false
+		}
+		// skip the nodes that end before the position
+		if fset.Position(n.End()).Line < pos.Line {
+			return false
+		}
+		switch t := n.(type) {
+		case *ast.FuncDecl:
+			if found = at(t.Name); found {
+				doc = t.Doc.Text()
+			}
+		case *ast.GenDecl:
+			for _, spec := range t.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					found = at(s.Name)
+				case *ast.ValueSpec:
+					found = at(s.Names...)
+				}
+				if found {
+					doc = spec_doc(t, spec).Text()
+					break
+				}
+			}
+		case *ast.Field:
+			if found = at(t.Names...); found {
+				doc = field_doc(t)
+			}
+		}
+		return !found
+	})
+	return doc
+}
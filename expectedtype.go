@@ -0,0 +1,405 @@
+package gocode
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+)
+
+//-------------------------------------------------------------------------
+// expected type
+//
+// Works out the type of the expression expected at the cursor: the right
+// side of an assignment, an argument of a call, a result of a return
+// statement or the value of a composite literal field. The candidates of
+// that type, or implementing it if it is an interface, are ranked first.
+//-------------------------------------------------------------------------
+
+// deduce_expected_type returns the type expected for the expression at
+// offset cursor of file and the scope of the type, if these are known.
+func (c *auto_complete_context) deduce_expected_type(file []byte, cursor int) (ast.Expr, *scope) {
+	iter := new_token_iterator(file, cursor)
+	if len(iter.tokens) == 0 {
+		return nil, nil
+	}
+
+	// move before the expression at the cursor: <partial> or <expr>.<partial>
+	if tok := iter.token(); tok.tok == token.IDENT && tok.off+len(tok.lit) >= cursor {
+		if !iter.go_back() {
+			return nil, nil
+		}
+	}
+	if iter.token().tok == token.PERIOD {
+		iter.extract_go_expr()
+	}
+
+	switch iter.token().tok {
+	case token.ASSIGN:
+		return c.expected_assign_type(file, &iter)
+	case token.COLON:
+		return c.expected_field_type(&iter)
+	case token.RETURN:
+		return c.expected_result_type(0)
+	case token.LPAREN, token.COMMA:
+		call := iter
+		if arg, ok := call.skip_to_call(); ok {
+			return c.expected_arg_type(&call, arg)
+		}
+		if index, ok := iter.skip_to_return(); ok {
+			return c.expected_result_type(index)
+		}
+	}
+	return nil, nil
+}
+
+// expected_assign_type returns the type of the left side of the assignment
+// or of the variable declaration at the '=' under the cursor of iter.
+func (c *auto_complete_context) expected_assign_type(file []byte, iter *token_iterator) (ast.Expr, *scope) {
+	assign := iter.token()
+	end := iter.token_index
+	spec := false
+loop:
+	for iter.go_back() {
+		switch iter.token().tok {
+		case token.RPAREN, token.RBRACK:
+			if !iter.skip_to_balanced_pair() {
+				return nil, nil
+			}
+		case token.VAR, token.CONST:
+			spec = true
+			break loop
+		case token.LPAREN:
+			// var ( a T = ...
+			lparen := iter.token_index
+			spec = iter.go_back() && (iter.token().tok == token.VAR || iter.token().tok == token.CONST)
+			iter.token_index = lparen
+			break loop
+		case token.SEMICOLON, token.LBRACE, token.RBRACE:
+			break loop
+		}
+	}
+	stmt := iter.tokens[iter.token_index+1 : end]
+	if len(stmt) == 0 {
+		return nil, nil
+	}
+
+	// skip the names of a specification, a, b T = ...
+	i := 0
+	if stmt[0].tok == token.IDENT {
+		i = 1
+		for i+1 < len(stmt) && stmt[i].tok == token.COMMA && stmt[i+1].tok == token.IDENT {
+			i += 2
+		}
+	}
+	if !spec && i < len(stmt) {
+		// the names of the other specifications of a group are followed
+		// by a type, unlike the operands of an assignment
+		switch stmt[i].tok {
+		case token.IDENT, token.MUL, token.MAP, token.CHAN, token.FUNC,
+			token.STRUCT, token.INTERFACE, token.ARROW:
+			spec = stmt[0].tok == token.IDENT
+		}
+	}
+
+	if spec {
+		if i >= len(stmt) {
+			// no type, var a = ...
+			return nil, nil
+		}
+		typ, err := parser.ParseExpr(string(file[stmt[i].off:assign.off]))
+		if err != nil {
+			return nil, nil
+		}
+		return typ, c.current.scope
+	}
+
+	for _, t := range stmt {
+		if t.tok == token.COMMA {
+			// a, b = ...
+			return nil, nil
+		}
+	}
+	expr, err := parser.ParseExpr(string(file[stmt[0].off:assign.off]))
+	if err != nil {
+		return nil, nil
+	}
+	t, scope, _ := infer_type(expr, c.current.scope, -1)
+	return t, scope
+}
+
+// expected_field_type returns the type of the field of the composite literal
+// the key of which is before the ':' under the cursor of iter.
+func (c *auto_complete_context) expected_field_type(iter *token_iterator) (ast.Expr, *scope) {
+	if !iter.go_back() || iter.token().tok != token.IDENT {
+		return nil, nil
+	}
+	name := iter.token().lit
+	if !iter.go_back() {
+		return nil, nil
+	}
+	if tok := iter.token().tok; tok != token.COMMA && tok != token.LBRACE {
+		return nil, nil
+	}
+	decl := c.deduce_struct_type_decl(iter)
+	if decl == nil {
+		return nil, nil
+	}
+	field := decl.find_child_and_in_embedded(name)
+	if field == nil || field.class != decl_var {
+		return nil, nil
+	}
+	return field.infer_type()
+}
+
+// expected_arg_type returns the type of parameter arg of the function called
+// at the '(' under the cursor of iter.
+func (c *auto_complete_context) expected_arg_type(iter *token_iterator, arg int) (ast.Expr, *scope) {
+	expr, err := parser.ParseExpr(iter.extract_go_expr())
+	if err != nil {
+		return nil, nil
+	}
+	ft, scope := func_type_of(expr, c.current.scope)
+	if ft == nil || ft.Params == nil {
+		return nil, nil
+	}
+
+	i := 0
+	for _, field := range ft.Params.List {
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+		if e, ok := field.Type.(*ast.Ellipsis); ok {
+			return e.Elt, scope
+		}
+		if arg < i+n {
+			return field.Type, scope
+		}
+		i += n
+	}
+	return nil, nil
+}
+
+// expected_result_type returns the type of result index of the function the
+// cursor is in.
+func (c *auto_complete_context) expected_result_type(index int) (ast.Expr, *scope) {
+	results := c.current.results
+	if results == nil {
+		return nil, nil
+	}
+	t := func_return_type(&ast.FuncType{Results: results}, index)
+	if t == nil {
+		return nil, nil
+	}
+	return t, c.current.results_scope
+}
+
+// func_type_of returns the type of function expression e, which is looked up
+// in scope s, if it is a function.
+func func_type_of(e ast.Expr, s *scope) (*ast.FuncType, *scope) {
+	t, scope, _ := infer_type(e, s, -1)
+	if t == nil {
+		return nil, nil
+	}
+	if _, ok := t.(*ast.FuncType); !ok {
+		// function-typed variable
+		t, scope = advance_to_type(func_predicate, t, scope)
+	}
+	ft, ok := t.(*ast.FuncType)
+	if !ok {
+		return nil, nil
+	}
+	return ft, scope
+}
+
+//-------------------------------------------------------------------------
+// assignability
+//-------------------------------------------------------------------------
+
+// value_type returns the type of the value of declaration d, which is the
+// result of a function returning a single value.
+func value_type(d *decl) (ast.Expr, *scope) {
+	switch d.class {
+	case decl_var, decl_const:
+		return d.infer_type()
+	case decl_func:
+		ft, ok := d.typ.(*ast.FuncType)
+		if !ok || ft.Results == nil || len(ft.Results.List) != 1 || len(ft.Results.List[0].Names) > 1 {
+			return nil, nil
+		}
+		return ft.Results.List[0].Type, d.scope
+	}
+	return nil, nil
+}
+
+// assignable reports whether a value of type t of scope ts can be assigned
+// to type to of scope tos: the types are identical, or to is an interface
+// the methods of which are in the method set of t.
+func assignable(t ast.Expr, ts *scope, to ast.Expr, tos *scope) bool {
+	if t == nil || to == nil {
+		return false
+	}
+	if identical_types(t, ts, to, tos) {
+		return true
+	}
+	iface := type_to_decl(to, tos)
+	if iface == nil {
+		return false
+	}
+	iface = advance_to_struct_or_interface(iface)
+	if iface == nil {
+		return false
+	}
+	if _, ok := iface.typ.(*ast.InterfaceType); !ok {
+		return false
+	}
+	ptr := false
+	if star, ok := t.(*ast.StarExpr); ok {
+		t, ptr = star.X, true
+	}
+	td := type_to_decl(t, ts)
+	if td == nil || td.class != decl_type {
+		return false
+	}
+	return implements(td, ptr, iface)
+}
+
+// implements reports whether type d, or *d if ptr, has the methods of
+// interface iface, the empty interface is not implemented as it tells nothing
+// about d. The methods with a pointer receiver are only in the method set of
+// *d.
+func implements(d *decl, ptr bool, iface *decl) bool {
+	methods := 0
+	ok := true
+	foreach_interface_method(iface, func(method *decl) {
+		methods++
+		m := d.find_child_and_in_embedded(method.name)
+		if m == nil || m.class != decl_func || !identical_signatures(m, method) {
+			ok = false
+		} else if !ptr && m.flags&decl_pointer_recv != 0 {
+			ok = false
+		}
+	})
+	return ok && methods != 0
+}
+
+// identical_signatures reports whether the functions a and b have identical
+// parameter and result types.
+func identical_signatures(a, b *decl) bool {
+	fa, ok := a.typ.(*ast.FuncType)
+	if !ok {
+		return false
+	}
+	fb, ok := b.typ.(*ast.FuncType)
+	if !ok {
+		return false
+	}
+	return identical_field_types(fa.Params, a.scope, fb.Params, b.scope) &&
+		identical_field_types(fa.Results, a.scope, fb.Results, b.scope)
+}
+
+// identical_field_types reports whether the types of the fields of a of
+// scope as and of b of scope bs are the same, one per name.
+func identical_field_types(a *ast.FieldList, as *scope, b *ast.FieldList, bs *scope) bool {
+	ta, tb := field_types(a), field_types(b)
+	if len(ta) != len(tb) {
+		return false
+	}
+	for i := range ta {
+		if !identical_types(ta[i], as, tb[i], bs) {
+			return false
+		}
+	}
+	return true
+}
+
+// field_types returns the type of every field of list, once per name.
+func field_types(list *ast.FieldList) []ast.Expr {
+	if list == nil {
+		return nil
+	}
+	var types []ast.Expr
+	for _, field := range list.List {
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			types = append(types, field.Type)
+		}
+	}
+	return types
+}
+
+// foreach_interface_method calls f with the methods of interface iface,
+// including those of its embedded interfaces.
+func foreach_interface_method(iface *decl, f func(m *decl)) {
+	if iface.is_visited() {
+		return
+	}
+	iface.set_visited()
+	defer iface.clear_visited()
+
+	for _, m := range iface.children {
+		if m.class == decl_func {
+			f(m)
+		}
+	}
+	for _, e := range iface.embedded {
+		if d := type_to_decl(e, iface.scope); d != nil {
+			if d = advance_to_struct_or_interface(d); d != nil {
+				foreach_interface_method(d, f)
+			}
+		}
+	}
+}
+
+// identical_types reports whether types a of scope as and b of scope bs are
+// the same, the named types of which are the same declarations.
+func identical_types(a ast.Expr, as *scope, b ast.Expr, bs *scope) bool {
+	switch x := a.(type) {
+	case *ast.Ident, *ast.SelectorExpr, *ast.IndexExpr, *ast.IndexListExpr:
+		switch b.(type) {
+		case *ast.Ident, *ast.SelectorExpr, *ast.IndexExpr, *ast.IndexListExpr:
+		default:
+			return false
+		}
+		da, db := type_to_decl(a, as), type_to_decl(b, bs)
+		if da == nil || db == nil {
+			return false
+		}
+		if da == db {
+			return true
+		}
+		// instantiations of generic types are new declarations
+		return da.name == db.name && da.scope != nil && db.scope != nil &&
+			da.scope.pkgname != "" && da.scope.pkgname == db.scope.pkgname
+	case *ast.StarExpr:
+		y, ok := b.(*ast.StarExpr)
+		return ok && identical_types(x.X, as, y.X, bs)
+	case *ast.ArrayType:
+		y, ok := b.(*ast.ArrayType)
+		return ok && (x.Len == nil) == (y.Len == nil) &&
+			get_array_len(x.Len) == get_array_len(y.Len) &&
+			identical_types(x.Elt, as, y.Elt, bs)
+	case *ast.MapType:
+		y, ok := b.(*ast.MapType)
+		return ok && identical_types(x.Key, as, y.Key, bs) &&
+			identical_types(x.Value, as, y.Value, bs)
+	case *ast.ChanType:
+		y, ok := b.(*ast.ChanType)
+		return ok && x.Dir == y.Dir && identical_types(x.Value, as, y.Value, bs)
+	case *ast.Ellipsis:
+		y, ok := b.(*ast.Ellipsis)
+		return ok && identical_types(x.Elt, as, y.Elt, bs)
+	case *ast.FuncType:
+		y, ok := b.(*ast.FuncType)
+		return ok && identical_field_types(x.Params, as, y.Params, bs) &&
+			identical_field_types(x.Results, as, y.Results, bs)
+	case *ast.InterfaceType:
+		// only the empty interfaces are compared
+		y, ok := b.(*ast.InterfaceType)
+		return ok && x.Methods.NumFields() == 0 && y.Methods.NumFields() == 0
+	}
+	return false
+}
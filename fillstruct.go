@@ -0,0 +1,259 @@
+package gocode
+
+import (
+	"bytes"
+	"go/ast"
+	"go/scanner"
+	"go/token"
+	"strings"
+)
+
+//-------------------------------------------------------------------------
+// struct literal fill-in
+//
+// Proposes the candidate filling in the fields of the struct literal at the
+// cursor that are not set yet with the zero values of their types, one field
+// per line (see Config.FillStructs).
+//-------------------------------------------------------------------------
+
+// get_fill_struct_candidate proposes the candidate setting the fields of
+// struct d, the literal of which the cursor is in, that are not set by the
+// literal. partial is the identifier that ends at the cursor, which is
+// replaced along with the spaces before it. The unexported fields of the
+// structs of other packages are skipped.
+func (c *auto_complete_context) get_fill_struct_candidate(file []byte, cursor int, partial string, d *decl, b *out_buffers) {
+	st, ok := d.typ.(*ast.StructType)
+	if !ok || st.Fields == nil {
+		return
+	}
+	start := cursor - len(partial)
+	iter := new_token_iterator(file, start)
+	if len(iter.tokens) == 0 || !iter.skip_to_left_curly() {
+		return
+	}
+	keys, ok := struct_literal_keys(file, iter.token().off, cursor)
+	if !ok {
+		// positional elements, which can't be mixed with keyed ones
+		return
+	}
+
+	var fields []string
+	var buf bytes.Buffer
+	add := func(name string, typ ast.Expr) {
+		if name == "_" || keys[name] {
+			return
+		}
+		if d.flags&decl_foreign != 0 && !ast.IsExported(name) {
+			return
+		}
+		buf.Reset()
+		buf.WriteString(name)
+		buf.WriteString(": ")
+		write_zero_value(&buf, typ, d.scope, b.canonical_aliases)
+		fields = append(fields, buf.String())
+	}
+	for _, f := range st.Fields.List {
+		if len(f.Names) == 0 {
+			add(get_type_path(f.Type).name, f.Type)
+			continue
+		}
+		for _, name := range f.Names {
+			add(name.Name, f.Type)
+		}
+	}
+	if len(fields) == 0 {
+		return
+	}
+
+	line := bytes.LastIndexByte(file[:start], '\n') + 1
+	indent := file[line:start]
+	indent = indent[:len(indent)-len(bytes.TrimLeft(indent, " \t"))]
+	var text string
+	if line+len(indent) == start {
+		// the literal is on several lines already and the cursor on a
+		// line of its own
+		text = strings.Join(fields, ",\n"+string(indent)) + ","
+	} else {
+		// Foo{A: 1, #} => Foo{A: 1,\n\tB: 0,\n}
+		for start > line && (file[start-1] == ' ' || file[start-1] == '\t') {
+			start--
+		}
+		inner := "\n" + string(indent) + "\t"
+		text = inner + strings.Join(fields, ","+inner) + ",\n" + string(indent)
+	}
+
+	buf.Reset()
+	pretty_print_type_expr(&buf, &ast.Ident{Name: d.name}, b.canonical_aliases)
+	b.candidates = append(b.candidates, candidate{
+		Name:    "fill",
+		Type:    buf.String(),
+		Class:   decl_fields,
+		Package: c.decl_package_import_path(d),
+		Edit: &TextEdit{
+			Start: new_position(file, start),
+			End:   new_position(file, cursor),
+			Text:  text,
+		},
+	})
+}
+
+// struct_literal_keys returns the keys of the elements of the composite
+// literal of file, the '{' of which is at offset lbrace, but the element at
+// offset cursor, which is being typed. It fails if the literal has elements
+// without keys.
+func struct_literal_keys(file []byte, lbrace, cursor int) (map[string]bool, bool) {
+	src := file[lbrace:]
+	cursor -= lbrace
+
+	var s scanner.Scanner
+	fset := token.NewFileSet()
+	s.Init(fset.AddFile("", fset.Base(), len(src)), src, nil, 0)
+
+	keys := make(map[string]bool)
+	positional := false
+	depth := 0
+	elem_start := 0 // offset of the current element
+	var first, second token_item
+	n := 0 // tokens of the current element
+	end_elem := func(off int) {
+		switch {
+		case n == 0:
+		case n > 1 && first.tok == token.IDENT && second.tok == token.COLON:
+			keys[first.lit] = true
+		case elem_start > cursor || off < cursor:
+			positional = true
+		}
+		n = 0
+		elem_start = off + 1
+	}
+	for {
+		pos, tok, lit := s.Scan()
+		off := fset.Position(pos).Offset
+		if tok == token.EOF {
+			end_elem(off)
+			break
+		}
+		switch tok {
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			depth--
+			if depth == 0 {
+				end_elem(off)
+				return keys, !positional
+			}
+		case token.COMMA:
+			if depth == 1 {
+				end_elem(off)
+				continue
+			}
+		case token.SEMICOLON:
+			if depth == 1 {
+				if lit == "\n" && elem_start <= cursor {
+					// inserted after the element being typed
+					continue
+				}
+				end_elem(off)
+				return keys, !positional
+			}
+		}
+		if depth > 0 {
+			switch n {
+			case 0:
+				first = token_item{off, tok, lit}
+			case 1:
+				second = token_item{off, tok, lit}
+			}
+			n++
+		}
+		switch tok {
+		case token.LPAREN, token.LBRACK, token.LBRACE:
+			depth++
+		}
+	}
+	return keys, !positional
+}
+
+// write_zero_value writes the zero value of type t of scope s: 0, "", false,
+// nil or T{} for the structs and arrays.
+func write_zero_value(out *bytes.Buffer, t ast.Expr, s *scope, canonical_aliases map[string]string) {
+	if id, ok := t.(*ast.Ident); ok {
+		if d := s.lookup(id.Name); d != nil && d.scope == g_universe_scope {
+			switch id.Name {
+			case "bool":
+				out.WriteString("false")
+			case "string":
+				out.WriteString(`""`)
+			case "any", "error":
+				out.WriteString("nil")
+			default:
+				out.WriteString("0")
+			}
+			return
+		}
+	}
+
+	switch t := t.(type) {
+	case *ast.Ident, *ast.SelectorExpr, *ast.IndexExpr, *ast.IndexListExpr:
+		d := type_to_decl(t, s)
+		if d == nil || d.class != decl_type || d.is_visited() {
+			// type parameter or unknown type
+			out.WriteString("*new(")
+			pretty_print_type_expr(out, t, canonical_aliases)
+			out.WriteByte(')')
+			return
+		}
+		if id, ok := t.(*ast.Ident); ok && strings.HasPrefix(id.Name, "$") {
+			// anonymous type
+			write_zero_value(out, d.typ, d.scope, canonical_aliases)
+			return
+		}
+		switch u := d.typ.(type) {
+		case *ast.StructType:
+		case *ast.ArrayType:
+			if u.Len == nil {
+				out.WriteString("nil")
+				return
+			}
+		default:
+			d.set_visited()
+			write_zero_value(out, d.typ, d.scope, canonical_aliases)
+			d.clear_visited()
+			return
+		}
+		pretty_print_type_expr(out, t, canonical_aliases)
+		out.WriteString("{}")
+	case *ast.ArrayType:
+		if t.Len == nil {
+			out.WriteString("nil")
+			return
+		}
+		pretty_print_type_expr(out, t, canonical_aliases)
+		out.WriteString("{}")
+	case *ast.StructType:
+		if t.Fields == nil || len(t.Fields.List) == 0 {
+			out.WriteString("struct{}{}")
+			return
+		}
+		// pretty_print_type_expr leaves the fields out
+		out.WriteString("struct{")
+		for i, f := range t.Fields.List {
+			if i > 0 {
+				out.WriteByte(';')
+			}
+			out.WriteByte(' ')
+			for j, name := range f.Names {
+				if j > 0 {
+					out.WriteString(", ")
+				}
+				out.WriteString(name.Name)
+			}
+			if len(f.Names) != 0 {
+				out.WriteByte(' ')
+			}
+			pretty_print_type_expr(out, f.Type, canonical_aliases)
+		}
+		out.WriteString(" }{}")
+	default:
+		// pointers, slices, maps, channels, functions and interfaces
+		out.WriteString("nil")
+	}
+}
@@ -42,7 +42,7 @@ func Stat(name string) (os.FileInfo, error) {
 	var sys syscall.Stat_t
 	err := syscall.Stat(name, &sys)
 	if err != nil {
-		return nil, &os.PathError{"stat", name, err}
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
 	}
 	var f fileStat
 	fillFileStatFromSys(&f, &sys, name)
@@ -57,7 +57,7 @@ func Lstat(name string) (os.FileInfo, error) {
 	var sys syscall.Stat_t
 	err := syscall.Lstat(name, &sys)
 	if err != nil {
-		return nil, &os.PathError{"lstat", name, err}
+		return nil, &os.PathError{Op: "lstat", Path: name, Err: err}
 	}
 	var f fileStat
 	fillFileStatFromSys(&f, &sys, name)
@@ -1,6 +1,6 @@
 module github.com/charlievieth/gocode
 
-go 1.15
+go 1.18
 
 require (
 	github.com/charlievieth/buildutil v0.0.3
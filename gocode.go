@@ -1,15 +1,17 @@
 package gocode
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"go/build"
+	"go/doc"
 	"log"
 	"os"
 	"path/filepath"
 	"runtime"
+	"runtime/debug"
 	"strings"
-	"sync"
-
-	"github.com/charlievieth/buildutil"
 )
 
 const g_debug = false
@@ -18,6 +20,36 @@ type Candidate struct {
 	Name  string `json:"name"`
 	Type  string `json:"type"`
 	Class string `json:"class"`
+
+	// Package is the import path of the package the candidate is a member
+	// of, if it is not declared by the current package.
+	Package string `json:"package,omitempty"`
+
+	// Score is the relevance of the candidate, the higher the better. It
+	// is the bonus of the candidates assignable to the type expected at
+	// the cursor, plus the score of the match if Config.Matcher is set.
+	// See Config.Matcher.
+	Score int `json:"score,omitempty"`
+
+	// AdditionalEdits are the edits of the file to apply along with the
+	// candidate, like the import of the package of the candidate.
+	AdditionalEdits []TextEdit `json:"additionalEdits,omitempty"`
+
+	// Edit, if set, is the edit of the file that applies the candidate,
+	// instead of the replacement of the span of the result by Name. It
+	// is set for the fill-in of struct literals, see Config.FillStructs.
+	Edit *TextEdit `json:"edit,omitempty"`
+
+	// Snippet is the call of a func candidate in the snippet syntax of
+	// the Language Server Protocol, with a placeholder for each argument:
+	// "Foo(${1:a int}, ${2:b ...string})". It is only set if
+	// Config.Snippets is.
+	Snippet string `json:"snippet,omitempty"`
+
+	// Doc is the doc comment of the declaration and Synopsis its first
+	// sentence, these are only set if Config.Docs is.
+	Doc      string `json:"doc,omitempty"`
+	Synopsis string `json:"synopsis,omitempty"`
 }
 
 func (c Candidate) String() string {
@@ -42,123 +74,459 @@ func (c Candidate) String() string {
 	}
 }
 
+// Request is a completion request.
+type Request struct {
+	Filename string // absolute name of the file
+	Data     []byte // contents of the file
+	Cursor   int    // byte offset of the cursor in Data
+}
+
+// Result is the result of a completion request.
+type Result struct {
+	Candidates []Candidate
+
+	// Start and End are the span of the file that a candidate replaces,
+	// the part of the identifier before the cursor.
+	Start, End Position
+
+	// Errors are the problems that did not prevent the completion, such
+	// as imports that could not be resolved or packages that could not be
+	// loaded. Candidates that depend on them are missing.
+	Errors []*Error
+}
+
+// Position is a position in a file.
+type Position struct {
+	Offset int // byte offset, starting at 0
+	Line   int // line number, starting at 1
+	Column int // column number in bytes, starting at 1
+	UTF16  int // UTF-16 code units from the start of the line, starting at 0
+}
+
+// new_position returns the position of byte offset in data.
+func new_position(data []byte, offset int) Position {
+	if offset > len(data) {
+		offset = len(data)
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	p := Position{Offset: offset, Line: 1}
+	line := bytes.LastIndexByte(data[:offset], '\n') + 1
+	p.Line += bytes.Count(data[:line], []byte{'\n'})
+	p.Column = offset - line + 1
+	for _, r := range string(data[line:offset]) {
+		if r >= 0x10000 {
+			p.UTF16 += 2 // surrogate pair
+		} else {
+			p.UTF16++
+		}
+	}
+	return p
+}
+
+// ErrorKind is the kind of an Error.
+type ErrorKind int
+
+const (
+	InternalError   ErrorKind = iota // gocode panicked
+	ParseError                       // the file could not be parsed
+	ImportError                      // an import could not be resolved
+	ExportDataError                  // the export data of a package is corrupt
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case InternalError:
+		return "internal error"
+	case ParseError:
+		return "parse error"
+	case ImportError:
+		return "import error"
+	case ExportDataError:
+		return "export data error"
+	}
+	return fmt.Sprintf("ErrorKind(%d)", int(k))
+}
+
+// Error is an error of a completion request.
+type Error struct {
+	Kind  ErrorKind
+	Path  string // file name or import path the error is about, if any
+	Err   error
+	Stack []byte // stack trace of an InternalError
+}
+
+func (e *Error) Error() string {
+	if e.Path == "" {
+		return "gocode: " + e.Kind.String() + ": " + e.Err.Error()
+	}
+	return "gocode: " + e.Kind.String() + ": " + e.Path + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// panic_error returns the error for the recovered panic value r. Values
+// other than an *Error are internal errors, the stack trace of which is
+// recorded, so panic_error must be called by the deferred function.
+func panic_error(r interface{}) *Error {
+	if e, ok := r.(*Error); ok {
+		return e
+	}
+	err, ok := r.(error)
+	if !ok {
+		err = fmt.Errorf("%v", r)
+	}
+	return &Error{Kind: InternalError, Err: err, Stack: debug.Stack()}
+}
+
 type Config struct {
 	GOROOT        string
 	GOPATH        string
+	GOMODCACHE    string // defaults to $GOMODCACHE or $GOPATH/pkg/mod
 	InstallSuffix string
 	AutoBuild     bool
 	Builtins      bool // propose builtin functions
+
+	// Source loads imported packages from their source files instead of
+	// compiled export data. Packages without export data (.a files) are
+	// always loaded from source.
+	Source bool
+
+	// Docs adds the doc comments of the declarations to the candidates.
+	// The comments of the files of the current package are parsed, and
+	// the doc comments of imported packages are read from their source
+	// directories, which makes loading packages slower.
+	Docs bool
+
+	// Overlay maps absolute file names to the contents of unsaved
+	// buffers, which are used instead of the files on disk. This applies
+	// to the other files of the current package and to the sources of
+	// packages loaded from source.
+	Overlay map[string][]byte
+
+	// Matcher matches the candidates against the partial identifier at
+	// the cursor, see PrefixMatcher, CamelCaseMatcher and FuzzyMatcher.
+	// If set, the score of the match plus a bonus for the declarations
+	// closest to the cursor, the local ones, then those of the package and
+	// then the imported packages, is added to the score of the candidates.
+	// Otherwise the candidates are matched by prefix, ignoring the case
+	// only if nothing else matches. The candidates are ranked by their
+	// score, which includes a bonus for those assignable to the type
+	// expected at the cursor, like the right side of an assignment or an
+	// argument of a call, and then sorted by class and name.
+	Matcher Matcher
+
+	// UnimportedPackages completes the packages that are not imported by
+	// the file, and their members. These are looked up by name in GOROOT,
+	// GOPATH and the module cache, which are scanned in the background the
+	// first time, until then only the packages found so far and the usual
+	// packages of the standard library are proposed. The candidates have
+	// the edit adding the import.
+	UnimportedPackages bool
+
+	// Snippets adds the snippets calling the func candidates to them, see
+	// Candidate.Snippet.
+	Snippets bool
+
+	// FillStructs proposes, in struct literals, the "fill" candidate of
+	// class "fields", the Edit of which sets the fields that are not set
+	// yet to the zero values of their types, one per line. The unexported
+	// fields of the structs of other packages are left out.
+	FillStructs bool
 }
 
-func (c *Config) Complete(file []byte, name string, cursor int) []Candidate {
-	if gocodeDaemon == nil {
-		gocodeDaemon = newDaemon()
-	}
-	return gocodeDaemon.complete(file, name, cursor, c)
+// Complete returns the completion candidates for offset cursor of file name,
+// the contents of which are file, and the span they replace. All calls share
+// one Engine, the caches of which are reset when the build context of the
+// Config differs from that of the previous call.
+func (c *Config) Complete(file []byte, name string, cursor int) Result {
+	return default_engine.complete(file, name, cursor, c)
+}
+
+// CompleteContext is like Complete, but reports errors and stops when ctx is
+// done. See Engine.CompleteContext.
+func (c *Config) CompleteContext(ctx context.Context, req Request) (Result, error) {
+	return default_engine.complete_context(ctx, req, c)
 }
 
-var gocodeDaemon = newDaemon()
+var default_engine = newEngine()
 
-type daemon struct {
+// Engine is a completion engine. It owns its package and declaration caches,
+// build context and configuration, so that engines with different
+// configurations do not interfere. An Engine is safe for concurrent use,
+// calls are serialized.
+type Engine struct {
 	autocomplete *auto_complete_context
 	declcache    *decl_cache
 	pkgcache     package_cache
 	context      package_lookup_context
-	mu           sync.Mutex
+	config       config
+	sem          chan struct{} // held while the engine is in use
+}
+
+// NewEngine returns an Engine using configuration conf, or the GOPATH and
+// GOROOT of the environment if conf is nil.
+func NewEngine(conf *Config) *Engine {
+	e := newEngine()
+	if conf != nil {
+		e.update(conf)
+	}
+	return e
 }
 
-func newDaemon() *daemon {
+func newEngine() *Engine {
 	ctxt := build.Default
 	ctxt.GOPATH = os.Getenv("GOPATH")
 	ctxt.GOROOT = runtime.GOROOT()
 	ctxt.IsDir = is_dir
-	d := daemon{
-		context:  package_lookup_context{Context: ctxt},
+	e := &Engine{
 		pkgcache: new_package_cache(),
+		sem:      make(chan struct{}, 1),
+	}
+	e.context = package_lookup_context{
+		Context:    ctxt,
+		GOMODCACHE: default_mod_cache(ctxt.GOPATH),
+		config:     &e.config,
+		dirs:       NewDirCache(),
+		reader:     new_file_reader(),
+		modules:    new_module_cache(),
+		index:      new_package_index(),
 	}
-	d.declcache = new_decl_cache(&d.context)
-	d.autocomplete = new_auto_complete_context(d.pkgcache, d.declcache)
-	return &d
+	e.declcache = new_decl_cache(&e.context)
+	e.autocomplete = new_auto_complete_context(e.pkgcache, e.declcache)
+	return e
+}
+
+// Complete is like Config.Complete, but uses the configuration of the engine.
+func (e *Engine) Complete(file []byte, name string, cursor int) Result {
+	return e.complete(file, name, cursor, nil)
 }
 
+// CompleteContext returns the completion candidates of req. It stops waiting
+// for other calls and abandons loading packages and files when ctx is done,
+// in which case the error of ctx is returned. Errors that prevent the
+// completion are returned as an *Error, as are the problems in Result.Errors
+// if there are no candidates.
+func (e *Engine) CompleteContext(ctx context.Context, req Request) (Result, error) {
+	return e.complete_context(ctx, req, nil)
+}
+
+// SetConfig changes the configuration of the engine. The caches of the engine
+// are reset if the build context changes.
+func (e *Engine) SetConfig(conf *Config) {
+	e.lock(context.Background())
+	defer e.unlock()
+	e.update(conf)
+}
+
+// lock acquires the engine, unless ctx is done first.
+func (e *Engine) lock(ctx context.Context) error {
+	select {
+	case e.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *Engine) unlock() { <-e.sem }
+
 var NoCandidates = []Candidate{}
 
-func (d *daemon) complete(file []byte, name string, cursor int, conf *Config) (res []Candidate) {
-	defer func() {
-		if e := recover(); e != nil {
-			if g_debug {
-				log.Printf("gocode: panic (%+v)\n", e)
+// complete updates the configuration of the engine to conf, if not nil, and
+// returns the completion candidates. Errors are ignored, in which case there
+// are NoCandidates.
+func (e *Engine) complete(file []byte, name string, cursor int, conf *Config) Result {
+	req := Request{Filename: name, Data: file, Cursor: cursor}
+	res, err := e.complete_context(context.Background(), req, conf)
+	if err != nil && g_debug {
+		log.Printf("gocode: %v\n", err)
+	}
+	if len(res.Candidates) == 0 {
+		res.Candidates = NoCandidates
+	}
+	return res
+}
+
+// complete_context updates the configuration of the engine to conf, if not
+// nil, and completes req.
+func (e *Engine) complete_context(ctx context.Context, req Request, conf *Config) (Result, error) {
+	var (
+		list    []candidate
+		partial int
+		res     Result
+	)
+	err := e.run(ctx, req.Filename, conf, func() {
+		list, partial = e.autocomplete.apropos(ctx, req.Data, req.Filename, req.Cursor)
+		res.Errors = e.autocomplete.current.errors
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	res.Start = new_position(req.Data, req.Cursor-partial)
+	res.End = new_position(req.Data, req.Cursor)
+	if len(list) == 0 {
+		if len(res.Errors) != 0 {
+			return res, res.Errors[0]
+		}
+		return res, nil
+	}
+	res.Candidates = make([]Candidate, len(list))
+	for i, c := range list {
+		res.Candidates[i] = new_candidate(c)
+		if c.Import != "" {
+			if edit, ok := import_edit(req.Data, c.Import); ok {
+				res.Candidates[i].AdditionalEdits = []TextEdit{edit}
 			}
-			if len(res) == 0 {
-				res = NoCandidates
+		}
+	}
+	return res, nil
+}
+
+// new_candidate converts candidate c to a Candidate.
+func new_candidate(c candidate) Candidate {
+	r := Candidate{
+		Name:    c.Name,
+		Type:    c.Type,
+		Class:   c.Class.String(),
+		Package: c.Package,
+		Score:   c.Score,
+		Snippet: c.Snippet,
+		Edit:    c.Edit,
+	}
+	if c.Doc != "" {
+		r.Doc = c.Doc
+		r.Synopsis = doc.Synopsis(c.Doc)
+	}
+	return r
+}
+
+// run acquires the engine, updates its configuration to conf, if not nil,
+// and calls f for file filename. Panics of f are returned as errors.
+func (e *Engine) run(ctx context.Context, filename string, conf *Config, f func()) (err error) {
+	if err := e.lock(ctx); err != nil {
+		return err
+	}
+	defer e.unlock()
+	defer func() {
+		if r := recover(); r != nil {
+			if c, ok := r.(cancelled); ok {
+				err = c.err
+				return
 			}
+			err = panic_error(r)
 		}
 	}()
-	d.mu.Lock()
-	defer d.mu.Unlock()
-	d.update(conf)
-	d.context.CurrentPackagePath = ""
-	importPath, err := buildutil.ImportPath(&d.context.Context, filepath.Dir(name))
-	if err == nil {
-		d.context.CurrentPackagePath = importPath
-	}
-	list, _ := d.autocomplete.apropos(file, name, cursor)
-	if list == nil || len(list) == 0 {
-		return NoCandidates
-	}
-	res = make([]Candidate, len(list))
-	for i, c := range list {
-		res[i] = Candidate{
-			Name:  c.Name,
-			Type:  c.Type,
-			Class: c.Class.String(),
-		}
+	if conf != nil {
+		e.update(conf)
 	}
-	return res
+	e.context.ctx = ctx
+	defer func() { e.context.ctx = nil }()
+	e.context.set_current_package(filepath.Dir(filename))
+	f()
+	return nil
+}
+
+func (e *Engine) update(conf *Config) {
+	e.config.SetProposeBuiltins(conf.Builtins)
+	e.config.SetAutoBuild(conf.AutoBuild)
+	e.config.SetMatcher(conf.Matcher)
+	e.config.SetUnimportedPackages(conf.UnimportedPackages)
+	e.config.SetSnippets(conf.Snippets)
+	e.config.SetFillStructs(conf.FillStructs)
+	e.context.overlay = conf.overlay()
+	if !e.same(conf) || e.config.SourceImporter() != conf.Source || e.config.Docs() != conf.Docs {
+		e.config.SetSourceImporter(conf.Source)
+		e.config.SetDocs(conf.Docs)
+		e.context.GOPATH = conf.GOPATH
+		e.context.GOROOT = conf.GOROOT
+		e.context.GOMODCACHE = conf.modCache()
+		e.context.InstallSuffix = conf.InstallSuffix
+		e.reset()
+
+		e.config.mu.Lock()
+		e.config.libPath = e.libPath()
+		e.config.mu.Unlock()
+	}
+}
+
+// Close stops the work the engine does in the background, which is building
+// the package index, see Config.UnimportedPackages. The engine can still be
+// used, but the packages that are not indexed by then are not completed,
+// until the caches are dropped.
+func (e *Engine) Close() {
+	e.lock(context.Background())
+	defer e.unlock()
+	e.context.index.close()
+}
+
+// DropCache drops the package, declaration and directory caches of the
+// engine.
+func (e *Engine) DropCache() {
+	e.lock(context.Background())
+	defer e.unlock()
+	e.context.dirs = NewDirCache()
+	e.reset()
 }
 
-func (d *daemon) update(conf *Config) {
-	g_config.SetProposeBuiltins(conf.Builtins)
-	g_config.SetAutoBuild(conf.AutoBuild)
-	if !d.same(conf) {
-		d.context.GOPATH = conf.GOPATH
-		d.context.GOROOT = conf.GOROOT
-		d.context.InstallSuffix = conf.InstallSuffix
-		d.pkgcache = new_package_cache()
-		d.declcache = new_decl_cache(&d.context)
-		d.autocomplete = new_auto_complete_context(d.pkgcache, d.declcache)
+func (e *Engine) reset() {
+	e.context.modules = new_module_cache()
+	if e.context.index != nil {
+		e.context.index.close()
+	}
+	e.context.index = new_package_index()
+	e.pkgcache = new_package_cache()
+	e.declcache = new_decl_cache(&e.context)
+	e.autocomplete = new_auto_complete_context(e.pkgcache, e.declcache)
+}
+
+func (e *Engine) same(conf *Config) bool {
+	return e.context.GOPATH == conf.GOPATH &&
+		e.context.GOROOT == conf.GOROOT &&
+		e.context.GOMODCACHE == conf.modCache() &&
+		e.context.InstallSuffix == conf.InstallSuffix
+}
 
-		g_config.libPath = d.libPath() // global config
-		g_config.proposeBuiltins = conf.Builtins
+// overlay returns the overlay keyed by clean file names.
+func (c *Config) overlay() map[string][]byte {
+	if len(c.Overlay) == 0 {
+		return nil
+	}
+	m := make(map[string][]byte, len(c.Overlay))
+	for name, data := range c.Overlay {
+		m[filepath.Clean(name)] = data
 	}
+	return m
 }
 
-func (d *daemon) same(conf *Config) bool {
-	return d.context.GOPATH == conf.GOPATH &&
-		d.context.GOROOT == conf.GOROOT &&
-		d.context.InstallSuffix == conf.InstallSuffix
+func (c *Config) modCache() string {
+	if c.GOMODCACHE != "" {
+		return c.GOMODCACHE
+	}
+	return default_mod_cache(c.GOPATH)
 }
 
 // libPath, returns the OS and Arch specific pkg paths for the current GOROOT
 // and GOPATH.
-func (d *daemon) libPath() string {
+func (e *Engine) libPath() string {
 	var all []string
-	pkg := d.pkgDir()
-	if d.context.GOROOT != "" {
-		all = append(all, filepath.Join(d.context.GOROOT, pkg))
+	pkg := e.pkgDir()
+	if e.context.GOROOT != "" {
+		all = append(all, filepath.Join(e.context.GOROOT, pkg))
 	}
-	if d.context.GOPATH != "" {
-		all = append(all, d.pkgpaths(pkg)...)
+	if e.context.GOPATH != "" {
+		all = append(all, e.pkgpaths(pkg)...)
 	}
 	return strings.Join(all, string(filepath.Separator))
 }
 
 // pkgpaths, returns all GOPATH pkg paths for Arch arch.
-func (d *daemon) pkgpaths(arch string) []string {
-	paths := filepath.SplitList(d.context.GOPATH)
+func (e *Engine) pkgpaths(arch string) []string {
+	paths := filepath.SplitList(e.context.GOPATH)
 	n := 0
 	for _, p := range paths {
-		if p != d.context.GOROOT && p != "" && p[0] != '~' {
+		if p != e.context.GOROOT && p != "" && p[0] != '~' {
 			paths[n] = filepath.Join(p, arch)
 			n++
 		}
@@ -167,12 +535,12 @@ func (d *daemon) pkgpaths(arch string) []string {
 }
 
 // osArch returns the os and arch specific package directory
-func (d *daemon) pkgDir() string {
+func (e *Engine) pkgDir() string {
 	var s string
-	if d.context.InstallSuffix == "" {
-		s = d.context.GOOS + "_" + d.context.GOARCH
+	if e.context.InstallSuffix == "" {
+		s = e.context.GOOS + "_" + e.context.GOARCH
 	} else {
-		s = d.context.GOOS + "_" + d.context.GOARCH + "_" + d.context.InstallSuffix
+		s = e.context.GOOS + "_" + e.context.GOARCH + "_" + e.context.InstallSuffix
 	}
 	return filepath.Join("pkg", s)
 }
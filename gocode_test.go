@@ -2,11 +2,13 @@ package gocode
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"strconv"
 	"strings"
@@ -46,6 +48,57 @@ func (t *TestConfig) SetGOPATH(s string) *Config {
 	return &c
 }
 
+// new_test_engine returns an engine with configuration conf, which is closed
+// when test t ends.
+func new_test_engine(t testing.TB, conf *Config) *Engine {
+	e := NewEngine(conf)
+	t.Cleanup(e.Close)
+	return e
+}
+
+// marked_test is file main.go of a temporary directory, the source of which
+// marks the cursors of a test with "#", and the engine the test uses.
+type marked_test struct {
+	*Engine
+	dir     string
+	name    string // dir/main.go
+	data    []byte // the source without the markers
+	cursors []int  // the offsets of the markers in data
+}
+
+// new_marked_test returns the marked_test of source src with an engine with
+// configuration conf. The files of others, by name, are written next to
+// main.go.
+func new_marked_test(t testing.TB, conf *Config, src string, others map[string]string) *marked_test {
+	m := &marked_test{Engine: new_test_engine(t, conf), dir: t.TempDir()}
+	m.name = m.file("main.go")
+	m.data, m.cursors = split_markers(src)
+	for name, data := range others {
+		if err := ioutil.WriteFile(m.file(name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return m
+}
+
+// file returns the path of file name of the directory of m.
+func (m *marked_test) file(name string) string {
+	return filepath.Join(m.dir, name)
+}
+
+// split_markers returns src without the "#" markers and the offsets of the
+// markers in it.
+func split_markers(src string) ([]byte, []int) {
+	parts := strings.Split(src, "#")
+	cursors := make([]int, 0, len(parts)-1)
+	off := 0
+	for _, p := range parts[:len(parts)-1] {
+		off += len(p)
+		cursors = append(cursors, off)
+	}
+	return []byte(strings.Join(parts, "")), cursors
+}
+
 func init() {
 	var err error
 	conf, err = newConfig()
@@ -143,9 +196,30 @@ func TestParallel_4(t *testing.T) {
 	}
 }
 
+// Engines do not share state and may be used concurrently.
+func TestEngines(t *testing.T) {
+	conf := testConf.Config()
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 2; i++ {
+		e := new_test_engine(t, conf)
+		for j := 0; j < 2; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for _, test := range tests {
+					if err := test.CheckEngine(e); err != nil {
+						t.Error(err)
+					}
+				}
+			}()
+		}
+	}
+	wg.Wait()
+}
+
 // Ensure complete does not panic!
 func TestCompleteRecover(t *testing.T) {
-	d := newDaemon()
+	d := newEngine()
 	defer func() {
 		if e := recover(); e != nil {
 			t.Fatalf("TestCompleteRecover panicked: %+v", e)
@@ -154,6 +228,209 @@ func TestCompleteRecover(t *testing.T) {
 	d.complete(nil, "", 0, nil)
 }
 
+func TestOverlay(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "main.go")
+	other := filepath.Join(dir, "other.go")
+	src := []byte("package main\n\nfunc main() {\n\tvar s Sibling\n\ts.\n}\n")
+	if err := ioutil.WriteFile(main, src, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(other, []byte("package main\n\ntype Sibling struct{ Old int }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	extra := filepath.Join(dir, "extra.go")
+
+	c := testConf.Config()
+	c.Overlay = map[string][]byte{
+		other: []byte("package main\n\ntype Sibling struct{ New int }\n"),
+		extra: []byte("package main\n\nfunc (Sibling) Extra() {}\n"),
+	}
+	var got []string
+	for _, c := range c.Complete(src, main, bytes.Index(src, []byte("s.\n"))+2).Candidates {
+		got = append(got, c.String())
+	}
+	want := []string{"func Extra()", "var New int"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("got %q want %q", got, want)
+	}
+}
+
+func TestOverlayImported(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":     "module example.com/m\n",
+		"lib/lib.go": "package lib\n\nfunc Old() {}\n",
+	}
+	for name, data := range files {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src := []byte("package main\n\nimport \"example.com/m/lib\"\n\nfunc main() {\n\tlib.\n}\n")
+
+	c := testConf.Config()
+	c.Source = true
+	c.Overlay = map[string][]byte{
+		filepath.Join(dir, "lib", "new.go"):      []byte("package lib\n\nfunc New() {}\n"),
+		filepath.Join(dir, "lib", "new_test.go"): []byte("package lib\n\nfunc Test() {}\n"),
+	}
+	var got []string
+	for _, c := range c.Complete(src, filepath.Join(dir, "main.go"), bytes.Index(src, []byte("lib.\n"))+4).Candidates {
+		got = append(got, c.String())
+	}
+	want := []string{"func New()", "func Old()"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("got %q want %q", got, want)
+	}
+}
+
+func TestCompleteReceiverTypeParams(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "a.go")
+	src := []byte("package main\n\nfunc (l *List[E]) First() {\n\tvar e E\n\te.\n}\n")
+
+	c := testConf.Config()
+	c.Overlay = map[string][]byte{
+		filepath.Join(dir, "b.go"): []byte("package main\n\ntype List[T interface{ Len() int }] struct{ items []T }\n"),
+	}
+	var got []string
+	for _, c := range c.Complete(src, main, bytes.Index(src, []byte("e.\n"))+2).Candidates {
+		got = append(got, c.String())
+	}
+	want := []string{"func Len() int"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("got %q want %q", got, want)
+	}
+}
+
+func TestCompleteContext(t *testing.T) {
+	e := new_test_engine(t, testConf.Config())
+	name := filepath.Join(t.TempDir(), "main.go")
+	complete := func(ctx context.Context, src string) (Result, error) {
+		data, cursors := split_markers(src)
+		return e.CompleteContext(ctx, Request{Filename: name, Data: data, Cursor: cursors[0]})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := complete(ctx, "package main\nfunc main() { # }\n"); err != context.Canceled {
+		t.Errorf("cancelled: got error %v want %v", err, context.Canceled)
+	}
+
+	tests := []struct {
+		src  string
+		kind ErrorKind
+		path string
+	}{
+		{"packge main\nfunc main() { # }\n", ParseError, name},
+		{"package main\nfunc main() { # }\nfunc f() {\n", ParseError, name},
+		{"package main\nimport \"example.com/missing\"\nfunc main() { missing.# }\n", ImportError, "example.com/missing"},
+	}
+	for _, x := range tests {
+		res, err := complete(context.Background(), x.src)
+		if err == nil && len(res.Errors) != 0 {
+			err = res.Errors[0]
+		}
+		var e *Error
+		if !errors.As(err, &e) || e.Kind != x.kind || e.Path != x.path {
+			t.Errorf("%q: got error %v want %s of %s", x.src, err, x.kind, x.path)
+		}
+	}
+
+	res, err := complete(context.Background(), "package main\nimport \"fmt\"\nfunc main() { fmt.Printl# }\n")
+	if err != nil || len(res.Candidates) != 1 || len(res.Errors) != 0 {
+		t.Errorf("fmt.Printl: got %v, %v, %v", res.Candidates, res.Errors, err)
+	}
+}
+
+func TestUpdatePackagesError(t *testing.T) {
+	e := new_test_engine(t, testConf.Config())
+	dir := t.TempDir()
+	bad := filepath.Join(dir, "bad.a")
+	if err := ioutil.WriteFile(bad, []byte("!<arch>\n__.PKGDEF\n$$B\ni\x00\x01"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing.a")
+	ps := map[string]*package_file_cache{
+		bad:     new_package_file_cache(bad, "example.com/bad", &e.context),
+		missing: new_package_file_cache(missing, "example.com/missing", &e.context),
+	}
+	// the error is reported until the archive changes
+	for i := 0; i < 2; i++ {
+		errs := update_packages(context.Background(), ps)
+		if len(errs) != 1 || errs[0].Kind != ExportDataError || errs[0].Path != "example.com/bad" {
+			t.Errorf("update %d: got errors %v want an export data error of example.com/bad", i, errs)
+		}
+	}
+}
+
+func TestCompleteRange(t *testing.T) {
+	src := "package main\n\nfunc main() {\n\tvar héllo int\n\t_ = \"😀é\" + hél\n}\n"
+	cursor := strings.Index(src, "hél\n") + len("hél")
+	res := new_test_engine(t, testConf.Config()).Complete([]byte(src), filepath.Join(t.TempDir(), "main.go"), cursor)
+	if len(res.Candidates) != 1 {
+		t.Fatalf("got candidates %v want var héllo", res.Candidates)
+	}
+	start := Position{Offset: cursor - len("hél"), Line: 5, Column: 17, UTF16: 13}
+	end := Position{Offset: cursor, Line: 5, Column: 21, UTF16: 16}
+	if res.Start != start || res.End != end {
+		t.Errorf("got range %+v-%+v want %+v-%+v", res.Start, res.End, start, end)
+	}
+}
+
+func TestSignatureHelp(t *testing.T) {
+	const src = `package main
+
+import "strings"
+
+type T struct{ cb func(n int) bool }
+
+func Map[E, R any](s []E, f func(E) R) []R { return nil }
+
+func main() {
+	var b strings.Builder
+	var t T
+	f := func(x int, y ...string) {}
+	#
+}
+`
+	tests := []struct {
+		call   string
+		name   string
+		typ    string
+		params []string
+		active int
+	}{
+		{"strings.Split(s, #", "Split", "func(s string, sep string) []string", []string{"s string", "sep string"}, 1},
+		{"b.WriteString(#", "WriteString", "func(s string) (int, error)", []string{"s string"}, 0},
+		{"f(1, \"a\", g(2, 3), #", "f", "func(x int, y ...string)", []string{"x int", "y ...string"}, 1},
+		{"t.cb(#", "cb", "func(n int) bool", []string{"n int"}, 0},
+		{"make([]int, #", "make", "func(type, len[, cap]) type", []string{"type", "len[, cap]"}, 1},
+		{"Map([]int{1, 2}, #", "Map", "func[E, R any](s []E, f func(E) R) []R", []string{"s []E", "f func(E) R"}, 1},
+	}
+	e := new_test_engine(t, testConf.Config())
+	name := filepath.Join(t.TempDir(), "main.go")
+	for _, x := range tests {
+		data, cursors := split_markers(strings.Replace(src, "#", x.call, 1))
+		sig, ok := e.SignatureHelp(data, name, cursors[0])
+		want := Signature{Name: x.name, Type: x.typ, Params: x.params, Active: x.active}
+		if !ok || fmt.Sprint(sig) != fmt.Sprint(want) {
+			t.Errorf("%s: got %+v, %t want %+v", x.call, sig, ok, want)
+		}
+	}
+
+	data, cursors := split_markers(src)
+	if sig, ok := e.SignatureHelp(data, name, cursors[0]); ok {
+		t.Errorf("outside of a call: got %+v", sig)
+	}
+}
+
 func BenchmarkOne(b *testing.B) {
 	t := tests[0]
 	for i := 0; i < b.N; i++ {
@@ -234,11 +511,23 @@ func (t Test) Check(conf *Config) error {
 	if conf == nil {
 		return errors.New("Check: nil Config")
 	}
-	fn := filepath.Base(filepath.Dir(t.Name))
-	cs := conf.Complete(t.File, t.Name, t.Cursor)
+	cs := conf.Complete(t.File, t.Name, t.Cursor).Candidates
 	if cs == nil {
 		return fmt.Errorf("Check: nil Candidates (%+v)", conf)
 	}
+	return t.check(cs)
+}
+
+func (t Test) CheckEngine(e *Engine) error {
+	cs := e.Complete(t.File, t.Name, t.Cursor).Candidates
+	if cs == nil {
+		return errors.New("CheckEngine: nil Candidates")
+	}
+	return t.check(cs)
+}
+
+func (t Test) check(cs []Candidate) error {
+	fn := filepath.Base(filepath.Dir(t.Name))
 	if len(cs) != len(t.Result) {
 		return t.Expected(fmt.Errorf("count: expected %d got %d: %s", len(t.Result), len(cs), fn))
 	}
@@ -350,3 +639,844 @@ func newConfig() (*Config, error) {
 	}
 	return &c, nil
 }
+
+func TestDefinition(t *testing.T) {
+	const src = `package main
+
+import "strings"
+
+type T struct{ n int }
+
+func (t T) Get() int { return t.n }
+
+func main() {
+	var t T
+	x := t.Get()
+	var o Other
+	o.Field = strings.ToUpper("a")
+	println(x, len(o.Field))
+}
+`
+	m := new_marked_test(t, testConf.Config(), src, map[string]string{
+		"other.go": "package main\n\ntype Other struct {\n\tField string\n}\n",
+	})
+	name, other := m.name, m.file("other.go")
+
+	tests := []struct {
+		at     string // the cursor is after the first occurrence of at
+		file   string
+		line   int
+		column int
+	}{
+		{"var t T", name, 5, 6},
+		{"t.Ge", name, 7, 12},
+		{"x, le", "", 0, 0}, // predeclared
+		{"println(x", name, 11, 2},
+		{"var o", name, 12, 6},
+		{"var o Oth", other, 3, 6},
+		{"o.F", other, 4, 2},
+		{"t.n", name, 5, 16},
+	}
+	for _, x := range tests {
+		cursor := strings.Index(src, x.at) + len(x.at)
+		pos, ok := m.Definition(m.data, name, cursor)
+		if ok != (x.file != "") || pos.Filename != x.file || pos.Line != x.line || pos.Column != x.column {
+			t.Errorf("%s: got %v, %t want %s:%d:%d", x.at, pos, ok, x.file, x.line, x.column)
+		}
+	}
+
+	// imported package
+	cursor := strings.Index(src, "ToUpper")
+	pos, ok := m.Definition(m.data, name, cursor)
+	if !ok || filepath.Base(pos.Filename) != "strings.go" || pos.Line == 0 {
+		t.Errorf("strings.ToUpper: got %v, %t", pos, ok)
+	}
+}
+
+func TestTypeAt(t *testing.T) {
+	const src = `package main
+
+import "bytes"
+
+// T is a test type.
+type T struct {
+	// n is a counter.
+	n int
+}
+
+// Get returns the counter.
+func (t *T) Get() int { return t.n }
+
+func main() {
+	var b bytes.Buffer
+	t := &T{}
+	x := t.Get()
+	b.WriteString("a")
+	println(x, b.Len())
+}
+`
+	tests := []struct {
+		at   string // the cursor is after the first occurrence of at
+		want TypeInfo
+	}{
+		{"\tt", TypeInfo{Name: "t", Class: "var", Type: "*T"}},
+		{"println(x", TypeInfo{Name: "x", Class: "var", Type: "int"}},
+		{"print", TypeInfo{Name: "println", Class: "func", Type: "func(...interface{})"}},
+		{"t.Ge", TypeInfo{Name: "Get", Class: "func", Type: "func() int", Doc: "Get returns the counter.\n"}},
+		{"t.n", TypeInfo{Name: "n", Class: "var", Type: "int", Doc: "n is a counter.\n"}},
+		{"&T", TypeInfo{Name: "T", Class: "type", Type: "struct", Doc: "T is a test type.\n"}},
+		{"var b by", TypeInfo{Name: "bytes", Class: "package", Package: "bytes"}},
+		{"b.L", TypeInfo{Name: "Len", Class: "func", Type: "func() int", Package: "bytes"}},
+	}
+	e := new_test_engine(t, testConf.Config())
+	name := filepath.Join(t.TempDir(), "main.go")
+	for _, x := range tests {
+		cursor := strings.Index(src, x.at) + len(x.at)
+		ti, ok := e.TypeAt([]byte(src), name, cursor)
+		if x.want.Package == "" {
+			// the import path of the temporary directory varies
+			ti.Package = ""
+		}
+		if x.want.Package == "bytes" && x.want.Class == "func" {
+			// the doc comment of the standard library may change
+			if !strings.HasPrefix(ti.Doc, "Len returns") {
+				t.Errorf("%s: got doc %q", x.at, ti.Doc)
+			}
+			ti.Doc = ""
+		}
+		if !ok || ti != x.want {
+			t.Errorf("%s: got %+v, %t want %+v", x.at, ti, ok, x.want)
+		}
+	}
+
+	cursor := strings.Index(src, `"a"`)
+	if ti, ok := e.TypeAt([]byte(src), name, cursor); ok {
+		t.Errorf("string literal: got %+v", ti)
+	}
+}
+
+func TestCompleteDocs(t *testing.T) {
+	const src = `package main
+
+import "strings"
+
+// Counter counts. It is not safe for concurrent use.
+type Counter struct {
+	N int // number of calls
+}
+
+func main() {
+	var c Counter
+	c.N = len(strings.ToUpper("a"))
+}
+`
+	docs := func(conf *Config, at string) map[string]Candidate {
+		cursor := strings.Index(src, at) + len(at)
+		name := filepath.Join(t.TempDir(), "main.go")
+		m := make(map[string]Candidate)
+		for _, c := range new_test_engine(t, conf).Complete([]byte(src), name, cursor).Candidates {
+			m[c.Name] = c
+		}
+		return m
+	}
+
+	for _, source := range []bool{false, true} {
+		conf := testConf.Config()
+		conf.Source = source
+		if c := docs(conf, "strings.ToUp")["ToUpper"]; c.Doc != "" {
+			t.Errorf("source=%t: docs are disabled, got %q", source, c.Doc)
+		}
+
+		conf.Docs = true
+		c := docs(conf, "strings.ToUp")["ToUpper"]
+		if !strings.HasPrefix(c.Doc, "ToUpper returns s with all Unicode letters mapped to their upper case") ||
+			!strings.HasPrefix(c.Synopsis, "ToUpper returns") || strings.Contains(c.Synopsis, "\n") {
+			t.Errorf("source=%t: strings.ToUpper: got doc %q, synopsis %q", source, c.Doc, c.Synopsis)
+		}
+		if c := docs(conf, "c.")["N"]; c.Doc != "number of calls\n" {
+			t.Errorf("source=%t: Counter.N: got %q", source, c.Doc)
+		}
+		if c := docs(conf, "var c Counter")["Counter"]; c.Synopsis != "Counter counts." {
+			t.Errorf("source=%t: Counter: got %+v", source, c)
+		}
+	}
+}
+
+func TestMatcher(t *testing.T) {
+	tests := []struct {
+		matcher Matcher
+		name    string
+		partial string
+		ok      bool
+	}{
+		{PrefixMatcher, "Println", "Pr", true},
+		{PrefixMatcher, "Println", "pr", true},
+		{PrefixMatcher, "Println", "pf", false},
+		{PrefixMatcher, "NewReadWriter", "NRW", false},
+		{CamelCaseMatcher, "NewReadWriter", "NRW", true},
+		{CamelCaseMatcher, "NewReadWriter", "nrw", true},
+		{CamelCaseMatcher, "NewReadWriter", "NewRW", true},
+		{CamelCaseMatcher, "NewReadWriter", "NRdW", false},
+		{CamelCaseMatcher, "HTTPServer", "HS", true},
+		{CamelCaseMatcher, "read_all", "rA", true},
+		{CamelCaseMatcher, "Printf", "pf", false},
+		{FuzzyMatcher, "Printf", "pf", true},
+		{FuzzyMatcher, "Printf", "fp", false},
+	}
+	for _, test := range tests {
+		score, ok := test.matcher.Match(test.name, test.partial)
+		if ok != test.ok || ok && (score <= 0 || score > MaxMatchScore) {
+			t.Errorf("%T.Match(%q, %q) = %d, %t", test.matcher, test.name, test.partial, score, ok)
+		}
+	}
+
+	// exact > prefix > camel-case > fuzzy
+	order := [][2]string{
+		{"Print", "Print"},
+		{"Println", "Print"},
+		{"Println", "print"},
+		{"NewReadWriter", "NRW"},
+		{"NewReader", "nrd"},
+	}
+	prev := MaxMatchScore + 1
+	for _, o := range order {
+		score, _ := FuzzyMatcher.Match(o[0], o[1])
+		if score >= prev {
+			t.Errorf("Match(%q, %q) = %d, want less than %d", o[0], o[1], score, prev)
+		}
+		prev = score
+	}
+}
+
+func TestCompleteRanked(t *testing.T) {
+	const src = `package main
+
+import "fmt"
+
+var printed int
+
+func main() {
+	prefix := 1
+	fmt.Println(prefix, printed)
+	_ = pr
+	fmt.pf
+}
+`
+	complete := func(conf *Config, at string) []Candidate {
+		cursor := strings.Index(src, at) + len(at)
+		name := filepath.Join(t.TempDir(), "main.go")
+		return new_test_engine(t, conf).Complete([]byte(src), name, cursor).Candidates
+	}
+
+	conf := testConf.Config()
+	if c := complete(conf, "fmt.pf"); len(c) != 0 {
+		t.Errorf("prefix matching: got %v", c)
+	}
+
+	conf.Builtins = true
+	conf.Matcher = FuzzyMatcher
+	c := complete(conf, "fmt.pf")
+	if len(c) == 0 || c[0].Name != "Printf" && c[0].Name != "Sprintf" && c[0].Name != "Fprintf" {
+		t.Fatalf("fuzzy matching: got %v", c)
+	}
+	for i := 1; i < len(c); i++ {
+		if c[i].Score > c[i-1].Score {
+			t.Errorf("candidates are not ranked: %v", c)
+			break
+		}
+	}
+
+	// locals, then the package, then the universe
+	var names []string
+	for _, c := range complete(conf, "_ = pr") {
+		names = append(names, c.Name)
+	}
+	if len(names) < 3 || names[0] != "prefix" || names[1] != "printed" {
+		t.Errorf("got %v, want prefix and printed first", names)
+	}
+}
+
+func TestCompleteExpectedType(t *testing.T) {
+	const src = `package main
+
+import "io"
+
+type sink struct{}
+
+func (sink) Write(p []byte) (int, error) { return len(p), nil }
+
+type buf struct{}
+
+func (*buf) Write(p []byte) (int, error) { return len(p), nil }
+
+type bad struct{}
+
+func (bad) Write(s string) {}
+
+type opts struct {
+	Name string
+	Out  io.Writer
+}
+
+func use(n int, s string) {}
+
+func lookup() (int, error) {
+	var count int
+	var label string
+	var snk sink
+	var fail error
+	var w io.Writer = snk
+	var abuf buf
+	var abad bad
+	var pbuf *buf
+	var w2 io.Writer = pbuf
+	count = len(label)
+	use(count, label)
+	_ = opts{Name: label, Out: w}
+	return count, fail
+}
+`
+	tests := []struct {
+		at   string // the cursor is at the end of at
+		want string
+	}{
+		{"var w io.Writer = ", "snk"},
+		{"var w2 io.Writer = ", "pbuf"}, // ahead of abuf and abad by name
+		{"count = ", "count"},
+		{"use(count, ", "label"},
+		{"\tuse(", "count"},
+		{"opts{Name: ", "label"},
+		{"Out: ", "pbuf"}, // ahead of snk and w by name
+		{"return count, ", "fail"},
+		{"\treturn ", "count"},
+	}
+	name := filepath.Join(t.TempDir(), "main.go")
+	for _, matcher := range []Matcher{nil, PrefixMatcher} {
+		conf := testConf.Config()
+		conf.Matcher = matcher
+		e := new_test_engine(t, conf)
+		for _, test := range tests {
+			cursor := strings.Index(src, test.at) + len(test.at)
+			// the source up to the cursor, as if the rest was not typed yet
+			data := src[:cursor] + "\n}\n"
+			c := e.Complete([]byte(data), name, cursor).Candidates
+			if len(c) == 0 || c[0].Name != test.want {
+				t.Errorf("%q (matcher %v): got %v, want %s first", test.at, matcher != nil, c, test.want)
+			}
+		}
+	}
+}
+
+func TestImportEdit(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{
+			"package main\n\nfunc main() {}\n",
+			"package main\n\nimport \"strings\"\n\nfunc main() {}\n",
+		},
+		{
+			"package main\n\nimport \"fmt\"\n\nfunc main() {}\n",
+			"package main\n\nimport \"fmt\"\nimport \"strings\"\n\nfunc main() {}\n",
+		},
+		{
+			"package main\n\nimport (\n\t\"fmt\"\n\t\"os\"\n)\n",
+			"package main\n\nimport (\n\t\"fmt\"\n\t\"os\"\n\t\"strings\"\n)\n",
+		},
+		{
+			"package main\n\nimport (\n\t\"bytes\"\n\t\"unicode\"\n)\n",
+			"package main\n\nimport (\n\t\"bytes\"\n\t\"strings\"\n\t\"unicode\"\n)\n",
+		},
+	}
+	for _, test := range tests {
+		e, ok := import_edit([]byte(test.src), "strings")
+		if !ok || e.Start != e.End {
+			t.Errorf("%q: got %+v, %t", test.src, e, ok)
+			continue
+		}
+		if got := test.src[:e.Start.Offset] + e.Text + test.src[e.End.Offset:]; got != test.want {
+			t.Errorf("%q: got %q want %q", test.src, got, test.want)
+		}
+	}
+}
+
+func TestCompleteUnimported(t *testing.T) {
+	const src = `package main
+
+func main() {
+	strings.ToUp
+	templ
+}
+`
+	complete := func(conf *Config, at string) []Candidate {
+		cursor := strings.Index(src, at) + len(at)
+		name := filepath.Join(t.TempDir(), "main.go")
+		return new_test_engine(t, conf).Complete([]byte(src), name, cursor).Candidates
+	}
+	want := TextEdit{Text: "\n\nimport \"strings\""}
+	want.Start = new_position([]byte(src), len("package main"))
+	want.End = want.Start
+
+	conf := testConf.Config()
+	if c := complete(conf, "strings.ToUp"); len(c) != 0 {
+		t.Errorf("unimported packages are disabled, got %v", c)
+	}
+
+	conf.UnimportedPackages = true
+	c := complete(conf, "strings.ToUp")
+	if len(c) == 0 || c[0].Name != "ToUpper" {
+		t.Errorf("got %+v", c)
+	}
+	for _, c := range c {
+		if len(c.AdditionalEdits) != 1 || c.AdditionalEdits[0] != want {
+			t.Errorf("%s: got edits %+v want %+v", c.Name, c.AdditionalEdits, want)
+		}
+	}
+
+	// html/template is preferred to text/template
+	c = complete(conf, "templ")
+	i := 0
+	for i < len(c) && c[i].Name != "template" {
+		i++
+	}
+	if i == len(c) || len(c[i].AdditionalEdits) != 1 || c[i].AdditionalEdits[0].Text != "\n\nimport \"html/template\"" {
+		t.Errorf("got %+v", c)
+	}
+}
+
+func TestPackageIndex(t *testing.T) {
+	modcache := t.TempDir()
+	files := map[string]string{
+		"example.com/a@v1.9.0/old/old.go":            "package old\n",
+		"example.com/a@v1.10.0/a.go":                 "package a\n",
+		"example.com/a@v1.10.0/cur/cur.go":           "package cur\n",
+		"example.com/a@v1.10.0/nested/go.mod":        "module example.com/a/nested\n",
+		"example.com/a@v1.10.0/nested/nested.go":     "package nested\n",
+		"example.com/!upper@v0.1.0-pre/upper.go":     "package upper\n",
+		"cache/download/example.com/b/@v/b.go":       "package b\n",
+		"example.com/a@v1.10.0/internal/internal.go": "package internal\n",
+	}
+	for name, data := range files {
+		name = filepath.Join(modcache, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctxt := &package_lookup_context{GOMODCACHE: modcache}
+	x := new_package_index()
+	x.start(ctxt)
+	<-x.done
+	tests := map[string][]string{
+		"a":        {"example.com/a"},
+		"cur":      {"example.com/a/cur"},
+		"upper":    {"example.com/Upper"},
+		"old":      {}, // only in the lower version
+		"nested":   {}, // other module
+		"b":        {},
+		"internal": {},
+	}
+	for name, want := range tests {
+		if got := x.lookup(name, ctxt); strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("%s: got %q want %q", name, got, want)
+		}
+	}
+	x.close()
+
+	// a closed index is not built
+	x = new_package_index()
+	x.close()
+	if got := x.lookup("cur", ctxt); len(got) != 0 {
+		t.Errorf("closed index: got %q", got)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"v1.10.0", "v1.9.0", 1},
+		{"v1.9.0", "v1.10.0", -1},
+		{"v1.2.3", "v1.2.3", 0},
+		{"v2.0.0+incompatible", "v1.9.9", 1},
+		{"v1.0.0", "v1.0.0-rc.1", 1},
+		{"v1.0.0-rc.2", "v1.0.0-rc.10", -1},
+		{"v1.0.0-alpha", "v1.0.0-alpha.1", -1},
+		{"v1.0.0-1", "v1.0.0-alpha", -1},
+		{"v0.0.0-20200121045136-8c9f03a8e57e", "v0.0.0-20190101000000-aaaaaaaaaaaa", 1},
+		{"v1.0.0", "bogus", 1},
+	}
+	for _, test := range tests {
+		if got := compare_versions(test.a, test.b); got != test.want {
+			t.Errorf("compare_versions(%q, %q): got %d want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestCompleteKeywords(t *testing.T) {
+	tests := []struct {
+		src  string // # is the cursor
+		want []string
+		not  []string
+	}{
+		{"package main\n\nfu#", []string{"func"}, nil},
+		{"package main\n\nfunc main() {\n\tde#\n}\n", []string{"defer"}, []string{"default"}},
+		{"package main\n\nfunc main() {\n\tfor {\n\t\tif true {\n\t\t\tco#\n\t\t}\n\t}\n}\n", []string{"continue", "const"}, nil},
+		{"package main\n\nfunc main() {\n\tco#\n}\n", []string{"const"}, []string{"continue"}},
+		{"package main\n\nfunc main() {\n\tfor {\n\t\tfunc() {\n\t\t\tbr#\n\t\t}()\n\t}\n}\n", nil, []string{"break"}},
+		{"package main\n\nfunc main() {\n\tswitch {\n\tcase true:\n\t\tf#\n\tdefault:\n\t}\n}\n", []string{"fallthrough", "for"}, nil},
+		{"package main\n\nfunc main() {\n\tswitch {\n\tcase true:\n\t\tf#\n\t}\n}\n", []string{"for"}, []string{"fallthrough"}},
+		{"package main\n\nfunc main() {\n\tswitch {\n\tcase true:\n\t\tb#\n\t}\n}\n", []string{"break"}, nil},
+		{"package main\n\nfunc main() {\n\tselect {\n\tc#\n\t}\n}\n", []string{"case"}, []string{"const"}},
+		{"package main\n\nfunc main() {\n\tselect {\n\tdefault:\n\t\tc#\n\t}\n}\n", []string{"case", "const"}, []string{"continue"}},
+		{"package main\n\nfunc main() {\n\tc#\n}\n", []string{"const"}, []string{"case", "chan"}},
+		{"package main\n\nfunc main() {\n\tfor k := r#\n}\n", []string{"range"}, nil},
+		{"package main\n\nfunc main() {\n\tx := m#\n}\n", []string{"map"}, []string{"range"}},
+		{"package main\n\nfunc main() {\n\t_ = struct{ A int }{A: 1, r#}\n}\n", nil, []string{"return", "range"}},
+		{"package main\n\nfunc main() {\n\tgo#\n}\n", []string{"go", "goto"}, nil},
+	}
+	for _, test := range tests {
+		m := new_marked_test(t, testConf.Config(), test.src, nil)
+		keywords := make(map[string]bool)
+		for _, c := range m.Complete(m.data, m.name, m.cursors[0]).Candidates {
+			if c.Class == "keyword" {
+				keywords[c.Name] = true
+			}
+		}
+		for _, kw := range test.want {
+			if !keywords[kw] {
+				t.Errorf("%q: missing keyword %s, got %v", test.src, kw, keywords)
+			}
+		}
+		for _, kw := range test.not {
+			if keywords[kw] {
+				t.Errorf("%q: unexpected keyword %s", test.src, kw)
+			}
+		}
+	}
+}
+
+func TestCompleteSnippets(t *testing.T) {
+	const src = `package main
+
+func pad(s string, n int) string { return s }
+func join(sep string, elems ...string) {}
+func skip(int, string) {}
+func mapOf[K comparable, V any](k K) V { var v V; return v }
+func zero[T any]() (t T) { return }
+func escape(v interface{ M() }) {}
+
+func main() {
+	_ = 
+}
+`
+	cursor := strings.Index(src, "_ = ") + len("_ = ")
+	name := filepath.Join(t.TempDir(), "main.go")
+	conf := testConf.Config()
+	conf.Snippets = true
+	snippets := make(map[string]string)
+	for _, c := range new_test_engine(t, conf).Complete([]byte(src), name, cursor).Candidates {
+		snippets[c.Name] = c.Snippet
+	}
+	want := map[string]string{
+		"pad":    "pad(${1:s string}, ${2:n int})",
+		"join":   "join(${1:sep string}, ${2:elems ...string})",
+		"skip":   "skip(${1:int}, ${2:string})",
+		"mapOf":  "mapOf[${1:K comparable}, ${2:V any}](${3:k K})",
+		"zero":   "zero[${1:T any}]()",
+		"escape": `escape(${1:v interface{ M() \}})`,
+		"main":   "main()",
+	}
+	for name, snippet := range want {
+		if got, ok := snippets[name]; !ok || got != snippet {
+			t.Errorf("%s: got %q, want %q", name, got, snippet)
+		}
+	}
+
+	conf.Snippets = false
+	for _, c := range new_test_engine(t, conf).Complete([]byte(src), name, cursor).Candidates {
+		if c.Snippet != "" {
+			t.Errorf("%s: snippet %q without Config.Snippets", c.Name, c.Snippet)
+		}
+	}
+}
+
+func TestCompleteFillStruct(t *testing.T) {
+	const src = `package main
+
+import "bytes"
+
+type Kind int
+
+type Point struct{ X, Y int }
+
+type options struct {
+	Name   string
+	Kind   Kind
+	Ok     bool
+	Err    error
+	Tags   []string
+	At     Point
+	Grid   [2]Point
+	Next   *options
+	_      int
+	Anon   struct{ A int }
+	Buffer bytes.Buffer
+	Point
+}
+
+var one = options{Name: "a", Ok: true, #}
+
+func main() {
+	_ = options{
+		Name: "b",
+		#
+	}
+	_ = bytes.Reader{#}
+	_ = Point{1, #}
+}
+`
+	tests := []struct {
+		n    int // cursor marker
+		want string
+	}{
+		{0, `var one = options{Name: "a", Ok: true,
+	Kind: 0,
+	Err: nil,
+	Tags: nil,
+	At: Point{},
+	Grid: [2]Point{},
+	Next: nil,
+	Anon: struct{ A int }{},
+	Buffer: bytes.Buffer{},
+	Point: Point{},
+}`},
+		{1, `	_ = options{
+		Name: "b",
+		Kind: 0,
+		Ok: false,
+		Err: nil,
+		Tags: nil,
+		At: Point{},
+		Grid: [2]Point{},
+		Next: nil,
+		Anon: struct{ A int }{},
+		Buffer: bytes.Buffer{},
+		Point: Point{},
+	}`},
+		{2, ""}, // the unexported fields of other packages are left out
+		{3, ""}, // positional elements
+	}
+	conf := testConf.Config()
+	conf.FillStructs = true
+	m := new_marked_test(t, conf, src, nil)
+	for _, test := range tests {
+		var fill *Candidate
+		for _, c := range m.Complete(m.data, m.name, m.cursors[test.n]).Candidates {
+			if c.Class == "fields" {
+				c := c
+				fill = &c
+			}
+		}
+		if test.want == "" {
+			if fill != nil {
+				t.Errorf("%d: got %+v, want no fill-in", test.n, fill)
+			}
+			continue
+		}
+		if fill == nil || fill.Edit == nil {
+			t.Errorf("%d: no fill-in", test.n)
+			continue
+		}
+		edit := fill.Edit
+		got := string(m.data[:edit.Start.Offset]) + edit.Text + string(m.data[edit.End.Offset:])
+		if !strings.Contains(got, test.want) {
+			t.Errorf("%d: got:\n%s\nwant:\n%s", test.n, got, test.want)
+		}
+	}
+}
+
+func TestImplementStubs(t *testing.T) {
+	const src = `package main
+
+import (
+	"io"
+	stdfs "io/fs"
+)
+
+type Store interface {
+	Get(key string) ([]byte, bool)
+	io.Closer
+}
+
+type Dir interface {
+	Open(name string) (stdfs.File, error)
+}
+
+type H#andle int
+
+type Repo struct{}
+
+func (r *Repo) Close() error { return nil }
+
+func (r *Repo) #
+`
+	m := new_marked_test(t, testConf.Config(), src, nil)
+	data := string(m.data)
+
+	// the typed receiver is replaced by the stubs
+	typed := strings.TrimSuffix(data, "func (r *Repo) \n")
+	handle := "type Handle int\n"
+	tests := []struct {
+		cursor int
+		iface  string
+		want   string // the file after the edit
+	}{
+		{m.cursors[1], "io.ReadWriteCloser", typed + `func (r *Repo) Read(p []byte) (n int, err error) {
+	panic("unimplemented")
+}
+
+func (r *Repo) Write(p []byte) (n int, err error) {
+	panic("unimplemented")
+}
+`},
+		{m.cursors[1], "Store", typed + `func (r *Repo) Get(key string) ([]byte, bool) {
+	panic("unimplemented")
+}
+`},
+		{m.cursors[1], "io.Closer", string(data)},
+		{strings.Index(string(data), "Handle") + 1, "Dir", strings.Replace(string(data), handle, handle+`
+func (h Handle) Open(name string) (stdfs.File, error) {
+	panic("unimplemented")
+}
+`, 1)},
+	}
+	for _, test := range tests {
+		edit, ok := m.ImplementStubs(m.data, m.name, test.cursor, test.iface)
+		got := data[:edit.Start.Offset] + edit.Text + data[edit.End.Offset:]
+		if !ok || got != test.want {
+			t.Errorf("%s: got %q, %t, want %q", test.iface, got, ok, test.want)
+		}
+	}
+	if _, ok := m.ImplementStubs(m.data, m.name, m.cursors[1], "Repo"); ok {
+		t.Error("Repo: implemented a struct")
+	}
+}
+
+func TestMembers(t *testing.T) {
+	e := new_test_engine(t, testConf.Config())
+	names := func(list []Candidate) map[string]bool {
+		m := make(map[string]bool, len(list))
+		for _, c := range list {
+			m[c.Name] = true
+		}
+		return m
+	}
+	tests := []struct {
+		path, typ string
+		want      []string
+		not       []string
+	}{
+		{"bytes", "", []string{"Buffer", "NewReader", "MinRead"}, []string{"errNegativeRead"}},
+		{"bytes", "*Buffer", []string{"WriteString", "Len"}, []string{"buf"}},
+		// embedded types
+		{"bufio", "ReadWriter", []string{"Reader", "Writer", "ReadString", "Flush"}, nil},
+		// alias of a type of another package
+		{"os", "FileMode", []string{"IsDir", "Perm"}, nil},
+	}
+	for _, test := range tests {
+		var list []Candidate
+		var err error
+		if test.typ == "" {
+			list, err = e.PackageMembers(test.path)
+		} else {
+			list, err = e.TypeMembers(test.path, test.typ)
+		}
+		if err != nil {
+			t.Errorf("%s %s: %v", test.path, test.typ, err)
+			continue
+		}
+		got := names(list)
+		for _, name := range test.want {
+			if !got[name] {
+				t.Errorf("%s %s: missing %s", test.path, test.typ, name)
+			}
+		}
+		for _, name := range test.not {
+			if got[name] {
+				t.Errorf("%s %s: unexpected %s", test.path, test.typ, name)
+			}
+		}
+	}
+
+	if _, err := e.PackageMembers("does/not/exist"); err == nil {
+		t.Error("unknown package: no error")
+	}
+	if _, err := e.TypeMembers("bytes", "NoSuchType"); err == nil {
+		t.Error("unknown type: no error")
+	}
+}
+
+func TestReferences(t *testing.T) {
+	const src = `package main
+
+type T struct{ n int }
+
+func (t T) Get() int { return t.n# }
+
+func main() {
+	var t# T
+	n# := t.Ge#t()
+	o := Other{n#: n}
+	println(o.n#, t.n, n)
+}
+`
+	m := new_marked_test(t, testConf.Config(), src, map[string]string{
+		"other.go":     "package main\n\ntype Other struct{ n int }\n\nfunc get(t T) int { return t.Get() + t.n }\n",
+		"main_test.go": "package main\n\nvar _ = T{}.Get()\n",
+	})
+	name, other, test := m.name, m.file("other.go"), m.file("main_test.go")
+
+	type ref struct {
+		file string
+		line int
+		col  int
+	}
+	tests := []struct {
+		n     int // cursor marker
+		tests bool
+		want  []ref
+	}{
+		// the method, through the types of the operands
+		{3, false, []ref{{name, 5, 12}, {name, 9, 9}, {other, 5, 30}}},
+		{3, true, []ref{{name, 5, 12}, {name, 9, 9}, {test, 3, 13}, {other, 5, 30}}},
+		// the field of T, not that of Other
+		{0, false, []ref{{name, 3, 16}, {name, 5, 33}, {name, 11, 17}, {other, 5, 40}}},
+		// the field of Other, the key of the literal too
+		{5, false, []ref{{name, 10, 13}, {name, 11, 12}, {other, 3, 20}}},
+		{4, false, []ref{{name, 10, 13}, {name, 11, 12}, {other, 3, 20}}},
+		// local variables
+		{1, false, []ref{{name, 8, 6}, {name, 9, 7}, {name, 11, 15}}},
+		{2, false, []ref{{name, 9, 2}, {name, 10, 16}, {name, 11, 20}}},
+	}
+	for _, x := range tests {
+		refs, ok := m.References(m.data, name, m.cursors[x.n], x.tests)
+		var got []ref
+		for _, r := range refs {
+			got = append(got, ref{r.Filename, r.Line, r.Column})
+		}
+		if !ok || !reflect.DeepEqual(got, x.want) {
+			t.Errorf("%d: got %v, %t, want %v", x.n, got, ok, x.want)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := m.ReferencesContext(ctx, m.data, name, m.cursors[3], false); err != context.Canceled {
+		t.Errorf("cancelled: got error %v want %v", err, context.Canceled)
+	}
+}
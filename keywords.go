@@ -0,0 +1,198 @@
+package gocode
+
+import (
+	"go/token"
+)
+
+//-------------------------------------------------------------------------
+// keywords
+//
+// Proposes the Go keywords that are valid at the cursor. Whether a keyword
+// is valid depends on the token before the cursor and on the statements the
+// cursor is in, which are recorded while the statements of the function at
+// the cursor are processed (see auto_complete_file.process_stmt).
+//-------------------------------------------------------------------------
+
+// statements the cursor is in
+type stmt_flags uint8
+
+const (
+	stmt_in_func      stmt_flags = 1 << iota // function body
+	stmt_in_loop                             // for statement, continue
+	stmt_in_breakable                        // for, switch or select, break
+	stmt_in_switch                           // switch or select clauses, case and default
+	stmt_in_case                             // expression switch case, fallthrough
+)
+
+var (
+	// keywords starting top-level declarations
+	g_decl_keywords = []string{"const", "func", "import", "type", "var"}
+
+	// keywords starting statements, at least in some statements
+	g_stmt_keywords = []struct {
+		name  string
+		flags stmt_flags // required statements
+	}{
+		{"break", stmt_in_breakable},
+		{"case", stmt_in_switch},
+		{"const", 0},
+		{"continue", stmt_in_loop},
+		{"default", stmt_in_switch},
+		{"defer", 0},
+		{"fallthrough", stmt_in_case},
+		{"for", 0},
+		{"go", 0},
+		{"goto", 0},
+		{"if", 0},
+		{"return", 0},
+		{"select", 0},
+		{"switch", 0},
+		{"type", 0},
+		{"var", 0},
+	}
+
+	// keywords of type expressions and function literals
+	g_expr_keywords = []string{"chan", "func", "interface", "map", "struct"}
+)
+
+// get_keyword_candidates proposes the keywords matching partial, the
+// identifier that ends at the cursor, that are valid there. Keywords are not
+// proposed until something is typed, so as not to bury the declarations.
+func (c *auto_complete_context) get_keyword_candidates(file []byte, cursor int, partial string, b *out_buffers) {
+	start := cursor - len(partial)
+	iter := new_token_iterator(file, start)
+	prev := token.ILLEGAL
+	if len(iter.tokens) != 0 {
+		prev = iter.token().tok
+	}
+	f := c.current
+
+	if f.stmts&stmt_in_func == 0 {
+		// top level, outside of any brackets
+		depth := 0
+		for _, t := range iter.tokens {
+			switch t.tok {
+			case token.LPAREN, token.LBRACE, token.LBRACK:
+				depth++
+			case token.RPAREN, token.RBRACE, token.RBRACK:
+				depth--
+			}
+		}
+		if depth == 0 && prev == token.SEMICOLON {
+			for _, kw := range g_decl_keywords {
+				b.append_keyword(partial, kw)
+			}
+		}
+		return
+	}
+
+	switch prev {
+	case token.SEMICOLON, token.LBRACE, token.COLON:
+		if prev == token.LBRACE && in_switch_body(&iter) && iter.token().off != f.stmt_start {
+			// switch {#, the clauses could not be parsed
+			b.append_keyword(partial, "case")
+			b.append_keyword(partial, "default")
+			break
+		}
+		if prev != token.SEMICOLON && iter.token().off != f.stmt_start {
+			// a composite literal, not a block or a clause
+			break
+		}
+		for _, kw := range g_stmt_keywords {
+			if f.stmts&kw.flags == kw.flags {
+				b.append_keyword(partial, kw.name)
+			}
+		}
+	case token.RBRACE:
+		// } else
+		b.append_keyword(partial, "else")
+	case token.FOR:
+		// for range ch
+		b.append_keyword(partial, "range")
+	case token.DEFINE, token.ASSIGN:
+		if in_for_head(&iter) {
+			b.append_keyword(partial, "range")
+		}
+		fallthrough
+	case token.LPAREN, token.COMMA, token.RBRACK, token.MUL, token.ARROW,
+		token.RETURN, token.IDENT:
+		if prev == token.IDENT && !is_var_name(&iter) {
+			break
+		}
+		for _, kw := range g_expr_keywords {
+			b.append_keyword(partial, kw)
+		}
+	}
+}
+
+// in_switch_body reports whether the '{' under the cursor of iter starts the
+// body of a switch or select statement.
+func in_switch_body(iter *token_iterator) bool {
+	it := *iter
+	for it.go_back() {
+		switch it.token().tok {
+		case token.SWITCH, token.SELECT:
+			return true
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			if !it.skip_to_balanced_pair() {
+				return false
+			}
+		case token.SEMICOLON, token.LBRACE, token.COLON:
+			return false
+		}
+	}
+	return false
+}
+
+// in_for_head reports whether the token under the cursor of iter is in the
+// head of a for statement, before its first ';'.
+func in_for_head(iter *token_iterator) bool {
+	it := *iter
+	for it.go_back() {
+		switch it.token().tok {
+		case token.FOR:
+			return true
+		case token.SEMICOLON, token.LBRACE, token.RBRACE, token.COLON:
+			return false
+		}
+	}
+	return false
+}
+
+// is_var_name reports whether the identifier under the cursor of iter is the
+// name of a variable declaration, var a, b, which is followed by its type.
+func is_var_name(iter *token_iterator) bool {
+	it := *iter
+	for it.go_back() {
+		switch it.token().tok {
+		case token.VAR:
+			return true
+		case token.COMMA:
+			if !it.go_back() || it.token().tok != token.IDENT {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+func (b *out_buffers) append_keyword(p, name string) {
+	score := 0
+	if b.matcher == nil {
+		if !has_prefix(name, p, b.ignorecase) {
+			return
+		}
+	} else {
+		var ok bool
+		if score, ok = b.matcher.Match(name, p); !ok {
+			return
+		}
+	}
+	b.candidates = append(b.candidates, candidate{
+		Name:  name,
+		Class: decl_keyword,
+		Score: score,
+	})
+}
@@ -0,0 +1,160 @@
+package gocode
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+//-------------------------------------------------------------------------
+// matcher
+//
+// Matches the names of the candidates against the partial identifier at the
+// cursor and scores them, so that the candidates can be ranked. The scores
+// of the matchers are comparable: exact and prefix matches score higher than
+// camel-case matches, which score higher than fuzzy matches.
+//-------------------------------------------------------------------------
+
+// Matcher matches candidate names against the partial identifier typed at
+// the cursor, see Config.Matcher.
+type Matcher interface {
+	// Match reports whether name matches partial, which may be empty, and
+	// how well, the higher the score the better. Scores are at most
+	// MaxMatchScore.
+	Match(name, partial string) (score int, ok bool)
+}
+
+// MaxMatchScore is the score of an exact match.
+const MaxMatchScore = 100
+
+// The matchers of the package.
+var (
+	// PrefixMatcher matches the names starting with the partial
+	// identifier, and with a lower score those starting with it if the
+	// case is ignored.
+	PrefixMatcher Matcher = prefix_matcher{}
+
+	// CamelCaseMatcher is like PrefixMatcher, but also matches the names
+	// the words of which start with the runes of the partial identifier,
+	// ignoring their case, like "NRW" or "NewRW" for "NewReadWriter".
+	CamelCaseMatcher Matcher = camel_case_matcher{}
+
+	// FuzzyMatcher is like CamelCaseMatcher, but also matches the names
+	// that contain the runes of the partial identifier in order, ignoring
+	// their case, like "pf" for "Printf".
+	FuzzyMatcher Matcher = fuzzy_matcher{}
+)
+
+type prefix_matcher struct{}
+
+func (prefix_matcher) Match(name, partial string) (int, bool) {
+	switch {
+	case partial == "":
+		return MaxMatchScore / 2, true
+	case name == partial:
+		return MaxMatchScore, true
+	case strings.HasPrefix(name, partial):
+		return 80 + 10*len(partial)/len(name), true
+	case len(partial) <= len(name) && strings.EqualFold(name[:len(partial)], partial):
+		return 70 + 10*len(partial)/len(name), true
+	}
+	return 0, false
+}
+
+type camel_case_matcher struct{}
+
+func (camel_case_matcher) Match(name, partial string) (int, bool) {
+	if score, ok := PrefixMatcher.Match(name, partial); ok {
+		return score, true
+	}
+	if n, ok := match_camel_case([]rune(name), 0, []rune(partial), false); ok {
+		// prefer the longer runs of matched runes
+		return 40 + 20*n/utf8.RuneCountInString(partial), true
+	}
+	return 0, false
+}
+
+type fuzzy_matcher struct{}
+
+func (fuzzy_matcher) Match(name, partial string) (int, bool) {
+	if score, ok := CamelCaseMatcher.Match(name, partial); ok {
+		return score, true
+	}
+	if n, ok := match_fuzzy([]rune(name), []rune(partial)); ok {
+		return 10 + 20*n/(2*utf8.RuneCountInString(partial)), true
+	}
+	return 0, false
+}
+
+// match_camel_case reports whether the runes of partial match the runes of
+// name from offset i in order, each at the start of a word of name or right
+// after the previous match, the one before i if next is set. It returns the
+// number of runes matched right after the previous match.
+func match_camel_case(name []rune, i int, partial []rune, next bool) (int, bool) {
+	if len(partial) == 0 {
+		return 0, true
+	}
+	for j := i; j < len(name); j++ {
+		consecutive := next && j == i
+		if !consecutive && !is_word_start(name, j) {
+			continue
+		}
+		if !equal_fold_rune(name[j], partial[0]) {
+			continue
+		}
+		if n, ok := match_camel_case(name, j+1, partial[1:], true); ok {
+			if consecutive {
+				n++
+			}
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// match_fuzzy reports whether the runes of partial are runes of name in
+// order. It returns the points of the match: two for each rune at the start
+// of a word or right after the previous match, one for the others.
+func match_fuzzy(name, partial []rune) (int, bool) {
+	points, prev := 0, -2
+	j := 0
+	for i := 0; i < len(name) && j < len(partial); i++ {
+		if !equal_fold_rune(name[i], partial[j]) {
+			continue
+		}
+		if i == prev+1 || is_word_start(name, i) {
+			points += 2
+		} else {
+			points++
+		}
+		prev = i
+		j++
+	}
+	return points, j == len(partial)
+}
+
+// is_word_start reports whether name[i] starts a word of a mixed-caps or
+// snake case name, like the R of "NewReader" or the S of "HTTPServer".
+func is_word_start(name []rune, i int) bool {
+	if i == 0 {
+		return true
+	}
+	r, prev := name[i], name[i-1]
+	switch {
+	case prev == '_':
+		return r != '_'
+	case unicode.IsUpper(r):
+		if !unicode.IsUpper(prev) {
+			return true
+		}
+		// last capital of an initialism followed by a word
+		return i+1 < len(name) && unicode.IsLower(name[i+1])
+	case unicode.IsDigit(r):
+		return !unicode.IsDigit(prev)
+	}
+	return false
+}
+
+func equal_fold_rune(a, b rune) bool {
+	return a == b || unicode.ToLower(a) == unicode.ToLower(b)
+}
@@ -0,0 +1,135 @@
+package gocode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/ast"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//-------------------------------------------------------------------------
+// members
+//
+// Lists the exported members of a package or of a type of a package, like
+// the candidates of a completion after "pkg." or "value.", without a file
+// and a cursor.
+//-------------------------------------------------------------------------
+
+// PackageMembers returns the exported members of the package of import path
+// importPath, sorted by class and name like the candidates of Complete. The
+// package is resolved from the working directory, like the imports of its
+// files. See Complete for the engine that is used.
+func (c *Config) PackageMembers(importPath string) ([]Candidate, error) {
+	return default_engine.members(importPath, "", c)
+}
+
+// PackageMembers is like Config.PackageMembers, but uses the configuration
+// of the engine.
+func (e *Engine) PackageMembers(importPath string) ([]Candidate, error) {
+	return e.members(importPath, "", nil)
+}
+
+// TypeMembers returns the exported fields and methods of type typeName of
+// the package of import path importPath, including those of its embedded
+// types, like the candidates of Complete for a value of the type. The
+// methods are those of the pointer type, typeName may be "*Buffer" as well
+// as "Buffer". See PackageMembers for the package.
+func (c *Config) TypeMembers(importPath, typeName string) ([]Candidate, error) {
+	return default_engine.members(importPath, typeName, c)
+}
+
+// TypeMembers is like Config.TypeMembers, but uses the configuration of the
+// engine.
+func (e *Engine) TypeMembers(importPath, typeName string) ([]Candidate, error) {
+	return e.members(importPath, typeName, nil)
+}
+
+// members returns the members of type typeName of package importPath, or of
+// the package if typeName is empty.
+func (e *Engine) members(importPath, typeName string, conf *Config) ([]Candidate, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		list []candidate
+		lerr error
+	)
+	// run takes a file name, that of the package is made up
+	err = e.run(context.Background(), filepath.Join(wd, "_.go"), conf, func() {
+		list, lerr = e.autocomplete.members(context.Background(), importPath, typeName)
+	})
+	if err == nil {
+		err = lerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := make([]Candidate, len(list))
+	for i, c := range list {
+		res[i] = new_candidate(c)
+	}
+	return res, nil
+}
+
+func (c *auto_complete_context) members(ctx context.Context, importPath, typeName string) ([]candidate, error) {
+	path, ok := find_global_file(importPath, c.declcache.context)
+	if !ok {
+		return nil, &Error{Kind: ImportError, Path: importPath, Err: errors.New("cannot find package")}
+	}
+	ps := make(map[string]*package_file_cache, 1)
+	c.pcache.append_packages(ps, []package_import{{abspath: path, path: importPath}}, c.declcache.context)
+	errs := update_packages(ctx, ps)
+	c.pcache.update_dependencies(ctx, ps, c.declcache.context)
+	pkg := c.pcache[path].main
+	if pkg == nil {
+		if len(errs) != 0 {
+			return nil, errs[0]
+		}
+		return nil, &Error{Kind: ImportError, Path: importPath, Err: errors.New("cannot find package")}
+	}
+
+	// move the methods of the aliases to their types, in a scope of its
+	// own as the declarations of the package are shared
+	s := new_scope(nil)
+	for name, d := range pkg.children {
+		s.entities[name] = d
+	}
+	propagate_type_alias_methods(s)
+
+	b := new_out_buffers(c)
+	// qualify the types by the names of their packages
+	b.canonical_aliases = map[string]string{}
+	if typeName == "" {
+		for name, d := range s.entities {
+			if ast.IsExported(name) {
+				// members of packages loaded from source may only have a value
+				d.infer_type()
+				b.append_decl("", name, importPath, d, decl_invalid)
+			}
+		}
+	} else {
+		typeName = strings.TrimPrefix(typeName, "*")
+		d := s.entities[typeName]
+		if d == nil || d.class != decl_type || !ast.IsExported(typeName) {
+			err := fmt.Errorf("cannot find type %s", typeName)
+			return nil, &Error{Kind: ImportError, Path: importPath, Err: err}
+		}
+		c.get_candidates_from_decl(cursor_context{decl: d}, decl_invalid, b)
+
+		exported := b.candidates[:0]
+		for _, c := range b.candidates {
+			if ast.IsExported(c.Name) {
+				exported = append(exported, c)
+			}
+		}
+		b.candidates = exported
+	}
+
+	sort.Sort(b)
+	return b.candidates, nil
+}
@@ -0,0 +1,380 @@
+package gocode
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/charlievieth/gocode/fs"
+)
+
+//-------------------------------------------------------------------------
+// go_module
+//
+// The parts of a go.mod file that matter for resolving import paths: the
+// module path plus its require and replace directives.
+//-------------------------------------------------------------------------
+
+type module_version struct {
+	path    string
+	version string
+}
+
+type module_replace struct {
+	old module_version // old.version is empty if all versions are replaced
+	new module_version // new.version is empty for local (directory) replacements
+}
+
+type go_module struct {
+	root     string // directory containing the go.mod file
+	path     string // module path
+	mtime    int64  // mtime of the go.mod file
+	requires map[string]string
+	replaces []module_replace
+	vendored bool // root contains vendor/modules.txt
+}
+
+var errNoModulePath = errors.New("go.mod: missing module path")
+
+func parse_go_mod(root string, data []byte) (*go_module, error) {
+	m := &go_module{
+		root:     root,
+		requires: make(map[string]string),
+	}
+	block := ""
+	for len(data) > 0 {
+		var line []byte
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i], data[i+1:]
+		} else {
+			line, data = data, nil
+		}
+		if i := bytes.Index(line, []byte("//")); i >= 0 {
+			line = line[:i]
+		}
+		fields := mod_fields(string(line))
+		if len(fields) == 0 {
+			continue
+		}
+		if block != "" {
+			if fields[0] == ")" {
+				block = ""
+				continue
+			}
+			m.directive(block, fields)
+			continue
+		}
+		if len(fields) == 2 && fields[1] == "(" {
+			block = fields[0]
+			continue
+		}
+		m.directive(fields[0], fields[1:])
+	}
+	if m.path == "" {
+		return nil, errNoModulePath
+	}
+	return m, nil
+}
+
+func (m *go_module) directive(verb string, args []string) {
+	switch verb {
+	case "module":
+		if len(args) == 1 {
+			m.path = args[0]
+		}
+	case "require":
+		if len(args) >= 2 {
+			m.requires[args[0]] = args[1]
+		}
+	case "replace":
+		// old [version] => new [version]
+		i := 0
+		for i < len(args) && args[i] != "=>" {
+			i++
+		}
+		if i == 0 || i > 2 || i == len(args)-1 {
+			return
+		}
+		var r module_replace
+		r.old.path = args[0]
+		if i == 2 {
+			r.old.version = args[1]
+		}
+		r.new.path = args[i+1]
+		if len(args) > i+2 {
+			r.new.version = args[i+2]
+		}
+		m.replaces = append(m.replaces, r)
+	}
+}
+
+// mod_fields splits a go.mod line into fields, unquoting quoted strings.
+func mod_fields(s string) []string {
+	var fields []string
+	for {
+		s = strings.TrimLeft(s, " \t\r")
+		if s == "" {
+			return fields
+		}
+		if s[0] == '"' || s[0] == '`' {
+			if q, err := strconv.QuotedPrefix(s); err == nil {
+				if v, err := strconv.Unquote(q); err == nil {
+					fields = append(fields, v)
+				}
+				s = s[len(q):]
+				continue
+			}
+		}
+		n := strings.IndexAny(s, " \t\r")
+		if n == -1 {
+			n = len(s)
+		}
+		fields = append(fields, s[:n])
+		s = s[n:]
+	}
+}
+
+// replacement returns the replacement for module version mv, if any. Version
+// specific replacements take precedence over wildcard ones.
+func (m *go_module) replacement(mv module_version) (module_replace, bool) {
+	found := -1
+	for i, r := range m.replaces {
+		if r.old.path != mv.path {
+			continue
+		}
+		if r.old.version == mv.version {
+			return r, true
+		}
+		if r.old.version == "" {
+			found = i
+		}
+	}
+	if found != -1 {
+		return m.replaces[found], true
+	}
+	return module_replace{}, false
+}
+
+// lookup returns the module that provides import path imp, this is the
+// longest module path that is a prefix of imp.
+func (m *go_module) lookup(imp string) (mv module_version, main bool, ok bool) {
+	if in_module(imp, m.path) {
+		mv.path = m.path
+		main = true
+		ok = true
+	}
+	for path, version := range m.requires {
+		if len(path) > len(mv.path) && in_module(imp, path) {
+			mv = module_version{path, version}
+			main = false
+			ok = true
+		}
+	}
+	return mv, main, ok
+}
+
+func in_module(imp, modpath string) bool {
+	return strings.HasPrefix(imp, modpath) &&
+		(len(imp) == len(modpath) || imp[len(modpath)] == '/')
+}
+
+// package_dir returns the source directory of the package with import path
+// imp, modcache is the root of the module cache (GOMODCACHE).
+func (m *go_module) package_dir(imp, modcache string) (string, bool) {
+	mv, main, ok := m.lookup(imp)
+	if !ok {
+		return "", false
+	}
+	rel := filepath.FromSlash(strings.TrimPrefix(imp[len(mv.path):], "/"))
+	if main {
+		return existing_dir(filepath.Join(m.root, rel))
+	}
+	if m.vendored {
+		if dir, ok := existing_dir(filepath.Join(m.root, "vendor", filepath.FromSlash(imp))); ok {
+			return dir, true
+		}
+	}
+	if r, ok := m.replacement(mv); ok {
+		if r.new.version == "" {
+			dir := filepath.FromSlash(r.new.path)
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(m.root, dir)
+			}
+			return existing_dir(filepath.Join(dir, rel))
+		}
+		mv = r.new
+	}
+	if modcache == "" {
+		return "", false
+	}
+	path, err1 := escape_module_path(mv.path)
+	version, err2 := escape_module_path(mv.version)
+	if err1 != nil || err2 != nil {
+		return "", false
+	}
+	return existing_dir(filepath.Join(modcache, filepath.FromSlash(path)+"@"+version, rel))
+}
+
+// import_path returns the import path of the package in directory dir, which
+// must be located within the module.
+func (m *go_module) import_path(dir string) (string, bool) {
+	rel, err := filepath.Rel(m.root, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	if rel == "." {
+		return m.path, true
+	}
+	return m.path + "/" + filepath.ToSlash(rel), true
+}
+
+func existing_dir(dir string) (string, bool) {
+	if is_dir(dir) {
+		return dir, true
+	}
+	return "", false
+}
+
+// escape_module_path escapes upper-case letters the way the go command does
+// for paths in the module cache: 'A' => "!a".
+func escape_module_path(s string) (string, error) {
+	upper := false
+	for _, r := range s {
+		if r == '!' || r >= utf8.RuneSelf {
+			return "", errors.New("invalid module path: " + strconv.Quote(s))
+		}
+		if 'A' <= r && r <= 'Z' {
+			upper = true
+		}
+	}
+	if !upper {
+		return s, nil
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if 'A' <= r && r <= 'Z' {
+			b.WriteByte('!')
+			b.WriteRune(r + ('a' - 'A'))
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String(), nil
+}
+
+//-------------------------------------------------------------------------
+// module_cache
+//
+// Thread-safe cache of parsed go.mod files keyed by their directory.
+//-------------------------------------------------------------------------
+
+type module_cache struct {
+	mods    map[string]*go_module
+	exports map[string]string // package directory => export data file
+	mu      sync.Mutex
+}
+
+func new_module_cache() *module_cache {
+	return &module_cache{
+		mods:    make(map[string]*go_module),
+		exports: make(map[string]string),
+	}
+}
+
+// export returns the cached export data file of the package in dir, if it
+// exists and is newer than the package's source files.
+func (c *module_cache) export(dir string, dirs *DirCache) (string, bool) {
+	c.mu.Lock()
+	name, ok := c.exports[dir]
+	c.mu.Unlock()
+	if !ok {
+		return "", false
+	}
+	fi, err := fs.Stat(name)
+	if err != nil {
+		return "", false
+	}
+	if stale, err := modified_after(dir, fi.ModTime(), dirs); err != nil || stale {
+		return "", false
+	}
+	return name, true
+}
+
+func (c *module_cache) set_export(dir, name string) {
+	c.mu.Lock()
+	c.exports[dir] = name
+	c.mu.Unlock()
+}
+
+// find returns the module enclosing directory dir or nil if dir is not
+// within a module.
+func (c *module_cache) find(dir string) *go_module {
+	for {
+		if m := c.load(dir); m != nil {
+			return m
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil
+		}
+		dir = parent
+	}
+}
+
+func (c *module_cache) load(root string) *go_module {
+	name := filepath.Join(root, "go.mod")
+	fi, err := fs.Stat(name)
+	if err != nil || fi.IsDir() {
+		return nil
+	}
+	mtime := fi.ModTime().UnixNano()
+
+	c.mu.Lock()
+	m := c.mods[root]
+	c.mu.Unlock()
+	if m != nil && m.mtime == mtime {
+		return m
+	}
+
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil
+	}
+	m, err = parse_go_mod(root, data)
+	if err != nil {
+		return nil
+	}
+	m.mtime = mtime
+	m.vendored = file_exists(filepath.Join(root, "vendor", "modules.txt"))
+
+	c.mu.Lock()
+	c.mods[root] = m
+	c.mu.Unlock()
+	return m
+}
+
+// default_mod_cache returns the default module cache directory: $GOMODCACHE
+// or the first GOPATH entry joined with "pkg/mod".
+func default_mod_cache(gopath string) string {
+	if s := os.Getenv("GOMODCACHE"); s != "" {
+		return s
+	}
+	for _, p := range filepath.SplitList(gopath) {
+		if p != "" {
+			return filepath.Join(p, "pkg", "mod")
+		}
+	}
+	return ""
+}
+
+// modules_enabled reports if module-aware lookup should be used, which is
+// the case unless GO111MODULE is explicitly set to "off".
+func modules_enabled() bool {
+	return os.Getenv("GO111MODULE") != "off"
+}
@@ -0,0 +1,107 @@
+package gocode
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testGoMod = `module example.com/app
+
+go 1.15
+
+require (
+	github.com/BurntSushi/toml v0.3.1
+	golang.org/x/tools v0.1.0 // indirect
+	example.com/local v1.0.0
+)
+
+require "golang.org/x/tools/gopls" v0.6.0
+
+replace example.com/local => ../local
+
+replace (
+	golang.org/x/tools v0.1.0 => golang.org/x/tools v0.1.1
+)
+`
+
+func TestParseGoMod(t *testing.T) {
+	m, err := parse_go_mod("/src/app", []byte(testGoMod))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.path != "example.com/app" {
+		t.Errorf("path: got %q want %q", m.path, "example.com/app")
+	}
+	requires := map[string]string{
+		"github.com/BurntSushi/toml": "v0.3.1",
+		"golang.org/x/tools":         "v0.1.0",
+		"golang.org/x/tools/gopls":   "v0.6.0",
+		"example.com/local":          "v1.0.0",
+	}
+	for path, version := range requires {
+		if m.requires[path] != version {
+			t.Errorf("require %s: got %q want %q", path, m.requires[path], version)
+		}
+	}
+	if len(m.replaces) != 2 {
+		t.Fatalf("replaces: got %d want %d", len(m.replaces), 2)
+	}
+	if _, err := parse_go_mod("", []byte("go 1.15\n")); err != errNoModulePath {
+		t.Errorf("missing module path: got %v want %v", err, errNoModulePath)
+	}
+}
+
+func TestModulePackageDir(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "gocode-modules-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	root := filepath.Join(tmp, "app")
+	modcache := filepath.Join(tmp, "mod")
+	dirs := []string{
+		filepath.Join(root, "internal", "util"),
+		filepath.Join(tmp, "local", "sub"),
+		filepath.Join(modcache, "github.com", "!burnt!sushi", "toml@v0.3.1"),
+		filepath.Join(modcache, "golang.org", "x", "tools@v0.1.1", "go", "ast", "astutil"),
+		filepath.Join(modcache, "golang.org", "x", "tools", "gopls@v0.6.0", "internal"),
+	}
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "go.mod"), []byte(testGoMod), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := new_module_cache().find(filepath.Join(root, "internal", "util"))
+	if m == nil {
+		t.Fatal("failed to find module")
+	}
+	if p, _ := m.import_path(filepath.Join(root, "internal", "util")); p != "example.com/app/internal/util" {
+		t.Errorf("import_path: got %q", p)
+	}
+
+	tests := []struct {
+		imp string
+		dir string
+	}{
+		{"example.com/app/internal/util", dirs[0]},
+		{"example.com/local/sub", dirs[1]},
+		{"github.com/BurntSushi/toml", dirs[2]},
+		{"golang.org/x/tools/go/ast/astutil", dirs[3]},
+		{"golang.org/x/tools/gopls/internal", dirs[4]},
+		{"fmt", ""},
+		{"example.com/app/missing", ""},
+	}
+	for _, x := range tests {
+		dir, ok := m.package_dir(x.imp, modcache)
+		if dir != x.dir || ok != (x.dir != "") {
+			t.Errorf("package_dir(%q): got (%q, %t) want %q", x.imp, dir, ok, x.dir)
+		}
+	}
+}
@@ -38,15 +38,19 @@ func (m *package_file_cache) update_cache() {
 	if err != nil {
 		return
 	}
+	if stat.IsDir() {
+		m.update_source_cache()
+		return
+	}
 
 	statmtime := stat.ModTime().UnixNano()
 	if m.mtime != statmtime {
-		m.mtime = statmtime
-
-		data, err := file_reader.read_file(fname)
+		data, err := m.context.reader.read_file(fname)
 		if err != nil {
 			return
 		}
+		// remembered only once processed, see object_go15.go
 		m.process_package_data(data)
+		m.mtime = statmtime
 	}
 }
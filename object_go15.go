@@ -16,22 +16,27 @@ func (m *package_file_cache) update_cache() {
 	if err != nil {
 		return
 	}
+	if stat.IsDir() {
+		m.update_source_cache()
+		return
+	}
 
 	statmtime := stat.ModTime().UnixNano()
 	if m.mtime != statmtime {
-		m.mtime = statmtime
-
-		buf, err := file_reader.read_file_buffer(m.name, stat)
+		buf, err := m.context.reader.read_file_buffer(m.name, stat)
 		if err != nil {
 			return
 		}
 
+		// the file is remembered only once it is processed, so that
+		// corrupt export data is reported again by every update
 		sum := crc32.Checksum(buf.Bytes(), crc32.MakeTable(crc32.Castagnoli))
 		if m.checksum != sum || m.size != stat.Size() {
+			m.process_package_data(buf.Bytes())
 			m.checksum = sum
 			m.size = stat.Size()
-			m.process_package_data(buf.Bytes())
 		}
+		m.mtime = statmtime
 
 		bufferPool.Put(buf)
 	}
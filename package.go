@@ -2,8 +2,11 @@ package gocode
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"go/ast"
+	"go/token"
+	"path/filepath"
 	"strings"
 )
 
@@ -29,12 +32,28 @@ type package_file_cache struct {
 	scope  *scope
 	main   *decl // package declaration
 	others map[string]*decl
+
+	// packages imported by the package source files, the declarations
+	// of which are not part of the package data
+	deps    []package_import
+	context *package_lookup_context
+
+	// source files of packages loaded from source and the mtime of
+	// their directory when the list was read
+	files    []string
+	dirmtime int64
+
+	// positions of the declarations read from export data, or the file set
+	// of the source files of packages loaded from source
+	positions position_table
+	fset      *token.FileSet
 }
 
-func new_package_file_cache(absname, name string) *package_file_cache {
+func new_package_file_cache(absname, name string, context *package_lookup_context) *package_file_cache {
 	return &package_file_cache{
 		name:        absname,
 		import_name: name,
+		context:     context,
 	}
 }
 
@@ -48,7 +67,14 @@ func new_package_file_cache_forever(name, defalias string) *package_file_cache {
 }
 
 func (m *package_file_cache) process_package_data(data []byte) {
-	m.scope = new_named_scope(g_universe_scope, m.name)
+	defer func() {
+		// the parsers panic on malformed data
+		if err := recover(); err != nil {
+			e := &Error{Kind: ExportDataError, Path: m.import_name}
+			e.Err = fmt.Errorf("%s: %v", m.name, err)
+			panic(e)
+		}
+	}()
 
 	// find import section
 	i := bytes.Index(data, []byte{'\n', '$', '$'})
@@ -57,11 +83,6 @@ func (m *package_file_cache) process_package_data(data []byte) {
 	}
 	data = data[i+len("\n$$"):]
 
-	// main package
-	m.main = new_decl(m.name, decl_package, nil)
-	// create map for other packages
-	m.others = make(map[string]*decl)
-
 	var pp package_parser
 	if data[0] == 'B' {
 		// binary format, skip 'B\n'
@@ -70,6 +91,10 @@ func (m *package_file_cache) process_package_data(data []byte) {
 			var p gc_ibin_parser
 			p.init(data[1:], m)
 			pp = &p
+		} else if len(data) > 0 && data[0] == 'u' {
+			var p gc_ubin_parser
+			p.init(data[1:], m)
+			pp = &p
 		} else {
 			var p gc_bin_parser
 			p.init(data, m)
@@ -87,6 +112,30 @@ func (m *package_file_cache) process_package_data(data []byte) {
 		p.init(data, m)
 		pp = &p
 	}
+	m.process_package(pp)
+	if m.context != nil && m.context.config.Docs() {
+		m.add_docs()
+	}
+}
+
+// process_package_source builds the package from the source files of the
+// package directory.
+func (m *package_file_cache) process_package_source(files []string) {
+	var p source_parser
+	p.init(m.name, files, m)
+	m.process_package(&p)
+}
+
+func (m *package_file_cache) process_package(pp package_parser) {
+	m.scope = new_named_scope(g_universe_scope, m.name)
+	m.positions = nil
+	m.fset = nil
+
+	// main package
+	m.main = new_decl(m.name, decl_package, nil)
+	// create map for other packages
+	m.others = make(map[string]*decl)
+	m.deps = nil
 
 	prefix := "!" + m.name + "!"
 	pp.parse_export(func(pkg string, decl ast.Decl) {
@@ -103,6 +152,12 @@ func (m *package_file_cache) process_package_data(data []byte) {
 		}
 	})
 
+	if m.fset != nil {
+		m.scope.positions = m.fset.Position
+	} else {
+		m.scope.positions = m.positions.position
+	}
+
 	// hack, add ourselves to the package scope
 	mainName := "!" + m.name + "!" + m.defalias
 	m.add_package_to_scope(mainName, m.name)
@@ -120,6 +175,15 @@ func (m *package_file_cache) process_package_data(data []byte) {
 	}
 }
 
+// add_position records a position read from export data, the returned
+// token.Pos is resolved by the scope of the package.
+func (m *package_file_cache) add_position(filename string, line, column int) token.Pos {
+	if rest := strings.TrimPrefix(filename, "$GOROOT"); rest != filename && m.context != nil {
+		filename = filepath.Join(m.context.GOROOT, filepath.FromSlash(rest))
+	}
+	return m.positions.add(filename, line, column)
+}
+
 func (m *package_file_cache) add_package_to_scope(alias, realname string) {
 	d := new_decl(realname, decl_package, nil)
 	m.scope.add_decl(alias, d)
@@ -135,6 +199,9 @@ func add_ast_decl_to_package(pkg *decl, decl ast.Decl, scope *scope) {
 			if d == nil {
 				return
 			}
+			d.pos = name.Pos()
+			d.tparams = ast_decl_type_params(data.decl)
+			d.doc = ast_decl_doc(data.decl)
 
 			if !name.IsExported() && d.class != decl_type {
 				return
@@ -179,7 +246,7 @@ func new_package_cache() package_cache {
 
 // Function fills 'ps' set with packages from 'packages' import information.
 // In case if package is not in the cache, it creates one and adds one to the cache.
-func (c package_cache) append_packages(ps map[string]*package_file_cache, pkgs []package_import) {
+func (c package_cache) append_packages(ps map[string]*package_file_cache, pkgs []package_import, context *package_lookup_context) {
 	for _, m := range pkgs {
 		if _, ok := ps[m.abspath]; ok {
 			continue
@@ -188,13 +255,54 @@ func (c package_cache) append_packages(ps map[string]*package_file_cache, pkgs [
 		if mod, ok := c[m.abspath]; ok {
 			ps[m.abspath] = mod
 		} else {
-			mod = new_package_file_cache(m.abspath, m.path)
+			mod = new_package_file_cache(m.abspath, m.path, context)
 			ps[m.abspath] = mod
 			c[m.abspath] = mod
 		}
 	}
 }
 
+// update_dependencies updates the packages that packages loaded from source
+// depend on and returns the errors of those that failed, see update_packages.
+// Unlike export data, package sources do not contain the declarations of the
+// types they reference from other packages, so the dependencies are loaded as
+// well (transitively) and bound to the package scopes.
+func (c package_cache) update_dependencies(ctx context.Context, ps map[string]*package_file_cache, context *package_lookup_context) []*Error {
+	var errs []*Error
+	seen := make(map[string]*package_file_cache, len(ps))
+	for k, p := range ps {
+		seen[k] = p
+	}
+	next := ps
+	for len(next) != 0 {
+		deps := make(map[string]*package_file_cache)
+		for _, p := range next {
+			for _, dep := range p.deps {
+				if _, ok := seen[dep.abspath]; !ok {
+					c.append_packages(deps, []package_import{dep}, context)
+					seen[dep.abspath] = deps[dep.abspath]
+				}
+			}
+		}
+		errs = append(errs, update_packages(ctx, deps)...)
+		next = deps
+	}
+	for _, p := range seen {
+		p.bind_dependencies(c)
+	}
+	return errs
+}
+
+// bind_dependencies replaces the placeholder declarations of the packages
+// the package depends on with the actual packages.
+func (m *package_file_cache) bind_dependencies(c package_cache) {
+	for _, dep := range m.deps {
+		if p, ok := c[dep.abspath]; ok && p.main != nil {
+			m.scope.replace_decl(dep.alias, p.main)
+		}
+	}
+}
+
 var g_builtin_unsafe_package = []byte(`
 import
 $$
@@ -212,3 +320,27 @@ func (c package_cache) add_builtin_unsafe_package() {
 	pkg.process_package_data(g_builtin_unsafe_package)
 	c["unsafe"] = pkg
 }
+
+//-------------------------------------------------------------------------
+// position_table
+//
+// Positions read from export data. These have no offsets and thus no
+// token.File, a token.Pos is an index into the table instead.
+//-------------------------------------------------------------------------
+
+type position_table []token.Position
+
+func (t *position_table) add(filename string, line, column int) token.Pos {
+	if filename == "" || line <= 0 {
+		return token.NoPos
+	}
+	*t = append(*t, token.Position{Filename: filename, Line: line, Column: column})
+	return token.Pos(len(*t))
+}
+
+func (t position_table) position(p token.Pos) token.Position {
+	if i := int(p) - 1; i >= 0 && i < len(t) {
+		return t[i]
+	}
+	return token.Position{}
+}
@@ -200,7 +200,7 @@ func (p *gc_bin_parser) pkg() string {
 func (p *gc_bin_parser) obj(tag int) {
 	switch tag {
 	case constTag:
-		p.pos()
+		pos := p.declPos()
 		pkg, name := p.qualifiedName()
 		typ := p.typ("")
 		p.skipValue() // ignore const value, gocode's not interested
@@ -208,7 +208,7 @@ func (p *gc_bin_parser) obj(tag int) {
 			Tok: token.CONST,
 			Specs: []ast.Spec{
 				&ast.ValueSpec{
-					Names:  []*ast.Ident{ast.NewIdent(name)},
+					Names:  []*ast.Ident{{Name: name, NamePos: pos}},
 					Type:   typ,
 					Values: []ast.Expr{&ast.BasicLit{Kind: token.INT, Value: "0"}},
 				},
@@ -217,37 +217,37 @@ func (p *gc_bin_parser) obj(tag int) {
 
 	case aliasTag:
 		// TODO(gri) verify type alias hookup is correct
-		p.pos()
+		pos := p.declPos()
 		pkg, name := p.qualifiedName()
 		typ := p.typ("")
 		p.callback(pkg, &ast.GenDecl{
 			Tok:   token.TYPE,
-			Specs: []ast.Spec{typeAliasSpec(name, typ)},
+			Specs: []ast.Spec{typeAliasSpec(name, typ, pos)},
 		})
 
 	case typeTag:
 		_ = p.typ("")
 
 	case varTag:
-		p.pos()
+		pos := p.declPos()
 		pkg, name := p.qualifiedName()
 		typ := p.typ("")
 		p.callback(pkg, &ast.GenDecl{
 			Tok: token.VAR,
 			Specs: []ast.Spec{
 				&ast.ValueSpec{
-					Names: []*ast.Ident{ast.NewIdent(name)},
+					Names: []*ast.Ident{{Name: name, NamePos: pos}},
 					Type:  typ,
 				},
 			},
 		})
 	case funcTag:
-		p.pos()
+		pos := p.declPos()
 		pkg, name := p.qualifiedName()
 		params := p.paramList()
 		results := p.paramList()
 		p.callback(pkg, &ast.FuncDecl{
-			Name: ast.NewIdent(name),
+			Name: &ast.Ident{Name: name, NamePos: pos},
 			Type: &ast.FuncType{Params: params, Results: results},
 		})
 
@@ -287,8 +287,15 @@ func (p *gc_bin_parser) pos() {
 	}
 	p.prevFile = file
 	p.prevLine = line
+}
 
-	// TODO(gri) register new position
+// declPos reads the position of a declaration, a field or a method.
+func (p *gc_bin_parser) declPos() token.Pos {
+	p.pos()
+	if !p.posInfoFormat {
+		return token.NoPos
+	}
+	return p.pfc.add_position(p.prevFile, p.prevLine, 0)
 }
 
 func (p *gc_bin_parser) qualifiedName() (pkg string, name string) {
@@ -333,13 +340,13 @@ func (p *gc_bin_parser) typ(parent string) ast.Expr {
 	switch i {
 	case namedTag:
 		// read type object
-		p.pos()
+		pos := p.declPos()
 		parent, name := p.qualifiedName()
 		tdecl := &ast.GenDecl{
 			Tok: token.TYPE,
 			Specs: []ast.Spec{
 				&ast.TypeSpec{
-					Name: ast.NewIdent(name),
+					Name: &ast.Ident{Name: name, NamePos: pos},
 				},
 			},
 		}
@@ -362,7 +369,7 @@ func (p *gc_bin_parser) typ(parent string) ast.Expr {
 		// read associated methods
 		for i := p.int(); i > 0; i-- {
 			// TODO(gri) replace this with something closer to fieldName
-			p.pos()
+			pos := p.declPos()
 			name := p.string()
 			if !exported(name) {
 				p.pkg()
@@ -376,7 +383,7 @@ func (p *gc_bin_parser) typ(parent string) ast.Expr {
 			strip_method_receiver(recv)
 			p.callback(parent, &ast.FuncDecl{
 				Recv: recv,
-				Name: ast.NewIdent(name),
+				Name: &ast.Ident{Name: name, NamePos: pos},
 				Type: &ast.FuncType{Params: params, Results: results},
 			})
 		}
@@ -469,14 +476,14 @@ func (p *gc_bin_parser) structType(parent string) *ast.StructType {
 }
 
 func (p *gc_bin_parser) field(parent string) (*ast.Field, string) {
-	p.pos()
+	pos := p.declPos()
 	_, name, _ := p.fieldName(parent)
 	typ := p.typ(parent)
 	tag := p.string()
 
 	var names []*ast.Ident
 	if name != "" {
-		names = []*ast.Ident{ast.NewIdent(name)}
+		names = []*ast.Ident{{Name: name, NamePos: pos}}
 	}
 	return &ast.Field{
 		Names: names,
@@ -495,12 +502,12 @@ func (p *gc_bin_parser) methodList(parent string) (methods []*ast.Field) {
 }
 
 func (p *gc_bin_parser) method(parent string) *ast.Field {
-	p.pos()
+	pos := p.declPos()
 	_, name, _ := p.fieldName(parent)
 	params := p.paramList()
 	results := p.paramList()
 	return &ast.Field{
-		Names: []*ast.Ident{ast.NewIdent(name)},
+		Names: []*ast.Ident{{Name: name, NamePos: pos}},
 		Type:  &ast.FuncType{Params: params, Results: results},
 	}
 }
@@ -827,3 +834,9 @@ var predeclared = []ast.Expr{
 	// used internally by gc; never used by this package or in .a files
 	ast.NewIdent("any"),
 }
+
+// predeclared types added by the indexed export format for generics
+var ibin_predeclared_generic = []ast.Expr{
+	ast.NewIdent("comparable"),
+	ast.NewIdent("any"),
+}
@@ -139,7 +139,7 @@ func (p *gc_ibin_parser) parse_export(callback func(string, ast.Decl)) {
 	r := &intReader{bytes.NewReader(p.data)}
 	p.version = int(r.uint64())
 	switch p.version {
-	case 0, 1:
+	case 0, 1, 2:
 		// ok
 	default:
 		panic(fmt.Errorf("unknown export format version %d", p.version))
@@ -158,6 +158,9 @@ func (p *gc_ibin_parser) parse_export(callback func(string, ast.Decl)) {
 	for i, pt := range predeclared {
 		p.typCache[uint64(i)] = &ibinType{typ: pt}
 	}
+	for i, pt := range ibin_predeclared_generic {
+		p.typCache[uint64(len(predeclared)+i)] = &ibinType{typ: pt}
+	}
 
 	pkgs := make([]ibinPackage, r.uint64())
 	for i := range pkgs {
@@ -220,8 +223,9 @@ func (p *gc_ibin_parser) doDecl(pkg ibinPackage, name string) *ibinType {
 }
 
 type ibinType struct {
-	typ ast.Expr
-	und *ibinType
+	typ   ast.Expr
+	und   *ibinType
+	bound ast.Expr // constraint of a type parameter
 }
 
 func (t *ibinType) underlying() ast.Expr {
@@ -236,18 +240,23 @@ type bimportReader struct {
 	declReader bytes.Reader
 	currPkg    ibinPackage
 	version    int
+
+	// position encoding
+	prevFile   string
+	prevLine   int64
+	prevColumn int64
 }
 
 func (r *bimportReader) obj(name string) *ibinType {
 	tag := r.byte()
-	r.pos()
+	pos := r.declPos()
 
 	switch tag {
 	case 'A':
 		typ := r.typ()
 		r.p.callback(r.currPkg.fullName, &ast.GenDecl{
 			Tok:   token.TYPE,
-			Specs: []ast.Spec{typeAliasSpec(name, typ.typ)},
+			Specs: []ast.Spec{typeAliasSpec(name, typ.typ, pos)},
 		})
 		return typ
 	case 'C':
@@ -256,32 +265,42 @@ func (r *bimportReader) obj(name string) *ibinType {
 			Tok: token.CONST,
 			Specs: []ast.Spec{
 				&ast.ValueSpec{
-					Names:  []*ast.Ident{ast.NewIdent(name)},
+					Names:  []*ast.Ident{{Name: name, NamePos: pos}},
 					Type:   typ.typ,
 					Values: []ast.Expr{&ast.BasicLit{Kind: token.INT, Value: "0"}},
 				},
 			},
 		})
 		return typ
-	case 'F':
+	case 'F', 'G':
+		var tparams *ast.FieldList
+		if tag == 'G' {
+			tparams = r.tparamList()
+		}
 		sig := r.signature()
+		sig.TypeParams = tparams
 		r.p.callback(r.currPkg.fullName, &ast.FuncDecl{
-			Name: ast.NewIdent(name),
+			Name: &ast.Ident{Name: name, NamePos: pos},
 			Type: sig,
 		})
 		return &ibinType{typ: sig}
-	case 'T':
+	case 'T', 'U':
 		// Types can be recursive. We need to setup a stub
 		// declaration before recursing.
 		t := &ibinType{typ: &ast.SelectorExpr{X: ast.NewIdent(r.currPkg.fullName), Sel: ast.NewIdent(name)}}
 		r.currPkg.declTyp[name] = t
+		var tparams *ast.FieldList
+		if tag == 'U' {
+			tparams = r.tparamList()
+		}
 		t.und = r.p.typAt(r.uint64())
 		r.p.callback(r.currPkg.fullName, &ast.GenDecl{
 			Tok: token.TYPE,
 			Specs: []ast.Spec{
 				&ast.TypeSpec{
-					Name: ast.NewIdent(name),
-					Type: t.und.typ,
+					Name:       &ast.Ident{Name: name, NamePos: pos},
+					TypeParams: tparams,
+					Type:       t.und.typ,
 				},
 			},
 		})
@@ -292,14 +311,14 @@ func (r *bimportReader) obj(name string) *ibinType {
 
 		// read associated methods
 		for n := r.uint64(); n > 0; n-- {
-			r.pos()
+			mpos := r.declPos()
 			mname := r.ident()
 			recv := &ast.FieldList{List: []*ast.Field{r.param()}}
 			msig := r.signature()
 			strip_method_receiver(recv)
 			r.p.callback(r.currPkg.fullName, &ast.FuncDecl{
 				Recv: recv,
-				Name: ast.NewIdent(mname),
+				Name: &ast.Ident{Name: mname, NamePos: mpos},
 				Type: msig,
 			})
 		}
@@ -311,18 +330,51 @@ func (r *bimportReader) obj(name string) *ibinType {
 			Tok: token.VAR,
 			Specs: []ast.Spec{
 				&ast.ValueSpec{
-					Names: []*ast.Ident{ast.NewIdent(name)},
+					Names: []*ast.Ident{{Name: name, NamePos: pos}},
 					Type:  typ.typ,
 				},
 			},
 		})
 
 		return typ
+	case 'P':
+		// Type parameters are declared as objects named after their
+		// generic declaration ("Map.K"), set up the type before reading
+		// the constraint, which may refer to it.
+		t := &ibinType{typ: ast.NewIdent(tparam_name(name))}
+		r.currPkg.declTyp[name] = t
+		implicit := r.bool()
+		t.bound = r.typ().typ
+		if implicit {
+			// constraint written without the interface: [T ~int | ~uint]
+			if it, ok := t.bound.(*ast.InterfaceType); ok && len(it.Methods.List) == 1 {
+				t.bound = it.Methods.List[0].Type
+			}
+		}
+		return t
 	default:
 		panic(fmt.Sprintf("unexpected tag: %v", tag))
 	}
 }
 
+// tparam_name returns the source name of the type parameter with export
+// name name, which is qualified by the declaration it belongs to and may
+// carry a subscript: "Map.K", "List.T₁".
+func tparam_name(name string) string {
+	if i := strings.LastIndexByte(name, '.'); i != -1 {
+		name = name[i+1:]
+	}
+	if strings.HasPrefix(name, "$") {
+		return "_" // blank type parameter
+	}
+	if i := strings.Index(name, "·"); i > 0 {
+		name = name[:i]
+	}
+	return strings.TrimRightFunc(name, func(r rune) bool {
+		return '₀' <= r && r <= '₉'
+	})
+}
+
 const predeclReserved = 32
 
 type itag uint64
@@ -338,29 +390,47 @@ const (
 	signatureType
 	structType
 	interfaceType
+	typeParamType
+	instanceType
+	unionType
 )
 
-// we don't care about that, let's just skip it
+// pos reads a position, only the positions of declarations are recorded, see
+// declPos.
 func (r *bimportReader) pos() {
 	if r.version == 0 {
-		if r.int64() != deltaNewFile {
+		if delta := r.int64(); delta != deltaNewFile {
+			r.prevLine += delta
 		} else if l := r.int64(); l == -1 {
+			r.prevLine += deltaNewFile
 		} else {
-			r.string()
+			r.prevFile = r.string()
+			r.prevLine = l
 		}
 	} else {
 		delta := r.int64()
+		r.prevColumn += delta >> 1
 		if delta&1 != 0 {
 			delta = r.int64()
+			r.prevLine += delta >> 1
 			if delta&1 != 0 {
-				r.string()
+				r.prevFile = r.string()
 			}
 		}
 	}
 }
 
+// declPos reads the position of a declaration, a field or a method.
+func (r *bimportReader) declPos() token.Pos {
+	r.pos()
+	return r.p.pfc.add_position(r.prevFile, int(r.prevLine), int(r.prevColumn))
+}
+
 func (r *bimportReader) value() *ibinType {
 	t := r.typ()
+	if r.version >= 2 {
+		r.int64() // constant kind
+	}
 	typ := t.underlying()
 	ident, ok := typ.(*ast.Ident)
 	if !ok {
@@ -521,14 +591,14 @@ func (r *bimportReader) doType() *ibinType {
 
 		fields := make([]*ast.Field, r.uint64())
 		for i := range fields {
-			r.pos()
+			fpos := r.declPos()
 			fname := r.ident()
 			ftyp := r.typ()
 			emb := r.bool()
 			r.string()
 			var names []*ast.Ident
 			if fname != "" && !emb {
-				names = []*ast.Ident{ast.NewIdent(fname)}
+				names = []*ast.Ident{{Name: fname, NamePos: fpos}}
 			}
 
 			fields[i] = &ast.Field{Names: names, Type: ftyp.typ}
@@ -540,22 +610,24 @@ func (r *bimportReader) doType() *ibinType {
 		r.currPkg = r.pkg()
 
 		numEmbeds := int(r.uint64())
-		embeddeds := make([]*ast.SelectorExpr, 0, numEmbeds)
+		embeddeds := make([]ast.Expr, 0, numEmbeds)
 		for i := 0; i < numEmbeds; i++ {
 			r.pos()
 			t := r.typ()
-			if named, ok := t.typ.(*ast.SelectorExpr); ok {
-				embeddeds = append(embeddeds, named)
+			// named types, instances of generic types and the
+			// type sets of constraints
+			if t.typ != nil {
+				embeddeds = append(embeddeds, t.typ)
 			}
 		}
 
 		methods := make([]*ast.Field, r.uint64())
 		for i := range methods {
-			r.pos()
+			mpos := r.declPos()
 			mname := r.ident()
 			msig := r.signature()
 			methods[i] = &ast.Field{
-				Names: []*ast.Ident{ast.NewIdent(mname)},
+				Names: []*ast.Ident{{Name: mname, NamePos: mpos}},
 				Type:  msig,
 			}
 		}
@@ -564,7 +636,52 @@ func (r *bimportReader) doType() *ibinType {
 		}
 
 		return &ibinType{typ: &ast.InterfaceType{Methods: &ast.FieldList{List: methods}}}
+
+	case typeParamType:
+		pkg, name := r.qualifiedIdent()
+		return r.p.doDecl(pkg, name)
+
+	case instanceType:
+		r.pos()
+		targs := make([]ast.Expr, r.uint64())
+		for i := range targs {
+			targs[i] = r.typ().typ
+		}
+		base := r.typ()
+		return &ibinType{typ: new_index_expr(base.typ, targs), und: base}
+
+	case unionType:
+		var union ast.Expr
+		for n := r.uint64(); n > 0; n-- {
+			tilde := r.bool()
+			term := r.typ().typ
+			if tilde {
+				term = &ast.UnaryExpr{Op: token.TILDE, X: term}
+			}
+			if union == nil {
+				union = term
+			} else {
+				union = &ast.BinaryExpr{X: union, Op: token.OR, Y: term}
+			}
+		}
+		return &ibinType{typ: union}
+	}
+}
+
+func (r *bimportReader) tparamList() *ast.FieldList {
+	n := r.uint64()
+	if n == 0 {
+		return nil
 	}
+	xs := make([]*ast.Field, n)
+	for i := range xs {
+		t := r.typ()
+		xs[i] = &ast.Field{
+			Names: []*ast.Ident{t.typ.(*ast.Ident)},
+			Type:  t.bound,
+		}
+	}
+	return &ast.FieldList{List: xs}
 }
 
 func (r *bimportReader) signature() *ast.FuncType {
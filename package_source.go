@@ -0,0 +1,527 @@
+package gocode
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/charlievieth/buildutil"
+	"github.com/charlievieth/gocode/fs"
+)
+
+//-------------------------------------------------------------------------
+// source_parser
+//
+// A package_parser that builds the declarations of a package by parsing its
+// Go source files instead of reading compiled export data. This allows
+// completion for packages that have never been built.
+//
+// The declarations are made to look like the ones produced by the export
+// data parsers: references to package-level identifiers are qualified with
+// the full package name ("!path!name"). Unlike export data, the source does
+// not contain the declarations of other packages, these are loaded
+// separately (see package_cache.update_dependencies).
+//-------------------------------------------------------------------------
+
+type source_parser struct {
+	dir     string
+	files   []string
+	pfc     *package_file_cache
+	context *package_lookup_context
+
+	fset  *token.FileSet
+	self  string          // full name of the package: "!" + pfc.name + "!" + name
+	names map[string]bool // package-level identifiers
+
+	imports map[string]string // file local package names => full names
+}
+
+func (p *source_parser) init(dir string, files []string, pfc *package_file_cache) {
+	p.dir = dir
+	p.files = files
+	p.pfc = pfc
+	p.context = pfc.context
+	p.fset = token.NewFileSet()
+	p.names = make(map[string]bool)
+}
+
+func (p *source_parser) parse_export(callback func(string, ast.Decl)) {
+	p.pfc.fset = p.fset
+	files := make([]*ast.File, 0, len(p.files))
+	for _, name := range p.files {
+		data, err := p.context.read_file(name)
+		if err != nil {
+			continue
+		}
+		data, _ = filter_out_shebang(data)
+		file, _ := parser.ParseFile(p.fset, name, data, parser.SkipObjectResolution|p.context.parse_mode())
+		if file == nil || file.Name == nil {
+			continue
+		}
+		files = append(files, file)
+	}
+	if len(files) == 0 {
+		return
+	}
+
+	p.pfc.defalias = files[0].Name.Name
+	p.self = "!" + p.pfc.name + "!" + p.pfc.defalias
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			for _, name := range source_decl_names(decl) {
+				p.names[name] = true
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i, file := range files {
+		p.imports = p.file_imports(p.files[i], file, seen)
+		for _, decl := range file.Decls {
+			if d := p.decl(decl); d != nil {
+				callback("", d)
+			}
+		}
+	}
+}
+
+// file_imports returns the packages imported by file keyed by their local
+// name and records them as dependencies of the package.
+func (p *source_parser) file_imports(filename string, file *ast.File, seen map[string]bool) map[string]string {
+	imports := make(map[string]string, len(file.Imports))
+	for _, imp := range file.Imports {
+		ipath, alias := path_and_alias(imp)
+		if alias == "_" || alias == "." || ipath == "C" {
+			continue
+		}
+		abspath, ok := abs_path_for_package(filename, ipath, p.context)
+		if !ok {
+			continue
+		}
+		name := source_import_name(ipath, abspath, p.context)
+		if alias == "" {
+			alias = name
+		}
+		full := "!" + ipath + "!" + name
+		imports[alias] = full
+		if !seen[full] {
+			seen[full] = true
+			p.pfc.add_package_to_scope(full, abspath)
+			p.pfc.deps = append(p.pfc.deps, package_import{
+				alias:   full,
+				abspath: abspath,
+				path:    ipath,
+			})
+		}
+	}
+	return imports
+}
+
+// source_import_name returns the name of the imported package. If the package
+// is loaded from source its name is read from the package clause, otherwise
+// it is guessed from the import path.
+func source_import_name(ipath, abspath string, context *package_lookup_context) string {
+	if is_dir(abspath) {
+		if names, err := context.dirs.readdir_names(abspath); err == nil {
+			for _, name := range names {
+				if has_go_ext(name) && !strings.HasSuffix(name, "_test.go") {
+					pkg, err := buildutil.ReadPackageName(filepath.Join(abspath, name), nil)
+					if err == nil && pkg != "documentation" {
+						return pkg
+					}
+				}
+			}
+		}
+	}
+	return guess_package_name(ipath)
+}
+
+// guess_package_name returns the conventional name of the package with
+// import path ipath: "gopkg.in/yaml.v2" => "yaml", "example.com/go-foo/v2"
+// => "foo".
+func guess_package_name(ipath string) string {
+	name := path.Base(ipath)
+	if is_major_version(name) && path.Dir(ipath) != "." {
+		name = path.Base(path.Dir(ipath))
+	}
+	if i := strings.Index(name, ".v"); i > 0 {
+		name = name[:i]
+	}
+	name = strings.TrimPrefix(name, "go-")
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == '.' {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+func is_major_version(s string) bool {
+	if len(s) < 2 || s[0] != 'v' {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func source_decl_names(decl ast.Decl) []string {
+	var names []string
+	switch t := decl.(type) {
+	case *ast.FuncDecl:
+		if t.Recv == nil {
+			names = append(names, t.Name.Name)
+		}
+	case *ast.GenDecl:
+		for _, spec := range t.Specs {
+			switch s := spec.(type) {
+			case *ast.TypeSpec:
+				names = append(names, s.Name.Name)
+			case *ast.ValueSpec:
+				for _, name := range s.Names {
+					names = append(names, name.Name)
+				}
+			}
+		}
+	}
+	return names
+}
+
+// decl converts a declaration parsed from source into the form produced by
+// the export data parsers, or returns nil if the declaration is not of
+// interest.
+func (p *source_parser) decl(decl ast.Decl) ast.Decl {
+	switch t := decl.(type) {
+	case *ast.FuncDecl:
+		if t.Recv != nil && method_of(t) == "" {
+			return nil
+		}
+		t.Body = nil
+		var hidden []string
+		if t.Recv != nil {
+			hidden = p.hide_names(receiver_type_params(t.Recv), hidden)
+		}
+		if t.Type.TypeParams != nil {
+			hidden = p.hide_names(field_list_names(t.Type.TypeParams), hidden)
+		}
+		t.Type = p.qualify(t.Type).(*ast.FuncType)
+		p.restore_names(hidden)
+		return t
+	case *ast.GenDecl:
+		switch t.Tok {
+		case token.CONST:
+			// fill in the implicit repetition of the previous type and values
+			var typ ast.Expr
+			var values []ast.Expr
+			for _, spec := range t.Specs {
+				vs := spec.(*ast.ValueSpec)
+				if vs.Type == nil && vs.Values == nil {
+					vs.Type = typ
+					vs.Values = values
+				}
+				typ, values = vs.Type, vs.Values
+			}
+			fallthrough
+		case token.VAR:
+			for _, spec := range t.Specs {
+				vs := spec.(*ast.ValueSpec)
+				if vs.Type != nil {
+					vs.Type = p.qualify(vs.Type)
+				}
+				for i, v := range vs.Values {
+					vs.Values[i] = p.qualify(v)
+				}
+				if t.Tok == token.VAR && vs.Type == nil && len(vs.Values) == 1 {
+					vs.Type = basic_lit_type(vs.Values[0])
+				}
+			}
+			return t
+		case token.TYPE:
+			for _, spec := range t.Specs {
+				ts := spec.(*ast.TypeSpec)
+				var hidden []string
+				if ts.TypeParams != nil {
+					hidden = p.hide_names(field_list_names(ts.TypeParams), hidden)
+					p.qualify_field_list(ts.TypeParams)
+				}
+				ts.Type = p.qualify(ts.Type)
+				p.restore_names(hidden)
+			}
+			return t
+		}
+	}
+	return nil
+}
+
+// qualify rewrites expression e so that references to package-level
+// identifiers and imported packages use full package names.
+func (p *source_parser) qualify(e ast.Expr) ast.Expr {
+	switch t := e.(type) {
+	case *ast.Ident:
+		if p.names[t.Name] {
+			return &ast.SelectorExpr{
+				X:   ast.NewIdent(p.self),
+				Sel: t,
+			}
+		}
+	case *ast.SelectorExpr:
+		if x, ok := t.X.(*ast.Ident); ok && !p.names[x.Name] {
+			if full, ok := p.imports[x.Name]; ok {
+				t.X = ast.NewIdent(full)
+			}
+			return t
+		}
+		t.X = p.qualify(t.X)
+	case *ast.StarExpr:
+		t.X = p.qualify(t.X)
+	case *ast.ParenExpr:
+		t.X = p.qualify(t.X)
+	case *ast.ArrayType:
+		t.Elt = p.qualify(t.Elt)
+	case *ast.Ellipsis:
+		if t.Elt != nil {
+			t.Elt = p.qualify(t.Elt)
+		}
+	case *ast.MapType:
+		t.Key = p.qualify(t.Key)
+		t.Value = p.qualify(t.Value)
+	case *ast.ChanType:
+		t.Value = p.qualify(t.Value)
+	case *ast.FuncType:
+		p.qualify_field_list(t.TypeParams)
+		t.Params = split_field_list(t.Params)
+		t.Results = split_field_list(t.Results)
+		p.qualify_field_list(t.Params)
+		p.qualify_field_list(t.Results)
+	case *ast.StructType:
+		p.qualify_field_list(t.Fields)
+	case *ast.InterfaceType:
+		p.qualify_field_list(t.Methods)
+	case *ast.FuncLit:
+		t.Type = p.qualify(t.Type).(*ast.FuncType)
+		t.Body = &ast.BlockStmt{}
+	case *ast.CompositeLit:
+		if t.Type != nil {
+			t.Type = p.qualify(t.Type)
+		}
+		t.Elts = nil
+	case *ast.CallExpr:
+		t.Fun = p.qualify(t.Fun)
+		for i, arg := range t.Args {
+			t.Args[i] = p.qualify(arg)
+		}
+	case *ast.UnaryExpr:
+		t.X = p.qualify(t.X)
+	case *ast.BinaryExpr:
+		t.X = p.qualify(t.X)
+		t.Y = p.qualify(t.Y)
+	case *ast.IndexExpr:
+		t.X = p.qualify(t.X)
+		t.Index = p.qualify(t.Index)
+	case *ast.IndexListExpr:
+		t.X = p.qualify(t.X)
+		for i, index := range t.Indices {
+			t.Indices[i] = p.qualify(index)
+		}
+	case *ast.SliceExpr:
+		t.X = p.qualify(t.X)
+	case *ast.TypeAssertExpr:
+		t.X = p.qualify(t.X)
+		if t.Type != nil {
+			t.Type = p.qualify(t.Type)
+		}
+	}
+	return e
+}
+
+// hide_names stops package-level identifiers shadowed by type parameters from
+// being qualified, the names hidden are appended to hidden.
+func (p *source_parser) hide_names(names []*ast.Ident, hidden []string) []string {
+	for _, name := range names {
+		if p.names[name.Name] {
+			p.names[name.Name] = false
+			hidden = append(hidden, name.Name)
+		}
+	}
+	return hidden
+}
+
+func (p *source_parser) restore_names(hidden []string) {
+	for _, name := range hidden {
+		p.names[name] = true
+	}
+}
+
+// basic_lit_type returns the default type of an untyped constant or nil if e
+// is not a literal.
+func basic_lit_type(e ast.Expr) ast.Expr {
+	lit, ok := e.(*ast.BasicLit)
+	if !ok {
+		return nil
+	}
+	switch lit.Kind {
+	case token.INT:
+		return ast.NewIdent("int")
+	case token.FLOAT:
+		return ast.NewIdent("float64")
+	case token.IMAG:
+		return ast.NewIdent("complex128")
+	case token.CHAR:
+		return ast.NewIdent("rune")
+	case token.STRING:
+		return ast.NewIdent("string")
+	}
+	return nil
+}
+
+func (p *source_parser) qualify_field_list(f *ast.FieldList) {
+	if f == nil {
+		return
+	}
+	for _, field := range f.List {
+		field.Type = p.qualify(field.Type)
+		field.Doc = nil
+		field.Comment = nil
+		field.Tag = nil
+	}
+}
+
+// split_field_list splits fields with multiple names into one field per name,
+// the way parameters are represented in export data: (x, y int) => (x int, y int).
+func split_field_list(f *ast.FieldList) *ast.FieldList {
+	if f == nil {
+		return nil
+	}
+	n := 0
+	for _, field := range f.List {
+		if len(field.Names) > 1 {
+			n += len(field.Names)
+		} else {
+			n++
+		}
+	}
+	if n == len(f.List) {
+		return f
+	}
+	list := make([]*ast.Field, 0, n)
+	for _, field := range f.List {
+		if len(field.Names) <= 1 {
+			list = append(list, field)
+			continue
+		}
+		for _, name := range field.Names {
+			list = append(list, &ast.Field{
+				Names: []*ast.Ident{name},
+				Type:  field.Type,
+			})
+		}
+	}
+	return &ast.FieldList{Opening: f.Opening, List: list, Closing: f.Closing}
+}
+
+// source_package_files returns the Go source files of the package in
+// directory dir that match the build context, test files are excluded. The
+// files of the overlay are read from it, those that do not exist on disk are
+// part of the package too.
+func source_package_files(dir string, context *package_lookup_context) ([]string, error) {
+	ctxt := context.Context
+	ctxt.IsDir = is_dir
+	ctxt.ReadDir = context.dirs.readdir_lstat
+	ctxt.OpenFile = func(name string) (io.ReadCloser, error) {
+		if data, ok := context.overlay[name]; ok {
+			return ioutil.NopCloser(bytes.NewReader(data)), nil
+		}
+		return os.Open(name)
+	}
+	overlay := overlay_go_files(dir, context)
+	p, err := ctxt.ImportDir(dir, 0)
+	if err != nil {
+		_, multiple := err.(*build.MultiplePackageError)
+		_, nogo := err.(*build.NoGoError)
+		if !(multiple || nogo && len(overlay) != 0) || p == nil {
+			return nil, err
+		}
+	}
+	files := make([]string, 0, len(p.GoFiles)+len(p.CgoFiles)+len(overlay))
+	for _, name := range p.GoFiles {
+		files = append(files, filepath.Join(dir, name))
+	}
+	for _, name := range p.CgoFiles {
+		files = append(files, filepath.Join(dir, name))
+	}
+	for _, abspath := range overlay {
+		name := filepath.Base(abspath)
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		if ok, err := ctxt.MatchFile(dir, name); err != nil || !ok {
+			continue
+		}
+		if pkg := file_package_name(abspath, context); pkg == p.Name || p.Name == "" {
+			p.Name = pkg
+			files = append(files, abspath)
+		}
+	}
+	return files, nil
+}
+
+// update_source_cache updates a package loaded from source. The cache is
+// keyed on the names, sizes and modification times of the package's source
+// files, or on the contents of those in the overlay.
+func (m *package_file_cache) update_source_cache() {
+	fi, err := fs.Stat(m.name)
+	if err != nil {
+		return
+	}
+	// the files of the overlay may change without the directory
+	t := fi.ModTime().UnixNano()
+	if m.files == nil || m.dirmtime != t || len(overlay_go_files(m.name, m.context)) != 0 {
+		files, err := source_package_files(m.name, m.context)
+		if err != nil {
+			return
+		}
+		m.files = files
+		m.dirmtime = t
+	}
+	files := m.files
+	var mtime int64
+	var size int64
+	h := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	for _, name := range files {
+		if data, ok := m.context.overlay[name]; ok {
+			size += int64(len(data))
+			fmt.Fprintf(h, "%s %d %x\n", name, len(data), crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)))
+			continue
+		}
+		fi, err := fs.Stat(name)
+		if err != nil {
+			return
+		}
+		if t := fi.ModTime().UnixNano(); t > mtime {
+			mtime = t
+		}
+		size += fi.Size()
+		fmt.Fprintf(h, "%s %d %d\n", name, fi.Size(), fi.ModTime().UnixNano())
+	}
+	sum := h.Sum32()
+	if m.mtime == mtime && m.size == size && m.checksum == sum && m.main != nil {
+		return
+	}
+	m.mtime = mtime
+	m.size = size
+	m.checksum = sum
+	m.process_package_source(files)
+}
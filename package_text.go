@@ -600,24 +600,28 @@ func strip_method_receiver(recv *ast.FieldList) string {
 	typ := recv.List[0].Type
 	switch t := typ.(type) {
 	case *ast.StarExpr:
-		sel, _ = t.X.(*ast.SelectorExpr)
-	case *ast.SelectorExpr:
-		sel = t
+		sel, _ = strip_type_args(t.X).(*ast.SelectorExpr)
+	case *ast.SelectorExpr, *ast.IndexExpr, *ast.IndexListExpr:
+		sel, _ = strip_type_args(t).(*ast.SelectorExpr)
 	}
 
 	// extract package path
 	if sel != nil {
 		pkg := sel.X.(*ast.Ident).Name
 
-		// write back stripped type
+		// write back stripped type, keeping the type parameters
+		var stripped ast.Expr = sel.Sel
+		if args := type_args(typ); args != nil {
+			stripped = new_index_expr(sel.Sel, args)
+		}
 		switch typ.(type) {
 		case *ast.StarExpr:
 			*recv = ast.FieldList{
-				List: []*ast.Field{{Names: recv.List[0].Names, Type: &ast.StarExpr{X: sel.Sel}}},
+				List: []*ast.Field{{Names: recv.List[0].Names, Type: &ast.StarExpr{X: stripped}}},
 			}
-		case *ast.SelectorExpr:
+		default:
 			*recv = ast.FieldList{
-				List: []*ast.Field{{Names: recv.List[0].Names, Type: sel.Sel}},
+				List: []*ast.Field{{Names: recv.List[0].Names, Type: stripped}},
 			}
 		}
 		return pkg
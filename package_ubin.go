@@ -0,0 +1,909 @@
+package gocode
+
+//-------------------------------------------------------------------------
+// gc_ubin_parser
+//
+// Reader for the "unified IR" export data format written by Go 1.20+
+// compilers.
+//
+// The following part of the code may contain portions of the code from the Go
+// standard library, which tells me to retain their copyright notice:
+//
+// Copyright (c) 2021 The Go Authors. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//    * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//    * Redistributions in binary form must reproduce the above
+// copyright notice, this list of conditions and the following disclaimer
+// in the documentation and/or other materials provided with the
+// distribution.
+//    * Neither the name of Google Inc. nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+// LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+// A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+// LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//-------------------------------------------------------------------------
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"strings"
+)
+
+// sections of the export data
+type ubinSection int
+
+const (
+	ubinSectionString ubinSection = iota
+	ubinSectionMeta
+	ubinSectionPosBase
+	ubinSectionPkg
+	ubinSectionName
+	ubinSectionType
+	ubinSectionObj
+	ubinSectionObjExt
+	ubinSectionObjDict
+	ubinSectionBody
+
+	ubinNumSections = iota
+)
+
+// export data versions, see internal/pkgbits
+const (
+	ubinV0 = iota
+	ubinV1 // adds the flags word
+	ubinV2 // removes legacy fields, adds type parameters of aliases
+	ubinV3 // compact composite literals (function bodies only)
+	ubinV4 // generic methods are encoded as standalone objects
+
+	ubinNumVersions = iota
+)
+
+const ubinFlagSyncMarkers = 1
+
+// sync markers, only present if the data was written with -d=syncframes
+type ubinSync int
+
+const (
+	_ ubinSync = iota
+	syncEOF
+	syncBool
+	syncInt64
+	syncUint64
+	syncString
+	syncValue
+	syncVal
+	syncRelocs
+	syncReloc
+	syncUseReloc
+	syncPublic
+	syncPos
+	syncPosBase
+	syncObject
+	syncObject1
+	syncPkg
+	syncPkgDef
+	syncMethod
+	syncType
+	syncTypeIdx
+	syncTypeParamNames
+	syncSignature
+	syncParams
+	syncParam
+	syncCodeObj
+	syncSym
+	syncLocalIdent
+	syncSelector
+)
+
+// type codes
+const (
+	ubinTypeBasic = iota
+	ubinTypeNamed
+	ubinTypePointer
+	ubinTypeSlice
+	ubinTypeArray
+	ubinTypeChan
+	ubinTypeMap
+	ubinTypeSignature
+	ubinTypeStruct
+	ubinTypeInterface
+	ubinTypeUnion
+	ubinTypeTypeParam
+)
+
+// object codes
+const (
+	ubinObjAlias = iota
+	ubinObjConst
+	ubinObjType
+	ubinObjFunc
+	ubinObjVar
+	ubinObjStub
+)
+
+// constant value codes
+const (
+	ubinValBool = iota
+	ubinValString
+	ubinValInt64
+	ubinValBigInt
+	ubinValBigRat
+	ubinValBigFloat
+)
+
+// go/types.BasicKind => type expression
+var ubin_basic_types = []ast.Expr{
+	ast.NewIdent(">_<"), // invalid
+	ast.NewIdent("bool"),
+	ast.NewIdent("int"),
+	ast.NewIdent("int8"),
+	ast.NewIdent("int16"),
+	ast.NewIdent("int32"),
+	ast.NewIdent("int64"),
+	ast.NewIdent("uint"),
+	ast.NewIdent("uint8"),
+	ast.NewIdent("uint16"),
+	ast.NewIdent("uint32"),
+	ast.NewIdent("uint64"),
+	ast.NewIdent("uintptr"),
+	ast.NewIdent("float32"),
+	ast.NewIdent("float64"),
+	ast.NewIdent("complex64"),
+	ast.NewIdent("complex128"),
+	ast.NewIdent("string"),
+	&ast.SelectorExpr{X: ast.NewIdent("unsafe"), Sel: ast.NewIdent("Pointer")},
+	ast.NewIdent("&untypedBool&"),
+	ast.NewIdent("&untypedInt&"),
+	ast.NewIdent("&untypedRune&"),
+	ast.NewIdent("&untypedFloat&"),
+	ast.NewIdent("&untypedComplex&"),
+	ast.NewIdent("&untypedString&"),
+	ast.NewIdent("&untypedNil&"),
+}
+
+type gc_ubin_parser struct {
+	callback func(pkg string, decl ast.Decl)
+	pfc      *package_file_cache
+
+	version      int
+	sync         bool
+	elemData     []byte
+	elemEnds     []uint32
+	elemEndsEnds [ubinNumSections]uint32
+
+	pkgs     []string // full package names, "" for the universe
+	bases    []string // file names of the position bases
+	typs     []ast.Expr
+	declared map[int]bool // objects by index
+}
+
+func (p *gc_ubin_parser) init(data []byte, pfc *package_file_cache) {
+	p.pfc = pfc
+
+	if len(data) < 4 {
+		panic("unified export data: unexpected EOF")
+	}
+	p.version = int(binary.LittleEndian.Uint32(data))
+	data = data[4:]
+	if p.version >= ubinNumVersions {
+		panic(fmt.Errorf("unknown unified export data version %d", p.version))
+	}
+	if p.version >= ubinV1 {
+		if len(data) < 4 {
+			panic("unified export data: unexpected EOF")
+		}
+		p.sync = binary.LittleEndian.Uint32(data)&ubinFlagSyncMarkers != 0
+		data = data[4:]
+	}
+
+	if len(data) < 4*ubinNumSections {
+		panic("unified export data: unexpected EOF")
+	}
+	for i := range p.elemEndsEnds {
+		p.elemEndsEnds[i] = binary.LittleEndian.Uint32(data[4*i:])
+	}
+	data = data[4*ubinNumSections:]
+
+	n := int(p.elemEndsEnds[ubinNumSections-1])
+	if len(data) < 4*n {
+		panic("unified export data: unexpected EOF")
+	}
+	p.elemEnds = make([]uint32, n)
+	for i := range p.elemEnds {
+		p.elemEnds[i] = binary.LittleEndian.Uint32(data[4*i:])
+	}
+	data = data[4*n:]
+
+	// the element data is followed by the 8 byte package fingerprint and
+	// whatever comes after the export data
+	if n > 0 && int(p.elemEnds[n-1]) > len(data) {
+		panic("unified export data: unexpected EOF")
+	}
+	p.elemData = data
+
+	p.pkgs = make([]string, p.numElems(ubinSectionPkg))
+	p.bases = make([]string, p.numElems(ubinSectionPosBase))
+	p.typs = make([]ast.Expr, p.numElems(ubinSectionType))
+	p.declared = make(map[int]bool)
+}
+
+func (p *gc_ubin_parser) parse_export(callback func(string, ast.Decl)) {
+	p.callback = callback
+
+	r := p.newReader(ubinSectionMeta, 0, syncPublic)
+	r.pkg()
+	if p.version < ubinV2 {
+		r.bool() // has init
+	}
+	for i, n := 0, r.len(); i < n; i++ {
+		r.sync(syncObject)
+		if p.version < ubinV2 {
+			r.bool() // derived func instance
+		}
+		p.objIdx(r.reloc(ubinSectionObj))
+		if r.len() != 0 {
+			panic("unified export data: unexpected type arguments")
+		}
+	}
+	r.sync(syncEOF)
+}
+
+func (p *gc_ubin_parser) numElems(k ubinSection) int {
+	n := int(p.elemEndsEnds[k])
+	if k > 0 {
+		n -= int(p.elemEndsEnds[k-1])
+	}
+	return n
+}
+
+func (p *gc_ubin_parser) dataIdx(k ubinSection, idx int) []byte {
+	abs := idx
+	if k > 0 {
+		abs += int(p.elemEndsEnds[k-1])
+	}
+	if abs >= int(p.elemEndsEnds[k]) {
+		panic(fmt.Sprintf("unified export data: element %d:%d out of bounds", k, idx))
+	}
+	var start uint32
+	if abs > 0 {
+		start = p.elemEnds[abs-1]
+	}
+	return p.elemData[start:p.elemEnds[abs]]
+}
+
+func (p *gc_ubin_parser) stringIdx(idx int) string {
+	return string(p.dataIdx(ubinSectionString, idx))
+}
+
+func (p *gc_ubin_parser) newReader(k ubinSection, idx int, marker ubinSync) *ubinReader {
+	r := &ubinReader{p: p}
+	r.data.Reset(p.dataIdx(k, idx))
+	r.sync(syncRelocs)
+	r.relocs = make([]ubinReloc, r.len())
+	for i := range r.relocs {
+		r.sync(syncReloc)
+		r.relocs[i] = ubinReloc{ubinSection(r.len()), r.len()}
+	}
+	r.sync(marker)
+	return r
+}
+
+func (p *gc_ubin_parser) pkgIdx(idx int) string {
+	if p.pkgs[idx] != "" {
+		return p.pkgs[idx]
+	}
+
+	r := p.newReader(ubinSectionPkg, idx, syncPkgDef)
+	var fullName string
+	switch path := r.string(); path {
+	case "":
+		// the package itself
+		name := r.string()
+		fullName = "!" + p.pfc.name + "!" + name
+		p.pfc.defalias = name
+	case "builtin":
+		return "" // universe
+	case "unsafe":
+		fullName = "unsafe"
+	default:
+		name := r.string()
+		fullName = "!" + path + "!" + name
+		p.pfc.add_package_to_scope(fullName, path)
+	}
+	p.pkgs[idx] = fullName
+	return fullName
+}
+
+func (p *gc_ubin_parser) posBaseIdx(idx int) string {
+	if p.bases[idx] != "" {
+		return p.bases[idx]
+	}
+
+	// the line bases of //line directives are followed by a position
+	// and a line and column, only the file name is of interest
+	r := p.newReader(ubinSectionPosBase, idx, syncPosBase)
+	p.bases[idx] = r.string()
+	return p.bases[idx]
+}
+
+// objIdx declares the object with index idx, if it wasn't already, and
+// returns its type expression.
+func (p *gc_ubin_parser) objIdx(idx int) ast.Expr {
+	rname := p.newReader(ubinSectionName, idx, syncObject1)
+	pkg, name := rname.qualifiedIdent()
+	tag := rname.code(syncCodeObj)
+
+	var ref ast.Expr
+	switch pkg {
+	case "":
+		ref = ast.NewIdent(name)
+	default:
+		ref = &ast.SelectorExpr{X: ast.NewIdent(pkg), Sel: ast.NewIdent(name)}
+	}
+
+	if tag == ubinObjStub || p.declared[idx] {
+		return ref
+	}
+	// local types promoted to the package scope and generic methods
+	if is_vargen_name(name) || strings.Contains(name, ".") {
+		return ref
+	}
+	p.declared[idx] = true
+
+	r := p.newReader(ubinSectionObj, idx, syncObject1)
+	r.dict = p.objDictIdx(idx)
+
+	switch tag {
+	case ubinObjAlias:
+		pos := r.declPos()
+		var tparams *ast.FieldList
+		if p.version >= ubinV2 {
+			tparams = r.typeParamNames(false)
+		}
+		spec := typeAliasSpec(name, r.typ(), pos)
+		spec.TypeParams = tparams
+		p.callback(pkg, &ast.GenDecl{
+			Tok:   token.TYPE,
+			Specs: []ast.Spec{spec},
+		})
+	case ubinObjConst:
+		pos := r.declPos()
+		typ := r.typ()
+		r.value()
+		p.callback(pkg, &ast.GenDecl{
+			Tok: token.CONST,
+			Specs: []ast.Spec{
+				&ast.ValueSpec{
+					Names:  []*ast.Ident{{Name: name, NamePos: pos}},
+					Type:   typ,
+					Values: []ast.Expr{&ast.BasicLit{Kind: token.INT, Value: "0"}},
+				},
+			},
+		})
+	case ubinObjFunc:
+		pos := r.declPos()
+		if p.version >= ubinV4 {
+			r.bool() // generic method, these are read with their type
+		}
+		tparams := r.typeParamNames(false)
+		sig := r.signature()
+		sig.TypeParams = tparams
+		p.callback(pkg, &ast.FuncDecl{
+			Name: &ast.Ident{Name: name, NamePos: pos},
+			Type: sig,
+		})
+	case ubinObjType:
+		pos := r.declPos()
+		tparams := r.typeParamNames(false)
+		p.callback(pkg, &ast.GenDecl{
+			Tok: token.TYPE,
+			Specs: []ast.Spec{
+				&ast.TypeSpec{
+					Name:       &ast.Ident{Name: name, NamePos: pos},
+					TypeParams: tparams,
+					Type:       r.typ(),
+				},
+			},
+		})
+
+		for n := r.len(); n > 0; n-- {
+			r.method(pkg)
+		}
+		if p.version >= ubinV4 {
+			for n := r.len(); n > 0; n-- {
+				p.genericMethod(pkg, r.reloc(ubinSectionObj))
+			}
+		}
+	case ubinObjVar:
+		pos := r.declPos()
+		p.callback(pkg, &ast.GenDecl{
+			Tok: token.VAR,
+			Specs: []ast.Spec{
+				&ast.ValueSpec{
+					Names: []*ast.Ident{{Name: name, NamePos: pos}},
+					Type:  r.typ(),
+				},
+			},
+		})
+	default:
+		panic(fmt.Sprintf("unexpected object code: %d", tag))
+	}
+	return ref
+}
+
+// is_vargen_name reports if name has a "·N" suffix, which is used for local
+// types promoted to the package scope.
+func is_vargen_name(name string) bool {
+	i := len(name)
+	for i > 0 && name[i-1] >= '0' && name[i-1] <= '9' {
+		i--
+	}
+	return i < len(name) && strings.HasSuffix(name[:i], "·")
+}
+
+func (p *gc_ubin_parser) genericMethod(pkg string, idx int) {
+	r := p.newReader(ubinSectionObj, idx, syncObject1)
+	r.dict = p.objDictIdx(idx)
+
+	pos := r.declPos()
+	r.bool() // generic method
+	_, name := r.selector()
+	r.typeParamNames(true)
+	recv := &ast.FieldList{List: []*ast.Field{r.param()}}
+	tparams := r.typeParamNames(false)
+	sig := r.signature()
+	sig.TypeParams = tparams
+	strip_method_receiver(recv)
+	p.callback(pkg, &ast.FuncDecl{
+		Recv: recv,
+		Name: &ast.Ident{Name: name, NamePos: pos},
+		Type: sig,
+	})
+}
+
+func (p *gc_ubin_parser) objDictIdx(idx int) *ubinDict {
+	r := p.newReader(ubinSectionObjDict, idx, syncObject1)
+	if r.len() != 0 {
+		panic("unified export data: unexpected implicit type parameters")
+	}
+	nreceivers := 0
+	if p.version >= ubinV4 {
+		nreceivers = r.len()
+	}
+	dict := &ubinDict{
+		rtbounds: make([]ubinTypeInfo, nreceivers),
+		tbounds:  make([]ubinTypeInfo, r.len()),
+	}
+	for i := range dict.rtbounds {
+		dict.rtbounds[i] = r.typInfo()
+	}
+	for i := range dict.tbounds {
+		dict.tbounds[i] = r.typInfo()
+	}
+	dict.derived = make([]int, r.len())
+	dict.derivedTypes = make([]ast.Expr, len(dict.derived))
+	for i := range dict.derived {
+		dict.derived[i] = r.reloc(ubinSectionType)
+		if p.version < ubinV2 {
+			r.bool() // needed
+		}
+	}
+	return dict
+}
+
+func (p *gc_ubin_parser) typIdx(info ubinTypeInfo, dict *ubinDict) ast.Expr {
+	idx := info.idx
+	where := &p.typs[idx]
+	if info.derived {
+		where = &dict.derivedTypes[idx]
+		idx = dict.derived[idx]
+	}
+	if *where != nil {
+		return *where
+	}
+
+	r := p.newReader(ubinSectionType, idx, syncTypeIdx)
+	r.dict = dict
+	typ := r.doTyp()
+	if *where == nil {
+		*where = typ
+	}
+	return *where
+}
+
+type ubinReloc struct {
+	k   ubinSection
+	idx int
+}
+
+type ubinTypeInfo struct {
+	idx     int
+	derived bool
+}
+
+// ubinDict holds the type parameters of the object being read.
+type ubinDict struct {
+	rtbounds []ubinTypeInfo // receiver type parameters of generic methods
+	rtparams []ast.Expr
+	tbounds  []ubinTypeInfo
+	tparams  []ast.Expr
+
+	derived      []int // types derived from the type parameters
+	derivedTypes []ast.Expr
+}
+
+type ubinReader struct {
+	p      *gc_ubin_parser
+	data   bytes.Reader
+	relocs []ubinReloc
+	dict   *ubinDict
+}
+
+func (r *ubinReader) rawUvarint() uint64 {
+	x, err := binary.ReadUvarint(&r.data)
+	if err != nil {
+		panic(fmt.Sprintf("readUvarint: %v", err))
+	}
+	return x
+}
+
+func (r *ubinReader) rawVarint() int64 {
+	ux := r.rawUvarint()
+	// zig-zag decode
+	x := int64(ux >> 1)
+	if ux&1 != 0 {
+		x = ^x
+	}
+	return x
+}
+
+func (r *ubinReader) sync(want ubinSync) {
+	if !r.p.sync {
+		return
+	}
+	have := ubinSync(r.rawUvarint())
+	for n := r.rawUvarint(); n > 0; n-- {
+		r.rawUvarint() // writer PCs
+	}
+	if have != want {
+		panic(fmt.Sprintf("unified export data desync: found %d, expected %d", have, want))
+	}
+}
+
+func (r *ubinReader) bool() bool {
+	r.sync(syncBool)
+	x, err := r.data.ReadByte()
+	if err != nil {
+		panic(fmt.Sprintf("data.ReadByte: %v", err))
+	}
+	return x != 0
+}
+
+func (r *ubinReader) int64() int64 {
+	r.sync(syncInt64)
+	return r.rawVarint()
+}
+
+func (r *ubinReader) uint64() uint64 {
+	r.sync(syncUint64)
+	return r.rawUvarint()
+}
+
+func (r *ubinReader) len() int { return int(r.uint64()) }
+
+func (r *ubinReader) code(marker ubinSync) int {
+	r.sync(marker)
+	return r.len()
+}
+
+func (r *ubinReader) reloc(k ubinSection) int {
+	r.sync(syncUseReloc)
+	e := r.relocs[r.len()]
+	if e.k != k {
+		panic(fmt.Sprintf("unified export data: unexpected section %d, expected %d", e.k, k))
+	}
+	return e.idx
+}
+
+func (r *ubinReader) string() string {
+	r.sync(syncString)
+	return r.p.stringIdx(r.reloc(ubinSectionString))
+}
+
+// we don't care about constant values, let's just skip them
+func (r *ubinReader) value() {
+	r.sync(syncValue)
+	complex := r.bool()
+	r.scalar()
+	if complex {
+		r.scalar()
+	}
+}
+
+func (r *ubinReader) scalar() {
+	switch tag := r.code(syncVal); tag {
+	case ubinValBool:
+		r.bool()
+	case ubinValString:
+		r.string()
+	case ubinValInt64:
+		r.int64()
+	case ubinValBigInt:
+		r.string()
+		r.bool()
+	case ubinValBigRat:
+		r.string()
+		r.bool()
+		r.string()
+		r.bool()
+	case ubinValBigFloat:
+		r.string()
+	default:
+		panic(fmt.Sprintf("unexpected scalar tag: %d", tag))
+	}
+}
+
+// we don't care about positions either, except those of declarations
+func (r *ubinReader) pos() {
+	r.sync(syncPos)
+	if !r.bool() {
+		return
+	}
+	r.reloc(ubinSectionPosBase)
+	r.uint64() // line
+	r.uint64() // column
+}
+
+// declPos reads the position of a declaration, a field or a method.
+func (r *ubinReader) declPos() token.Pos {
+	r.sync(syncPos)
+	if !r.bool() {
+		return token.NoPos
+	}
+	file := r.p.posBaseIdx(r.reloc(ubinSectionPosBase))
+	line := r.uint64()
+	column := r.uint64()
+	return r.p.pfc.add_position(file, int(line), int(column))
+}
+
+func (r *ubinReader) pkg() string {
+	r.sync(syncPkg)
+	return r.p.pkgIdx(r.reloc(ubinSectionPkg))
+}
+
+func (r *ubinReader) ident(marker ubinSync) (string, string) {
+	r.sync(marker)
+	pkg := r.pkg()
+	return pkg, r.string()
+}
+
+func (r *ubinReader) qualifiedIdent() (string, string) { return r.ident(syncSym) }
+func (r *ubinReader) localIdent() (string, string)     { return r.ident(syncLocalIdent) }
+func (r *ubinReader) selector() (string, string)       { return r.ident(syncSelector) }
+
+func (r *ubinReader) typInfo() ubinTypeInfo {
+	r.sync(syncType)
+	if r.bool() {
+		return ubinTypeInfo{idx: r.len(), derived: true}
+	}
+	return ubinTypeInfo{idx: r.reloc(ubinSectionType)}
+}
+
+func (r *ubinReader) typ() ast.Expr {
+	return r.p.typIdx(r.typInfo(), r.dict)
+}
+
+func (r *ubinReader) doTyp() ast.Expr {
+	switch tag := r.code(syncType); tag {
+	case ubinTypeBasic:
+		kind := r.len()
+		if kind >= len(ubin_basic_types) {
+			panic(fmt.Sprintf("unexpected basic type: %d", kind))
+		}
+		return ubin_basic_types[kind]
+	case ubinTypeNamed:
+		r.sync(syncObject)
+		if r.p.version < ubinV2 {
+			r.bool() // derived func instance
+		}
+		typ := r.p.objIdx(r.reloc(ubinSectionObj))
+		targs := make([]ast.Expr, r.len())
+		for i := range targs {
+			targs[i] = r.typ()
+		}
+		if len(targs) != 0 {
+			return new_index_expr(typ, targs)
+		}
+		return typ
+	case ubinTypeTypeParam:
+		n := r.len()
+		if n < len(r.dict.rtbounds) {
+			return r.dict.rtparams[n]
+		}
+		return r.dict.tparams[n-len(r.dict.rtbounds)]
+	case ubinTypeArray:
+		n := r.uint64()
+		return &ast.ArrayType{
+			Len: &ast.BasicLit{Kind: token.INT, Value: fmt.Sprint(n)},
+			Elt: r.typ(),
+		}
+	case ubinTypeChan:
+		dir := ast.SEND | ast.RECV
+		switch d := r.len(); d {
+		case 0:
+			// already set
+		case 1:
+			dir = ast.SEND
+		case 2:
+			dir = ast.RECV
+		default:
+			panic(fmt.Sprintf("unexpected channel dir %d", d))
+		}
+		return &ast.ChanType{Dir: dir, Value: r.typ()}
+	case ubinTypeMap:
+		key := r.typ()
+		return &ast.MapType{Key: key, Value: r.typ()}
+	case ubinTypePointer:
+		return &ast.StarExpr{X: r.typ()}
+	case ubinTypeSignature:
+		return r.signature()
+	case ubinTypeSlice:
+		return &ast.ArrayType{Elt: r.typ()}
+	case ubinTypeStruct:
+		fields := make([]*ast.Field, r.len())
+		for i := range fields {
+			fpos := r.declPos()
+			_, fname := r.selector()
+			ftyp := r.typ()
+			r.string() // tag
+			var names []*ast.Ident
+			if !r.bool() { // embedded
+				names = []*ast.Ident{{Name: fname, NamePos: fpos}}
+			}
+			fields[i] = &ast.Field{Names: names, Type: ftyp}
+		}
+		return &ast.StructType{Fields: &ast.FieldList{List: fields}}
+	case ubinTypeInterface:
+		methods := make([]*ast.Field, r.len())
+		embeddeds := make([]ast.Expr, r.len())
+		implicit := len(methods) == 0 && len(embeddeds) == 1 && r.bool()
+		for i := range methods {
+			mpos := r.declPos()
+			_, mname := r.selector()
+			methods[i] = &ast.Field{
+				Names: []*ast.Ident{{Name: mname, NamePos: mpos}},
+				Type:  r.signature(),
+			}
+		}
+		for i := range embeddeds {
+			embeddeds[i] = r.typ()
+		}
+		if implicit {
+			// constraint written without the interface: [T ~int | ~uint]
+			return embeddeds[0]
+		}
+		for _, typ := range embeddeds {
+			methods = append(methods, &ast.Field{Type: typ})
+		}
+		return &ast.InterfaceType{Methods: &ast.FieldList{List: methods}}
+	case ubinTypeUnion:
+		var union ast.Expr
+		for n := r.len(); n > 0; n-- {
+			tilde := r.bool()
+			term := r.typ()
+			if tilde {
+				term = &ast.UnaryExpr{Op: token.TILDE, X: term}
+			}
+			if union == nil {
+				union = term
+			} else {
+				union = &ast.BinaryExpr{X: union, Op: token.OR, Y: term}
+			}
+		}
+		return union
+	default:
+		panic(fmt.Sprintf("unexpected type code: %d", tag))
+	}
+}
+
+func (r *ubinReader) signature() *ast.FuncType {
+	r.sync(syncSignature)
+	params := r.params()
+	results := r.params()
+	if r.bool() { // variadic
+		last := params.List[len(params.List)-1]
+		last.Type = &ast.Ellipsis{Elt: last.Type.(*ast.ArrayType).Elt}
+	}
+	return &ast.FuncType{Params: params, Results: results}
+}
+
+func (r *ubinReader) params() *ast.FieldList {
+	r.sync(syncParams)
+	xs := make([]*ast.Field, r.len())
+	for i := range xs {
+		xs[i] = r.param()
+	}
+	return &ast.FieldList{List: xs}
+}
+
+func (r *ubinReader) param() *ast.Field {
+	r.sync(syncParam)
+	r.pos()
+	_, name := r.localIdent()
+	if name == "" { // gocode specific hack for unnamed parameters
+		name = "?"
+	}
+	return &ast.Field{
+		Names: []*ast.Ident{ast.NewIdent(name)},
+		Type:  r.typ(),
+	}
+}
+
+func (r *ubinReader) method(pkg string) {
+	r.sync(syncMethod)
+	pos := r.declPos()
+	_, name := r.selector()
+	r.typeParamNames(false)
+	recv := &ast.FieldList{List: []*ast.Field{r.param()}}
+	sig := r.signature()
+	r.pos()
+	strip_method_receiver(recv)
+	r.p.callback(pkg, &ast.FuncDecl{
+		Recv: recv,
+		Name: &ast.Ident{Name: name, NamePos: pos},
+		Type: sig,
+	})
+}
+
+// typeParamNames reads the type parameters of the object being read, or
+// those of the receiver of a generic method.
+func (r *ubinReader) typeParamNames(recv bool) *ast.FieldList {
+	r.sync(syncTypeParamNames)
+
+	bounds := r.dict.tbounds
+	tparams := &r.dict.tparams
+	if recv {
+		bounds = r.dict.rtbounds
+		tparams = &r.dict.rtparams
+	}
+	if len(bounds) == 0 {
+		return nil
+	}
+
+	// the constraints may refer to the type parameters, set up the names
+	// before reading them
+	names := make([]ast.Expr, len(bounds))
+	for i := range names {
+		r.pos()
+		_, name := r.localIdent()
+		names[i] = ast.NewIdent(name)
+	}
+	*tparams = names
+
+	fields := make([]*ast.Field, len(bounds))
+	for i, info := range bounds {
+		fields[i] = &ast.Field{
+			Names: []*ast.Ident{names[i].(*ast.Ident)},
+			Type:  r.p.typIdx(info, r.dict),
+		}
+	}
+	return &ast.FieldList{List: fields}
+}
@@ -0,0 +1,59 @@
+package gocode
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// exportData returns the export data of package path written by the go
+// command, which uses the unified IR format since Go 1.20.
+func exportData(t *testing.T, path string) []byte {
+	out, err := exec.Command("go", "list", "-export", "-f", "{{.Export}}", path).Output()
+	if err != nil {
+		t.Skipf("go list -export %s: %v", path, err)
+	}
+	data, err := ioutil.ReadFile(strings.TrimSpace(string(out)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := bytes.Index(data, []byte("\n$$B\n"))
+	if i == -1 || len(data) < i+6 || data[i+5] != 'u' {
+		t.Skipf("export data of %s is not in the unified IR format", path)
+	}
+	return data
+}
+
+func TestUnifiedExportData(t *testing.T) {
+	tests := []struct {
+		path  string
+		decl  string
+		child string
+		typ   string
+	}{
+		{"fmt", "Println", "", "func(a ...any) (n int, err error)"},
+		{"sync/atomic", "Pointer", "Load", "func() *T"},
+		{"slices", "Contains", "", "func[S ~[]E, E comparable](s S, v E) bool"},
+		{"go/ast", "File", "Decls", "[]ast.Decl"},
+	}
+	var buf bytes.Buffer
+	for _, x := range tests {
+		pfc := new_package_file_cache(x.path, x.path, nil)
+		pfc.process_package_data(exportData(t, x.path))
+		d := pfc.main.children[x.decl]
+		if d != nil && x.child != "" {
+			d = d.children[x.child]
+		}
+		if d == nil {
+			t.Errorf("%s: missing declaration %s %s", x.path, x.decl, x.child)
+			continue
+		}
+		buf.Reset()
+		d.pretty_print_type(&buf, nil)
+		if buf.String() != x.typ {
+			t.Errorf("%s: %s %s: got %q want %q", x.path, x.decl, x.child, buf.String(), x.typ)
+		}
+	}
+}
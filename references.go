@@ -0,0 +1,206 @@
+package gocode
+
+import (
+	"context"
+	"go/scanner"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+//-------------------------------------------------------------------------
+// references
+//
+// Finds the identifiers of the files of the current package that refer to
+// the declaration of the identifier at the cursor. Every identifier with
+// the name of the declaration is resolved like the one at the cursor, as if
+// the cursor was at it, so that the selectors of fields and methods are
+// resolved through the types of their operands.
+//-------------------------------------------------------------------------
+
+// References returns the positions of the identifiers of the package of
+// file name, the contents of which are file, that refer to the declaration
+// of the identifier at offset cursor, including the declaration itself, if
+// it belongs to the package. The other files of the package are read from
+// the overlay (see Config.Overlay) or the disk, and the _test.go files are
+// only searched if tests is set, or if name is one. The positions are
+// sorted by file name and offset, their columns are in bytes. It reports
+// false if the declaration is not known. See Complete for the engine that is
+// used.
+func (c *Config) References(file []byte, name string, cursor int, tests bool) ([]token.Position, bool) {
+	refs, ok, _ := default_engine.references(context.Background(), file, name, cursor, tests, c)
+	return refs, ok
+}
+
+// References is like Config.References, but uses the configuration of the
+// engine.
+func (e *Engine) References(file []byte, name string, cursor int, tests bool) ([]token.Position, bool) {
+	refs, ok, _ := e.references(context.Background(), file, name, cursor, tests, nil)
+	return refs, ok
+}
+
+// ReferencesContext is like References, but stops looking for the
+// references once ctx is done, in which case the error of ctx is returned.
+func (e *Engine) ReferencesContext(ctx context.Context, file []byte, name string, cursor int, tests bool) ([]token.Position, bool, error) {
+	return e.references(ctx, file, name, cursor, tests, nil)
+}
+
+func (e *Engine) references(ctx context.Context, file []byte, name string, cursor int, tests bool, conf *Config) (refs []token.Position, ok bool, err error) {
+	if cursor < 0 || cursor > len(file) {
+		return nil, false, nil
+	}
+	err = e.run(ctx, name, conf, func() {
+		refs, ok = e.autocomplete.references(ctx, file, filepath.Clean(name), cursor, tests)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return refs, ok, nil
+}
+
+// references processes each file of the package once, see process_file, and
+// resolves the identifiers with the name of the declaration against it.
+func (c *auto_complete_context) references(ctx context.Context, file []byte, filename string, cursor int, tests bool) ([]token.Position, bool) {
+	// the cursor may be anywhere in the identifier
+	start, end := cursor, ident_end(file, cursor)
+	for start > 0 {
+		r, size := utf8.DecodeLastRune(file[:start])
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			break
+		}
+		start -= size
+	}
+	ident := string(file[start:end])
+	if ident == "" {
+		return nil, false
+	}
+
+	// the other files see the file being edited through the overlay
+	lctx := c.declcache.context
+	overlay := lctx.overlay
+	lctx.overlay = make(map[string][]byte, len(overlay)+1)
+	for name, data := range overlay {
+		lctx.overlay[name] = data
+	}
+	lctx.overlay[filename] = file
+	defer func() { lctx.overlay = overlay }()
+
+	c.process_file(ctx, file, filename)
+	d := c.reference_decl(file, start, ident)
+	if d == nil {
+		return nil, false
+	}
+	pos := d.position()
+
+	files := []string{filename}
+	for _, name := range find_other_package_files(filename, c.current.package_name, lctx) {
+		if tests || !strings.HasSuffix(name, "_test.go") {
+			files = append(files, name)
+		}
+	}
+
+	var refs []token.Position
+	seen := make(map[token.Position]bool)
+	add := func(name string, data []byte, off int) {
+		p := new_position(data, off)
+		ref := token.Position{Filename: name, Offset: off, Line: p.Line, Column: p.Column}
+		if !seen[ref] {
+			seen[ref] = true
+			refs = append(refs, ref)
+		}
+	}
+	for i, name := range files {
+		data := file
+		if i > 0 {
+			var err error
+			if data, err = lctx.read_file(name); err != nil {
+				continue
+			}
+			c.process_file(ctx, data, name)
+		}
+		if name == pos.Filename && pos.Offset+len(ident) <= len(data) &&
+			string(data[pos.Offset:pos.Offset+len(ident)]) == ident {
+			add(name, data, pos.Offset)
+		}
+		for _, off := range ident_offsets(data, ident) {
+			if same_decl(c.reference_decl(data, off, ident), d, pos) {
+				add(name, data, off)
+			}
+		}
+	}
+
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Filename != refs[j].Filename {
+			return refs[i].Filename < refs[j].Filename
+		}
+		return refs[i].Offset < refs[j].Offset
+	})
+	return refs, true
+}
+
+// reference_decl returns the declaration identifier ident at offset off of
+// src, the file processed by process_file, refers to. The keys of struct
+// literals, which cursor_ident_decl takes for plain names, are fields.
+func (c *auto_complete_context) reference_decl(src []byte, off int, ident string) *decl {
+	end := off + len(ident)
+	c.set_cursor(end)
+	d, _ := c.cursor_ident_decl(src, end, c.set_cursor)
+
+	// <type>{..., <ident>: ...}
+	for end < len(src) && (src[end] == ' ' || src[end] == '\t') {
+		end++
+	}
+	if end == len(src) || src[end] != ':' || end+1 < len(src) && src[end+1] == '=' {
+		return d
+	}
+	iter := new_token_iterator(src, off)
+	if len(iter.tokens) == 0 {
+		return d
+	}
+	if tok := iter.token().tok; tok != token.LBRACE && tok != token.COMMA {
+		return d
+	}
+	if s := c.deduce_struct_type_decl(&iter); s != nil {
+		return s.find_child_and_in_embedded(ident)
+	}
+	return d
+}
+
+// same_decl reports whether x is declaration d, which is at position pos.
+// The declarations of a package are loaded again for each of its files,
+// those with positions are compared by position.
+func same_decl(x, d *decl, pos token.Position) bool {
+	if x == nil {
+		return false
+	}
+	if x == d {
+		return true
+	}
+	if x.name != d.name || !pos.IsValid() {
+		return false
+	}
+	xpos := x.position()
+	return xpos.Filename == pos.Filename && xpos.Line == pos.Line && xpos.Column == pos.Column
+}
+
+// ident_offsets returns the offsets of the identifiers named name of src.
+func ident_offsets(src []byte, name string) []int {
+	var s scanner.Scanner
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	s.Init(file, src, nil, 0)
+
+	var offsets []int
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			return offsets
+		}
+		if tok == token.IDENT && lit == name {
+			offsets = append(offsets, file.Offset(pos))
+		}
+	}
+}
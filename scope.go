@@ -1,5 +1,7 @@
 package gocode
 
+import "go/token"
+
 //-------------------------------------------------------------------------
 // scope
 //-------------------------------------------------------------------------
@@ -9,6 +11,10 @@ type scope struct {
 	pkgname  string
 	parent   *scope // nil for universe scope
 	entities map[string]*decl
+
+	// resolves the positions of the declarations made in this scope and in
+	// its children, nil to use the one of the parent
+	positions func(token.Pos) token.Position
 }
 
 func new_named_scope(outer *scope, name string) *scope {
@@ -81,3 +87,16 @@ func (s *scope) lookup(name string) *decl {
 	}
 	return decl
 }
+
+// position returns the position p of a declaration made in s.
+func (s *scope) position(p token.Pos) token.Position {
+	if !p.IsValid() {
+		return token.Position{}
+	}
+	for ; s != nil; s = s.parent {
+		if s.positions != nil {
+			return s.positions(p)
+		}
+	}
+	return token.Position{}
+}
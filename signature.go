@@ -0,0 +1,143 @@
+package gocode
+
+import (
+	"bytes"
+	"context"
+	"go/ast"
+	"go/parser"
+	"strings"
+)
+
+//-------------------------------------------------------------------------
+// signature help
+//
+// Finds the function called at the cursor and the argument the cursor is
+// at, so that editors can show the signature of the function while its
+// arguments are typed.
+//-------------------------------------------------------------------------
+
+// Signature is the signature of the function called at a cursor.
+type Signature struct {
+	Name   string   // name of the function, if any
+	Type   string   // type of the function, "func(a int, b string) error"
+	Params []string // parameters of the function, "a int"
+	Active int      // index of the argument at the cursor
+}
+
+// SignatureHelp returns the signature of the function called at offset
+// cursor of file name, the contents of which are file. It reports false
+// if the cursor is not in the arguments of a call. See Complete for the
+// engine that is used.
+func (c *Config) SignatureHelp(file []byte, name string, cursor int) (Signature, bool) {
+	return default_engine.signature_help(file, name, cursor, c)
+}
+
+// SignatureHelp is like Config.SignatureHelp, but uses the configuration of
+// the engine.
+func (e *Engine) SignatureHelp(file []byte, name string, cursor int) (Signature, bool) {
+	return e.signature_help(file, name, cursor, nil)
+}
+
+func (e *Engine) signature_help(file []byte, name string, cursor int, conf *Config) (sig Signature, ok bool) {
+	e.run(context.Background(), name, conf, func() {
+		sig, ok = e.autocomplete.signature_help(file, name, cursor)
+	})
+	return sig, ok
+}
+
+func (c *auto_complete_context) signature_help(file []byte, filename string, cursor int) (Signature, bool) {
+	c.process(context.Background(), file, filename, cursor)
+
+	iter := new_token_iterator(file, cursor)
+	active, ok := iter.skip_to_call()
+	if !ok {
+		return Signature{}, false
+	}
+	expr, err := parser.ParseExpr(iter.extract_go_expr())
+	if err != nil {
+		return Signature{}, false
+	}
+
+	var sig Signature
+	switch t := expr.(type) {
+	case *ast.Ident:
+		sig.Name = t.Name
+		if d := c.current.scope.lookup(t.Name); d != nil && d.scope == g_universe_scope {
+			return builtin_signature(d, active)
+		}
+	case *ast.SelectorExpr:
+		sig.Name = t.Sel.Name
+	case *ast.IndexExpr, *ast.IndexListExpr:
+		// explicitly instantiated generic function
+		if id, ok := strip_type_args(t).(*ast.Ident); ok {
+			sig.Name = id.Name
+		}
+	}
+
+	ft, _ := func_type_of(expr, c.current.scope)
+	if ft == nil {
+		return Signature{}, false
+	}
+
+	aliases := new_out_buffers(c).canonical_aliases
+	var buf bytes.Buffer
+	pretty_print_type_expr(&buf, ft, aliases)
+	sig.Type = buf.String()
+
+	variadic := false
+	if ft.Params != nil {
+		for _, field := range ft.Params.List {
+			buf.Reset()
+			pretty_print_type_expr(&buf, field.Type, aliases)
+			typ := buf.String()
+			if len(field.Names) == 0 {
+				sig.Params = append(sig.Params, typ)
+			}
+			for _, name := range field.Names {
+				sig.Params = append(sig.Params, name.Name+" "+typ)
+			}
+			_, variadic = field.Type.(*ast.Ellipsis)
+		}
+	}
+	sig.Active = active
+	if variadic && active >= len(sig.Params) {
+		sig.Active = len(sig.Params) - 1
+	}
+	return sig, true
+}
+
+// builtin_signature returns the signature of builtin function d, the type of
+// which is only described by the name of its type, see g_universe_scope.
+func builtin_signature(d *decl, active int) (Signature, bool) {
+	id, ok := d.typ.(*ast.Ident)
+	if d.class != decl_func || !ok || !strings.HasPrefix(id.Name, "func(") {
+		return Signature{}, false
+	}
+	sig := Signature{Name: d.name, Type: id.Name, Active: active}
+
+	// split the parameters at the commas outside of brackets
+	params := id.Name[len("func("):]
+	depth, start := 0, 0
+	for i := 0; i < len(params) && depth >= 0; i++ {
+		switch params[i] {
+		case '(', '[':
+			depth++
+		case ')', ']':
+			depth--
+		case ',':
+			if depth != 0 {
+				continue
+			}
+			sig.Params = append(sig.Params, strings.TrimSpace(params[start:i]))
+			start = i + 1
+		}
+		if depth < 0 && start < i {
+			sig.Params = append(sig.Params, strings.TrimSpace(params[start:i]))
+		}
+	}
+	n := len(sig.Params)
+	if n != 0 && active >= n && strings.HasPrefix(sig.Params[n-1], "...") {
+		sig.Active = n - 1
+	}
+	return sig, true
+}
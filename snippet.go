@@ -0,0 +1,171 @@
+package gocode
+
+import (
+	"bytes"
+	"go/ast"
+	"strconv"
+	"strings"
+)
+
+//-------------------------------------------------------------------------
+// snippets
+//
+// Builds the calls of the func candidates in the snippet syntax of the
+// Language Server Protocol, with a placeholder for each argument, from the
+// types of the declarations (see Config.Snippets).
+//-------------------------------------------------------------------------
+
+// func_snippet returns the snippet calling function name of type ft of scope
+// s, like "Foo(${1:a int}, ${2:b ...string})". The type parameters that can
+// not be inferred from the arguments are placeholders too: "New[${1:T any}]()".
+// It returns "" if ft is not a function type.
+func func_snippet(name string, ft ast.Expr, s *scope, canonical_aliases map[string]string) string {
+	t, ok := ft.(*ast.FuncType)
+	if !ok {
+		return ""
+	}
+	var buf, tmp bytes.Buffer
+	n := 0
+	placeholder := func(name string, typ ast.Expr) {
+		n++
+		tmp.Reset()
+		if name != "" {
+			tmp.WriteString(name)
+			tmp.WriteByte(' ')
+		}
+		snippet_type(&tmp, typ, s, canonical_aliases)
+		buf.WriteString("${" + strconv.Itoa(n) + ":")
+		buf.WriteString(escape_snippet(tmp.String()))
+		buf.WriteByte('}')
+	}
+	// placeholders writes a placeholder for each of the fields, separated
+	// by commas
+	placeholders := func(fields []*ast.Field) {
+		first := true
+		for _, f := range fields {
+			names := f.Names
+			if len(names) == 0 {
+				names = []*ast.Ident{nil}
+			}
+			for _, id := range names {
+				if !first {
+					buf.WriteString(", ")
+				}
+				first = false
+				pname := ""
+				if id != nil && id.Name != "_" {
+					pname = id.Name
+				}
+				placeholder(pname, f.Type)
+			}
+		}
+	}
+
+	buf.WriteString(name)
+	if explicit := explicit_type_params(t.TypeParams, t.Params); len(explicit) != 0 {
+		buf.WriteByte('[')
+		placeholders(explicit)
+		buf.WriteByte(']')
+	}
+	buf.WriteByte('(')
+	if t.Params != nil {
+		placeholders(t.Params.List)
+	}
+	buf.WriteByte(')')
+	return buf.String()
+}
+
+// snippet_type writes type expression e of scope s to out like
+// pretty_print_type_expr, but with the methods of the anonymous interfaces,
+// which are the type of the parameter: interface{ M() } and not interface{}.
+func snippet_type(out *bytes.Buffer, e ast.Expr, s *scope, canonical_aliases map[string]string) {
+	switch t := e.(type) {
+	case *ast.Ident:
+		if strings.HasPrefix(t.Name, "$i") && s != nil {
+			if d := s.lookup(t.Name); d != nil {
+				if it, ok := d.typ.(*ast.InterfaceType); ok {
+					snippet_type(out, it, d.scope, canonical_aliases)
+					return
+				}
+			}
+		}
+	case *ast.InterfaceType:
+		if t.Methods.NumFields() == 0 {
+			break
+		}
+		out.WriteString("interface{ ")
+		for i, m := range t.Methods.List {
+			if i > 0 {
+				out.WriteString("; ")
+			}
+			if len(m.Names) == 0 {
+				// embedded interface
+				snippet_type(out, m.Type, s, canonical_aliases)
+				continue
+			}
+			// M func(x int) error => M(x int) error
+			var sig bytes.Buffer
+			pretty_print_type_expr(&sig, m.Type, canonical_aliases)
+			out.WriteString(m.Names[0].Name)
+			out.Write(bytes.TrimPrefix(sig.Bytes(), []byte("func")))
+		}
+		out.WriteString(" }")
+		return
+	case *ast.StarExpr:
+		out.WriteByte('*')
+		snippet_type(out, t.X, s, canonical_aliases)
+		return
+	case *ast.ArrayType:
+		if t.Len == nil {
+			out.WriteString("[]")
+			snippet_type(out, t.Elt, s, canonical_aliases)
+			return
+		}
+	case *ast.Ellipsis:
+		out.WriteString("...")
+		snippet_type(out, t.Elt, s, canonical_aliases)
+		return
+	}
+	pretty_print_type_expr(out, e, canonical_aliases)
+}
+
+// explicit_type_params returns the type parameters of tparams up to the last
+// one that is not used by the parameters params, and that can not be
+// inferred from the arguments of a call.
+func explicit_type_params(tparams, params *ast.FieldList) []*ast.Field {
+	if tparams == nil || len(tparams.List) == 0 {
+		return nil
+	}
+	used := make(map[string]bool)
+	if params != nil {
+		for _, f := range params.List {
+			ast.Inspect(f.Type, func(n ast.Node) bool {
+				if id, ok := n.(*ast.Ident); ok {
+					used[id.Name] = true
+				}
+				return true
+			})
+		}
+	}
+
+	// the type arguments are given in order, up to the last one needed
+	var fields []*ast.Field
+	last := -1
+	for _, f := range tparams.List {
+		for _, name := range f.Names {
+			fields = append(fields, &ast.Field{Names: []*ast.Ident{name}, Type: f.Type})
+			if !used[name.Name] {
+				last = len(fields) - 1
+			}
+		}
+	}
+	return fields[:last+1]
+}
+
+var snippet_escaper = strings.NewReplacer(`\`, `\\`, `$`, `\$`, `}`, `\}`)
+
+// escape_snippet escapes the characters of s that are special in the text of
+// a snippet placeholder.
+func escape_snippet(s string) string {
+	return snippet_escaper.Replace(s)
+}
@@ -0,0 +1,245 @@
+package gocode
+
+import (
+	"bytes"
+	"context"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"sort"
+	"unicode"
+	"unicode/utf8"
+)
+
+//-------------------------------------------------------------------------
+// interface stubs
+//
+// Generates the declarations of the methods of an interface that a type of
+// the current package is missing, to implement the interface.
+//-------------------------------------------------------------------------
+
+// ImplementStubs returns the edit of file name, the contents of which are
+// file, that adds the declarations of the methods of interface iface that
+// the type at offset cursor does not have, with bodies that panic. iface is
+// a type expression like "io.ReadWriteCloser" or "Store", which is resolved
+// at the cursor. The type is the receiver of the method declaration at the
+// cursor, like "func (r *Repo) ", which the stubs use and the edit replaces,
+// or else the type named by the identifier at the cursor, with a pointer
+// receiver if it is a struct, after the declaration of which the edit
+// inserts the stubs. The types of the methods are qualified by the names
+// under which the file imports their packages. It reports false if the type
+// or the interface is not known. See Complete for the engine that is used.
+func (c *Config) ImplementStubs(file []byte, name string, cursor int, iface string) (TextEdit, bool) {
+	return default_engine.implement_stubs(file, name, cursor, iface, c)
+}
+
+// ImplementStubs is like Config.ImplementStubs, but uses the configuration
+// of the engine.
+func (e *Engine) ImplementStubs(file []byte, name string, cursor int, iface string) (TextEdit, bool) {
+	return e.implement_stubs(file, name, cursor, iface, nil)
+}
+
+func (e *Engine) implement_stubs(file []byte, name string, cursor int, iface string, conf *Config) (edit TextEdit, ok bool) {
+	if cursor < 0 || cursor > len(file) {
+		return TextEdit{}, false
+	}
+	e.run(context.Background(), name, conf, func() {
+		edit, ok = e.autocomplete.implement_stubs(file, name, cursor, iface)
+	})
+	return edit, ok
+}
+
+func (c *auto_complete_context) implement_stubs(file []byte, filename string, cursor int, iface_expr string) (TextEdit, bool) {
+	d, _ := c.ident_decl(file, filename, cursor)
+	recv, td, start := c.stub_receiver(file, cursor)
+	end := ident_end(file, cursor) // func (r *Repo) Na#me
+	if td == nil && d != nil && d.class == decl_type && c.decl_package_import_path(d) == "" {
+		recv, td = default_receiver(d), d
+		start, end = -1, decl_end(file, cursor)
+	}
+	if td == nil {
+		return TextEdit{}, false
+	}
+
+	iface := c.stub_interface(iface_expr)
+	if iface == nil {
+		return TextEdit{}, false
+	}
+
+	// the missing methods, by name
+	var missing []*decl
+	seen := make(map[string]bool)
+	foreach_interface_method(iface, func(m *decl) {
+		if seen[m.name] {
+			return
+		}
+		seen[m.name] = true
+		if have := td.find_child_and_in_embedded(m.name); have == nil || have.class != decl_func {
+			missing = append(missing, m)
+		}
+	})
+	sort.Slice(missing, func(i, j int) bool {
+		return missing[i].name < missing[j].name
+	})
+
+	if len(missing) == 0 {
+		pos := new_position(file, cursor)
+		return TextEdit{Start: pos, End: pos}, true
+	}
+
+	aliases := new_out_buffers(c).canonical_aliases
+	var buf bytes.Buffer
+	if start == -1 {
+		// after the declaration of the type
+		start = end
+		buf.WriteString("\n\n")
+	}
+	for i, m := range missing {
+		if i > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString("func ")
+		buf.WriteString(recv)
+		buf.WriteByte(' ')
+		buf.WriteString(m.name)
+		// func(p []byte) (n int, err error) => (p []byte) (n int, err error)
+		sig := bytes.NewBuffer(nil)
+		pretty_print_type_expr(sig, m.typ, aliases)
+		buf.Write(sig.Bytes()[len("func"):])
+		buf.WriteString(" {\n\tpanic(\"unimplemented\")\n}")
+		if i < len(missing)-1 {
+			buf.WriteByte('\n')
+		}
+	}
+	return TextEdit{
+		Start: new_position(file, start),
+		End:   new_position(file, end),
+		Text:  buf.String(),
+	}, true
+}
+
+// stub_receiver returns the receiver of the method declaration at the
+// cursor, like "(r *Repo)", the declaration of its type and the offset of
+// the func keyword of the declaration, if the cursor is after the receiver.
+func (c *auto_complete_context) stub_receiver(file []byte, cursor int) (string, *decl, int) {
+	iter := new_token_iterator(file, cursor)
+	if len(iter.tokens) == 0 {
+		return "", nil, 0
+	}
+	if tok := iter.token(); tok.tok == token.IDENT && tok.off+len(tok.lit) >= cursor {
+		// func (r *Repo) Na#
+		if !iter.go_back() {
+			return "", nil, 0
+		}
+	}
+	if iter.token().tok != token.RPAREN {
+		return "", nil, 0
+	}
+	end := iter.token().off + 1
+	if !iter.skip_to_balanced_pair() {
+		return "", nil, 0
+	}
+	start := iter.token().off
+	if !iter.go_back() || iter.token().tok != token.FUNC {
+		return "", nil, 0
+	}
+	fn := iter.token().off
+
+	recv := string(file[start:end])
+	src := "package p; func " + recv + " _() {}"
+	f, err := parser.ParseFile(token.NewFileSet(), "", src, 0)
+	if err != nil || len(f.Decls) != 1 {
+		return "", nil, 0
+	}
+	fd, ok := f.Decls[0].(*ast.FuncDecl)
+	if !ok || fd.Recv == nil || len(fd.Recv.List) != 1 {
+		return "", nil, 0
+	}
+	t := fd.Recv.List[0].Type
+	if star, ok := t.(*ast.StarExpr); ok {
+		t = star.X
+	}
+	id, ok := strip_type_args(t).(*ast.Ident)
+	if !ok {
+		return "", nil, 0
+	}
+	d := c.current.scope.lookup(id.Name)
+	if d == nil || d.class != decl_type {
+		return "", nil, 0
+	}
+	return recv, d, fn
+}
+
+// decl_end returns the offset of the end of the top-level declaration of
+// file at offset cursor, or of the end of file.
+func decl_end(file []byte, cursor int) int {
+	fset := token.NewFileSet()
+	f, _ := parser.ParseFile(fset, "", file, 0)
+	if f == nil {
+		return len(file)
+	}
+	tf := fset.File(f.Pos())
+	for _, d := range f.Decls {
+		if tf.Offset(d.Pos()) <= cursor && cursor <= tf.Offset(d.End()) {
+			return tf.Offset(d.End())
+		}
+	}
+	return len(file)
+}
+
+// default_receiver returns the receiver of the methods of type d: its first
+// letter in lower case, and a pointer if d is a struct.
+func default_receiver(d *decl) string {
+	r, _ := utf8.DecodeRuneInString(d.name)
+	var buf bytes.Buffer
+	buf.WriteByte('(')
+	buf.WriteRune(unicode.ToLower(r))
+	buf.WriteByte(' ')
+	if _, ok := d.typ.(*ast.StructType); ok {
+		buf.WriteByte('*')
+	}
+	buf.WriteString(d.name)
+	if d.tparams != nil {
+		buf.WriteByte('[')
+		for i, name := range field_list_names(d.tparams) {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(name.Name)
+		}
+		buf.WriteByte(']')
+	}
+	buf.WriteByte(')')
+	return buf.String()
+}
+
+// stub_interface returns the interface of type expression expr, which is
+// resolved at the cursor. The packages that are not imported are looked up
+// in the package index if Config.UnimportedPackages is set.
+func (c *auto_complete_context) stub_interface(expr string) *decl {
+	e, err := parser.ParseExpr(expr)
+	if err != nil {
+		return nil
+	}
+	d := type_to_decl(e, c.current.scope)
+	if d == nil && c.declcache.context.config.UnimportedPackages() {
+		if sel, ok := strip_type_args(e).(*ast.SelectorExpr); ok {
+			if id, ok := sel.X.(*ast.Ident); ok {
+				if pkg, _ := c.resolveKnownPackageIdent(id.Name); pkg != nil {
+					d = pkg.find_child(sel.Sel.Name)
+				}
+			}
+		}
+	}
+	if d == nil {
+		return nil
+	}
+	d = advance_to_struct_or_interface(d)
+	if d == nil {
+		return nil
+	}
+	if _, ok := d.typ.(*ast.InterfaceType); !ok {
+		return nil
+	}
+	return d
+}
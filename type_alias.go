@@ -1,14 +1,13 @@
-// +build go1.9 go1.8.typealias
-
 package gocode
 
 import (
 	"go/ast"
+	"go/token"
 )
 
-func typeAliasSpec(name string, typ ast.Expr) *ast.TypeSpec {
+func typeAliasSpec(name string, typ ast.Expr, pos token.Pos) *ast.TypeSpec {
 	return &ast.TypeSpec{
-		Name:   ast.NewIdent(name),
+		Name:   &ast.Ident{Name: name, NamePos: pos},
 		Assign: 1,
 		Type:   typ,
 	}
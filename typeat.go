@@ -0,0 +1,96 @@
+package gocode
+
+import (
+	"bytes"
+	"context"
+)
+
+//-------------------------------------------------------------------------
+// type at
+//
+// Describes the identifier at the cursor: what it is, its type and where it
+// comes from, for editors to show when hovering over it.
+//-------------------------------------------------------------------------
+
+// TypeInfo describes the declaration of an identifier.
+type TypeInfo struct {
+	Name    string `json:"name"`
+	Class   string `json:"class"`   // like Candidate.Class
+	Type    string `json:"type"`    // like Candidate.Type
+	Package string `json:"package"` // import path of the declaring package
+	Doc     string `json:"doc"`     // doc comment, if available
+}
+
+// TypeAt describes the identifier at offset cursor of file name, the
+// contents of which are file. The cursor may be anywhere in the identifier,
+// which is either a plain name or the selector of a package member, a field
+// or a method. It reports false if the identifier is not known. Unless the
+// docs are collected (see Config.Docs), the doc comment is read from the
+// source of the declaration, if available. See Complete for the engine that
+// is used.
+func (c *Config) TypeAt(file []byte, name string, cursor int) (TypeInfo, bool) {
+	return default_engine.type_at(file, name, cursor, c)
+}
+
+// TypeAt is like Config.TypeAt, but uses the configuration of the engine.
+func (e *Engine) TypeAt(file []byte, name string, cursor int) (TypeInfo, bool) {
+	return e.type_at(file, name, cursor, nil)
+}
+
+func (e *Engine) type_at(file []byte, name string, cursor int, conf *Config) (ti TypeInfo, ok bool) {
+	if cursor < 0 || cursor > len(file) {
+		return ti, false
+	}
+	e.run(context.Background(), name, conf, func() {
+		ti, ok = e.autocomplete.type_at(file, name, cursor)
+	})
+	return ti, ok
+}
+
+func (c *auto_complete_context) type_at(file []byte, filename string, cursor int) (TypeInfo, bool) {
+	d, name := c.ident_decl(file, filename, cursor)
+	if d == nil || !d.matches() {
+		return TypeInfo{}, false
+	}
+	// members of packages loaded from source may only have a value
+	d.infer_type()
+
+	var buf bytes.Buffer
+	d.pretty_print_type(&buf, new_out_buffers(c).canonical_aliases)
+	ti := TypeInfo{
+		Name:    name,
+		Class:   d.class.String(),
+		Type:    buf.String(),
+		Package: c.decl_package(d),
+		Doc:     d.doc, // only collected if Config.Docs is set
+	}
+
+	if pos := d.position(); ti.Doc == "" && pos.IsValid() {
+		src := file
+		if pos.Filename != filename {
+			src, _ = c.current.context.read_file(pos.Filename)
+		}
+		if src != nil {
+			ti.Doc = doc_comment(src, pos)
+		}
+	}
+	return ti, true
+}
+
+// decl_package returns the import path of the package that declares d, the
+// current package unless it is imported.
+func (c *auto_complete_context) decl_package(d *decl) string {
+	if d.scope == g_universe_scope {
+		return ""
+	}
+	if d.class == decl_package {
+		if pkg, ok := c.pcache[d.name]; ok {
+			return pkg.import_name
+		}
+		return ""
+	}
+	if path := c.decl_package_import_path(d); path != "" {
+		return path
+	}
+	return c.current.context.CurrentPackagePath
+}
@@ -0,0 +1,498 @@
+package gocode
+
+import (
+	"go/ast"
+	"go/token"
+	"reflect"
+)
+
+//-------------------------------------------------------------------------
+// type parameters
+//
+// Declarations of generic types and functions keep their type expressions
+// in terms of their type parameters. An instantiation substitutes type
+// arguments into copies of these expressions. The copies live in a scope
+// nested into the scope of the generic declaration, which makes the names
+// used by the type arguments visible to them.
+//-------------------------------------------------------------------------
+
+func field_list_names(f *ast.FieldList) []*ast.Ident {
+	var names []*ast.Ident
+	for _, field := range f.List {
+		names = append(names, field.Names...)
+	}
+	return names
+}
+
+// receiver_type_params returns the type parameters of a method receiver:
+// (l *List[T]) => T.
+func receiver_type_params(recv *ast.FieldList) []*ast.Ident {
+	if len(recv.List) == 0 {
+		return nil
+	}
+	var names []*ast.Ident
+	for _, arg := range type_args(recv.List[0].Type) {
+		if ident, ok := arg.(*ast.Ident); ok {
+			names = append(names, ident)
+		}
+	}
+	return names
+}
+
+// receiver_type_param_fields returns the type parameters of a method
+// receiver named names with the constraints of the type parameters tparams
+// of its type: [T any, S ~[]T] and (s *Set[E, X]) => [E any, X ~[]E].
+func receiver_type_param_fields(names []*ast.Ident, tparams *ast.FieldList) []*ast.Field {
+	var tnames []*ast.Ident
+	var constraints []ast.Expr
+	if tparams != nil {
+		for _, field := range tparams.List {
+			for _, name := range field.Names {
+				tnames = append(tnames, name)
+				constraints = append(constraints, field.Type)
+			}
+		}
+	}
+	if len(tnames) != len(names) {
+		tnames, constraints = nil, nil
+	}
+
+	m := make(map[string]ast.Expr, len(tnames))
+	for i, name := range tnames {
+		m[name.Name] = names[i]
+	}
+	fields := make([]*ast.Field, len(names))
+	for i, name := range names {
+		fields[i] = &ast.Field{Names: []*ast.Ident{name}}
+		if constraints != nil {
+			fields[i].Type = subst_type_params(constraints[i], m)
+		}
+	}
+	return fields
+}
+
+// ast_decl_type_params returns the type parameters of a generic type or of
+// the receiver of a method of a generic type.
+func ast_decl_type_params(d ast.Decl) *ast.FieldList {
+	switch t := d.(type) {
+	case *ast.GenDecl:
+		if t.Tok == token.TYPE {
+			return t.Specs[0].(*ast.TypeSpec).TypeParams
+		}
+	case *ast.FuncDecl:
+		if t.Recv == nil {
+			break
+		}
+		if names := receiver_type_params(t.Recv); names != nil {
+			return &ast.FieldList{List: []*ast.Field{{Names: names}}}
+		}
+	}
+	return nil
+}
+
+// type_args returns the type arguments of instantiation e or nil if e is
+// not an instantiation: *List[int] => int.
+func type_args(e ast.Expr) []ast.Expr {
+	for {
+		star, ok := e.(*ast.StarExpr)
+		if !ok {
+			break
+		}
+		e = star.X
+	}
+	switch t := e.(type) {
+	case *ast.IndexExpr:
+		return []ast.Expr{t.Index}
+	case *ast.IndexListExpr:
+		return t.Indices
+	}
+	return nil
+}
+
+// declare_type_args makes the names used by type argument e, which makes
+// sense in scope from, visible in scope s.
+func declare_type_args(s *scope, e ast.Expr, from *scope) {
+	if from == nil {
+		return
+	}
+	declare := func(ident *ast.Ident) {
+		d := from.lookup(ident.Name)
+		if d != nil && s.lookup(ident.Name) != d {
+			s.add_decl(ident.Name, d)
+		}
+	}
+	ast.Inspect(e, func(n ast.Node) bool {
+		switch t := n.(type) {
+		case *ast.SelectorExpr:
+			// only the package name makes sense in a scope
+			if ident, ok := t.X.(*ast.Ident); ok {
+				declare(ident)
+			}
+			return false
+		case *ast.Ident:
+			declare(t)
+		}
+		return true
+	})
+}
+
+func type_params_map(tparams *ast.FieldList, args []ast.Expr) map[string]ast.Expr {
+	m := make(map[string]ast.Expr, len(args))
+	for i, name := range field_list_names(tparams) {
+		if i < len(args) {
+			m[name.Name] = args[i]
+		}
+	}
+	return m
+}
+
+// subst_type_params returns a copy of type expression e with type parameters
+// replaced by their type arguments in m.
+func subst_type_params(e ast.Expr, m map[string]ast.Expr) ast.Expr {
+	switch t := e.(type) {
+	case *ast.Ident:
+		if arg, ok := m[t.Name]; ok {
+			return arg
+		}
+	case *ast.StarExpr:
+		return &ast.StarExpr{X: subst_type_params(t.X, m)}
+	case *ast.ParenExpr:
+		return &ast.ParenExpr{X: subst_type_params(t.X, m)}
+	case *ast.ArrayType:
+		return &ast.ArrayType{Len: t.Len, Elt: subst_type_params(t.Elt, m)}
+	case *ast.Ellipsis:
+		return &ast.Ellipsis{Elt: subst_type_params(t.Elt, m)}
+	case *ast.MapType:
+		return &ast.MapType{
+			Key:   subst_type_params(t.Key, m),
+			Value: subst_type_params(t.Value, m),
+		}
+	case *ast.ChanType:
+		return &ast.ChanType{Dir: t.Dir, Value: subst_type_params(t.Value, m)}
+	case *ast.FuncType:
+		return &ast.FuncType{
+			TypeParams: subst_field_list(t.TypeParams, m),
+			Params:     subst_field_list(t.Params, m),
+			Results:    subst_field_list(t.Results, m),
+		}
+	case *ast.StructType:
+		return &ast.StructType{Fields: subst_field_list(t.Fields, m)}
+	case *ast.InterfaceType:
+		return &ast.InterfaceType{Methods: subst_field_list(t.Methods, m)}
+	case *ast.IndexExpr:
+		return &ast.IndexExpr{X: t.X, Index: subst_type_params(t.Index, m)}
+	case *ast.IndexListExpr:
+		indices := make([]ast.Expr, len(t.Indices))
+		for i, index := range t.Indices {
+			indices[i] = subst_type_params(index, m)
+		}
+		return &ast.IndexListExpr{X: t.X, Indices: indices}
+	case *ast.BinaryExpr:
+		return &ast.BinaryExpr{
+			X:  subst_type_params(t.X, m),
+			Op: t.Op,
+			Y:  subst_type_params(t.Y, m),
+		}
+	case *ast.UnaryExpr:
+		return &ast.UnaryExpr{Op: t.Op, X: subst_type_params(t.X, m)}
+	}
+	return e
+}
+
+func subst_field_list(f *ast.FieldList, m map[string]ast.Expr) *ast.FieldList {
+	if f == nil {
+		return nil
+	}
+	list := make([]*ast.Field, len(f.List))
+	for i, field := range f.List {
+		list[i] = &ast.Field{
+			Names: field.Names,
+			Type:  subst_type_params(field.Type, m),
+			Tag:   field.Tag,
+		}
+	}
+	return &ast.FieldList{List: list}
+}
+
+// instantiate returns an instance of generic type d with type arguments args,
+// which make sense in scope argscope. Children and embedded types of the
+// instance refer to the type arguments instead of the type parameters.
+func (d *decl) instantiate(args []ast.Expr, argscope *scope) *decl {
+	if len(args) != len(field_list_names(d.tparams)) {
+		return d
+	}
+	new_instance_scope := func(outer *scope) *scope {
+		s := new_scope(outer)
+		for _, arg := range args {
+			declare_type_args(s, arg, argscope)
+		}
+		return s
+	}
+
+	m := type_params_map(d.tparams, args)
+	inst := &decl{
+		name:        d.name,
+		class:       d.class,
+		flags:       d.flags &^ decl_visited,
+		typ:         subst_type_params(d.typ, m),
+		value_index: -1,
+		scope:       new_instance_scope(d.scope),
+		origin:      d,
+		pos:         d.pos,
+		doc:         d.doc,
+	}
+	if len(d.embedded) != 0 {
+		inst.embedded = make([]ast.Expr, len(d.embedded))
+		for i, e := range d.embedded {
+			inst.embedded[i] = subst_type_params(e, m)
+		}
+	}
+	if len(d.children) != 0 {
+		inst.children = make(map[string]*decl, len(d.children))
+		for name, c := range d.children {
+			// methods may name the type parameters differently
+			cm := m
+			if c.tparams != nil {
+				cm = type_params_map(c.tparams, args)
+			}
+			cs := inst.scope
+			if c.scope != d.scope {
+				cs = new_instance_scope(c.scope)
+			}
+			inst.children[name] = &decl{
+				name:        c.name,
+				class:       c.class,
+				flags:       c.flags &^ decl_visited,
+				typ:         subst_type_params(c.typ, cm),
+				value_index: -1,
+				scope:       cs,
+				pos:         c.pos,
+				doc:         c.doc,
+			}
+		}
+	}
+	return inst
+}
+
+//-------------------------------------------------------------------------
+// Generic functions
+//-------------------------------------------------------------------------
+
+// type argument of a generic function and the scope where it makes sense
+type type_arg struct {
+	typ   ast.Expr
+	scope *scope
+}
+
+// explicit_type_args binds the type parameters of generic function type f to
+// type arguments args in order: Map[int, string].
+func explicit_type_args(f *ast.FuncType, args []ast.Expr, scope *scope) map[string]type_arg {
+	targs := make(map[string]type_arg, len(args))
+	for i, name := range field_list_names(f.TypeParams) {
+		if i >= len(args) {
+			break
+		}
+		targs[name.Name] = type_arg{args[i], scope}
+	}
+	return targs
+}
+
+// infer_type_args infers the type arguments of a call of generic function
+// type f, which makes sense in scope fs, with arguments args, which make sense
+// in scope. Type parameters are matched against the types of the arguments
+// first, then against the core types of their constraints:
+// func Index[S ~[]E, E comparable](s S, v E).
+func infer_type_args(f *ast.FuncType, fs *scope, args []ast.Expr, scope *scope) map[string]type_arg {
+	tparams := make(map[string]bool)
+	for _, name := range field_list_names(f.TypeParams) {
+		tparams[name.Name] = true
+	}
+	targs := make(map[string]type_arg)
+
+	var params []ast.Expr
+	if f.Params != nil {
+		for _, field := range f.Params.List {
+			n := len(field.Names)
+			if n == 0 {
+				n = 1
+			}
+			for ; n > 0; n-- {
+				params = append(params, field.Type)
+			}
+		}
+	}
+
+	// untyped constants have the lowest priority, they take their default
+	// type only if no typed argument binds the type parameter
+	var untyped []int
+	param := func(i int) ast.Expr {
+		if i >= len(params) {
+			i = len(params) - 1
+			if i < 0 {
+				return nil
+			}
+			if _, ok := params[i].(*ast.Ellipsis); !ok {
+				return nil
+			}
+		}
+		if e, ok := params[i].(*ast.Ellipsis); ok {
+			return e.Elt
+		}
+		return params[i]
+	}
+	for i, arg := range args {
+		p := param(i)
+		if p == nil {
+			break
+		}
+		if _, ok := arg.(*ast.BasicLit); ok {
+			untyped = append(untyped, i)
+			continue
+		}
+		at, as, _ := infer_type(arg, scope, -1)
+		unify_type_params(p, at, as, tparams, targs)
+	}
+	for _, i := range untyped {
+		unify_type_params(param(i), basic_lit_type(args[i]), g_universe_scope, tparams, targs)
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, field := range f.TypeParams.List {
+			core := core_type(field.Type, fs)
+			if core == nil {
+				continue
+			}
+			for _, name := range field.Names {
+				if arg, ok := targs[name.Name]; ok {
+					n := len(targs)
+					unify_type_params(core, arg.typ, arg.scope, tparams, targs)
+					changed = changed || len(targs) != n
+				}
+			}
+		}
+	}
+	return targs
+}
+
+// unify_type_params binds the type parameters used by parameter type p to the
+// corresponding parts of argument type a, which makes sense in scope.
+func unify_type_params(p, a ast.Expr, scope *scope, tparams map[string]bool, targs map[string]type_arg) {
+	if p == nil || a == nil {
+		return
+	}
+	if ident, ok := p.(*ast.Ident); ok {
+		if _, ok := targs[ident.Name]; tparams[ident.Name] && !ok {
+			targs[ident.Name] = type_arg{a, scope}
+		}
+		return
+	}
+
+	kind := func(e ast.Expr) reflect.Type {
+		if _, ok := e.(*ast.IndexListExpr); ok {
+			return reflect.TypeOf((*ast.IndexExpr)(nil))
+		}
+		return reflect.TypeOf(e)
+	}
+	if kind(p) != kind(a) {
+		// a named type, try its underlying type
+		want := kind(p)
+		a, scope = advance_to_type(func(e ast.Expr) bool {
+			return kind(e) == want
+		}, a, scope)
+		if a == nil {
+			return
+		}
+	}
+
+	switch p := p.(type) {
+	case *ast.StarExpr:
+		unify_type_params(p.X, a.(*ast.StarExpr).X, scope, tparams, targs)
+	case *ast.ArrayType:
+		unify_type_params(p.Elt, a.(*ast.ArrayType).Elt, scope, tparams, targs)
+	case *ast.MapType:
+		a := a.(*ast.MapType)
+		unify_type_params(p.Key, a.Key, scope, tparams, targs)
+		unify_type_params(p.Value, a.Value, scope, tparams, targs)
+	case *ast.ChanType:
+		unify_type_params(p.Value, a.(*ast.ChanType).Value, scope, tparams, targs)
+	case *ast.IndexExpr, *ast.IndexListExpr:
+		pargs, aargs := type_args(p), type_args(a)
+		for i := 0; i < len(pargs) && i < len(aargs); i++ {
+			unify_type_params(pargs[i], aargs[i], scope, tparams, targs)
+		}
+	case *ast.FuncType:
+		a := a.(*ast.FuncType)
+		unify_field_lists(p.Params, a.Params, scope, tparams, targs)
+		unify_field_lists(p.Results, a.Results, scope, tparams, targs)
+	}
+}
+
+func unify_field_lists(p, a *ast.FieldList, scope *scope, tparams map[string]bool, targs map[string]type_arg) {
+	if p == nil || a == nil {
+		return
+	}
+	for i := 0; i < len(p.List) && i < len(a.List); i++ {
+		unify_type_params(p.List[i].Type, a.List[i].Type, scope, tparams, targs)
+	}
+}
+
+// core_type returns the single type term of constraint c, which makes sense
+// in scope, or nil if there is none: ~[]E => []E.
+func core_type(c ast.Expr, scope *scope) ast.Expr {
+	switch t := c.(type) {
+	case *ast.UnaryExpr:
+		if t.Op == token.TILDE {
+			return t.X
+		}
+	case *ast.Ident:
+		// interface{ ~[]E } is an anonymous type
+		d := scope.lookup(t.Name)
+		if d != nil && d.class == decl_type && len(d.children) == 0 && len(d.embedded) == 1 {
+			return core_type(d.embedded[0], d.scope)
+		}
+	case *ast.ArrayType, *ast.MapType, *ast.ChanType, *ast.StarExpr, *ast.FuncType:
+		return t
+	}
+	return nil
+}
+
+// instantiate_func returns an instance of generic function type f, which
+// makes sense in scope, with type parameters bound to type arguments targs.
+// Type parameters without a type argument are kept.
+func instantiate_func(f *ast.FuncType, scope *scope, targs map[string]type_arg) (*ast.FuncType, *scope) {
+	if len(targs) == 0 {
+		return f, scope
+	}
+	s := new_scope(scope)
+	m := make(map[string]ast.Expr, len(targs))
+	for name, arg := range targs {
+		declare_type_args(s, arg.typ, arg.scope)
+		m[name] = arg.typ
+	}
+
+	var tparams *ast.FieldList
+	for _, field := range f.TypeParams.List {
+		var names []*ast.Ident
+		for _, name := range field.Names {
+			if _, ok := targs[name.Name]; !ok {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			continue
+		}
+		if tparams == nil {
+			tparams = new(ast.FieldList)
+		}
+		tparams.List = append(tparams.List, &ast.Field{
+			Names: names,
+			Type:  subst_type_params(field.Type, m),
+		})
+	}
+	return &ast.FuncType{
+		TypeParams: tparams,
+		Params:     subst_field_list(f.Params, m),
+		Results:    subst_field_list(f.Results, m),
+	}, s
+}
@@ -0,0 +1,472 @@
+package gocode
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//-------------------------------------------------------------------------
+// package_index
+//
+// Index of the packages of GOROOT, GOPATH and the module cache keyed by
+// their names, to complete the packages that are not imported yet (see
+// Config.UnimportedPackages). It is built in the background the first time
+// it is used, until then the lookups see the packages indexed so far.
+//-------------------------------------------------------------------------
+
+// ranks of the indexed packages, the lower the better
+const (
+	rank_known    = iota // knownPackageIdents
+	rank_goroot          // standard library
+	rank_required        // required by the current module
+	rank_gopath
+	rank_mod_cache
+)
+
+type indexed_package struct {
+	path string // import path
+	rank int
+}
+
+type package_index struct {
+	once sync.Once
+	done chan struct{} // closed once the index is built
+	quit chan struct{} // closed to stop building the index
+
+	mu   sync.Mutex
+	pkgs map[string][]indexed_package
+}
+
+func new_package_index() *package_index {
+	return &package_index{
+		done: make(chan struct{}),
+		quit: make(chan struct{}),
+		pkgs: make(map[string][]indexed_package),
+	}
+}
+
+// start starts building the index with the roots of ctxt, the first time.
+func (x *package_index) start(ctxt *package_lookup_context) {
+	x.once.Do(func() {
+		type root struct {
+			dir  string
+			rank int
+		}
+		var roots []root
+		if ctxt.GOROOT != "" {
+			roots = append(roots, root{filepath.Join(ctxt.GOROOT, "src"), rank_goroot})
+		}
+		for _, p := range ctxt.gopath() {
+			roots = append(roots, root{filepath.Join(p, "src"), rank_gopath})
+		}
+		if ctxt.GOMODCACHE != "" {
+			roots = append(roots, root{ctxt.GOMODCACHE, rank_mod_cache})
+		}
+		go func() {
+			defer close(x.done)
+			seen := make(map[string]bool)
+			for _, r := range roots {
+				x.walk(r.dir, "", r.rank, seen)
+			}
+		}()
+	})
+}
+
+// close stops building the index and waits for it to stop. The index is
+// not built if it is not yet.
+func (x *package_index) close() {
+	x.once.Do(func() { close(x.done) })
+	select {
+	case <-x.quit:
+	default:
+		close(x.quit)
+	}
+	<-x.done
+}
+
+// lookup returns the import paths of the packages named name, best ranked
+// first. The packages required by the current module of ctxt rank right
+// after the standard library, the packages of knownPackageIdents first,
+// which are known before they are indexed.
+func (x *package_index) lookup(name string, ctxt *package_lookup_context) []string {
+	x.start(ctxt)
+
+	x.mu.Lock()
+	pkgs := append([]indexed_package(nil), x.pkgs[name]...)
+	x.mu.Unlock()
+	known := false
+	for i, p := range pkgs {
+		switch {
+		case knownPackageIdents[name] == p.path:
+			pkgs[i].rank = rank_known
+			known = true
+		case p.rank > rank_required && ctxt.CurrentModule != nil:
+			if _, main, ok := ctxt.CurrentModule.lookup(p.path); ok && !main {
+				pkgs[i].rank = rank_required
+			}
+		}
+	}
+	if path, ok := knownPackageIdents[name]; ok && !known {
+		pkgs = append(pkgs, indexed_package{path, rank_known})
+	}
+	sort.SliceStable(pkgs, func(i, j int) bool {
+		if pkgs[i].rank != pkgs[j].rank {
+			return pkgs[i].rank < pkgs[j].rank
+		}
+		if len(pkgs[i].path) != len(pkgs[j].path) {
+			return len(pkgs[i].path) < len(pkgs[j].path)
+		}
+		return pkgs[i].path < pkgs[j].path
+	})
+
+	paths := make([]string, len(pkgs))
+	for i, p := range pkgs {
+		paths[i] = p.path
+	}
+	return paths
+}
+
+// names calls f with the names of the indexed packages and of those of
+// knownPackageIdents.
+func (x *package_index) names(ctxt *package_lookup_context, f func(name string)) {
+	x.start(ctxt)
+
+	x.mu.Lock()
+	names := make([]string, 0, len(x.pkgs))
+	for name := range x.pkgs {
+		names = append(names, name)
+	}
+	for name := range knownPackageIdents {
+		if _, ok := x.pkgs[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	x.mu.Unlock()
+	for _, name := range names {
+		f(name)
+	}
+}
+
+// walk adds the packages of directory dir, the import path of which is path,
+// and of its subdirectories. In the module cache the versions are stripped
+// from the paths, and only the highest version of a module is added.
+func (x *package_index) walk(dir, path string, rank int, seen map[string]bool) {
+	select {
+	case <-x.quit:
+		return
+	default:
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	if path != "" && !seen[path] {
+		if name := package_name_of_dir(dir, entries); name != "" {
+			seen[path] = true
+			x.mu.Lock()
+			x.pkgs[name] = append(x.pkgs[name], indexed_package{path, rank})
+			x.mu.Unlock()
+		}
+	}
+
+	// the highest version of each module of the module cache: a@v1.10.0
+	// and a@v1.9.0 => a@v1.10.0
+	var latest map[string]string
+	if rank == rank_mod_cache {
+		latest = make(map[string]string)
+		for _, e := range entries {
+			name := e.Name()
+			if i := strings.IndexByte(name, '@'); i >= 0 && e.IsDir() {
+				mod, v := name[:i], name[i+1:]
+				if cur, ok := latest[mod]; !ok || compare_versions(v, cur) > 0 {
+					latest[mod] = v
+				}
+			}
+		}
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() || !index_dir(name) {
+			continue
+		}
+		elem := name
+		switch rank {
+		case rank_goroot:
+			if path == "" && name == "cmd" {
+				continue
+			}
+		case rank_mod_cache:
+			if path == "" && name == "cache" {
+				continue
+			}
+			if i := strings.IndexByte(name, '@'); i >= 0 {
+				elem = name[:i]
+				if latest[elem] != name[i+1:] {
+					continue
+				}
+			} else if strings.Contains(dir, "@") && file_exists(filepath.Join(dir, name, "go.mod")) {
+				// nested module, not part of this one
+				continue
+			}
+			elem = unescape_module_path(elem)
+		}
+		sub := elem
+		if path != "" {
+			sub = path + "/" + elem
+		}
+		x.walk(filepath.Join(dir, name), sub, rank, seen)
+	}
+}
+
+// compare_versions compares the semantic versions a and b, like
+// "v1.2.3-pre+build", and returns -1, 0 or 1. The versions that are not
+// valid are lower than the valid ones.
+func compare_versions(a, b string) int {
+	pa, oka := parse_version(a)
+	pb, okb := parse_version(b)
+	if !oka || !okb {
+		return compare_ints(bool_int(oka), bool_int(okb))
+	}
+	for i := 0; i < 3; i++ {
+		if c := compare_numbers(pa[i], pb[i]); c != 0 {
+			return c
+		}
+	}
+	// a version without pre-release is higher than one with it
+	if pa[3] == "" || pb[3] == "" {
+		return compare_ints(bool_int(pa[3] == ""), bool_int(pb[3] == ""))
+	}
+	ida, idb := strings.Split(pa[3], "."), strings.Split(pb[3], ".")
+	for i := 0; i < len(ida) && i < len(idb); i++ {
+		na, nb := is_number(ida[i]), is_number(idb[i])
+		var c int
+		switch {
+		case na && nb:
+			c = compare_numbers(ida[i], idb[i])
+		case na || nb:
+			// numeric identifiers are lower than the others
+			c = compare_ints(bool_int(nb), bool_int(na))
+		default:
+			c = strings.Compare(ida[i], idb[i])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return compare_ints(len(ida), len(idb))
+}
+
+// parse_version returns the major, minor and patch numbers and the
+// pre-release of semantic version v, without its build metadata.
+func parse_version(v string) ([4]string, bool) {
+	var p [4]string
+	if !strings.HasPrefix(v, "v") {
+		return p, false
+	}
+	v = v[1:]
+	if i := strings.IndexByte(v, '+'); i >= 0 {
+		v = v[:i]
+	}
+	if i := strings.IndexByte(v, '-'); i >= 0 {
+		v, p[3] = v[:i], v[i+1:]
+		if p[3] == "" {
+			return p, false
+		}
+	}
+	nums := strings.Split(v, ".")
+	if len(nums) > 3 {
+		return p, false
+	}
+	for i := range p[:3] {
+		p[i] = "0"
+		if i < len(nums) {
+			if !is_number(nums[i]) {
+				return p, false
+			}
+			p[i] = nums[i]
+		}
+	}
+	return p, true
+}
+
+func is_number(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// compare_numbers compares the decimal numbers a and b of any length.
+func compare_numbers(a, b string) int {
+	a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		return compare_ints(len(a), len(b))
+	}
+	return strings.Compare(a, b)
+}
+
+func compare_ints(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func bool_int(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// index_dir reports whether the packages of a directory named name may be
+// imported from anywhere.
+func index_dir(name string) bool {
+	switch name {
+	case "testdata", "vendor", "internal":
+		return false
+	}
+	return name[0] != '.' && name[0] != '_'
+}
+
+// package_name_of_dir returns the name of the package in directory dir with
+// entries, which is empty for commands and directories without Go files.
+func package_name_of_dir(dir string, entries []os.DirEntry) string {
+	fset := token.NewFileSet()
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.PackageClauseOnly)
+		if err != nil {
+			continue
+		}
+		switch pkg := file.Name.Name; pkg {
+		case "main", "documentation":
+			// the other files may belong to a library
+		default:
+			return pkg
+		}
+	}
+	return ""
+}
+
+// unescape_module_path reverses escape_module_path: "!a" => 'A'.
+func unescape_module_path(s string) string {
+	if !strings.Contains(s, "!") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '!' && i+1 < len(s) {
+			i++
+			b.WriteByte(s[i] - ('a' - 'A'))
+		} else {
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+//-------------------------------------------------------------------------
+// import edits
+//-------------------------------------------------------------------------
+
+// TextEdit is an edit of a file, which replaces the span from Start to End
+// by Text.
+type TextEdit struct {
+	Start, End Position
+	Text       string
+}
+
+// import_edit returns the edit that adds the import of path to file data. The
+// import is added to the import declaration with parentheses, if any, in
+// order, or else after the last import or the package clause.
+func import_edit(data []byte, path string) (TextEdit, bool) {
+	fset := token.NewFileSet()
+	file, _ := parser.ParseFile(fset, "", data, parser.ImportsOnly)
+	if file == nil || file.Name == nil || file.Name.Name == "" {
+		return TextEdit{}, false
+	}
+	offset := func(p token.Pos) int {
+		return fset.Position(p).Offset
+	}
+	quoted := strconv.Quote(path)
+	insert := func(off int, text string) (TextEdit, bool) {
+		pos := new_position(data, off)
+		return TextEdit{Start: pos, End: pos, Text: text}, true
+	}
+
+	var last *ast.GenDecl
+	for _, d := range file.Decls {
+		gd, ok := d.(*ast.GenDecl)
+		if !ok || gd.Tok != token.IMPORT {
+			break
+		}
+		last = gd
+		if !gd.Lparen.IsValid() {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			s := spec.(*ast.ImportSpec)
+			if s.Path.Value > quoted {
+				return insert(offset(s.Pos()), quoted+"\n\t")
+			}
+		}
+		if n := len(gd.Specs); n != 0 {
+			return insert(offset(gd.Specs[n-1].End()), "\n\t"+quoted)
+		}
+		return insert(offset(gd.Lparen)+1, "\n\t"+quoted+"\n")
+	}
+	if last != nil {
+		return insert(offset(last.End()), "\nimport "+quoted)
+	}
+	return insert(offset(file.Name.End()), "\n\nimport "+quoted)
+}
+
+// get_unimported_candidates proposes the packages, the names of which match
+// partial, that are not in set, the declarations visible at the cursor.
+func (c *auto_complete_context) get_unimported_candidates(set map[string]*decl, partial string, b *out_buffers) {
+	ctxt := c.declcache.context
+	ctxt.index.names(ctxt, func(name string) {
+		if _, ok := set[name]; ok || name == c.current.package_name {
+			return
+		}
+		score := 0
+		if b.matcher == nil {
+			if !has_prefix(name, partial, b.ignorecase) {
+				return
+			}
+		} else {
+			var ok bool
+			if score, ok = b.matcher.Match(name, partial); !ok {
+				return
+			}
+		}
+		path := ctxt.index.lookup(name, ctxt)[0]
+		b.candidates = append(b.candidates, candidate{
+			Name:    name,
+			Class:   decl_package,
+			Package: path,
+			Score:   score,
+			Import:  path,
+		})
+	})
+}
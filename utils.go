@@ -86,19 +86,28 @@ func (c *DirCache) Readdirnames(path string) ([]string, error) {
 	return d.names, nil
 }
 
-var dir_cache = NewDirCache()
-
 func has_go_ext(s string) bool {
 	return len(s) >= len("*.go") && s[len(s)-len(".go"):] == ".go"
 }
 
-func readdirnames(name string) ([]string, error) {
-	return dir_cache.Readdirnames(name)
+// readdir_names returns the names in directory name, a nil cache reads the
+// directory every time.
+func (c *DirCache) readdir_names(name string) ([]string, error) {
+	if c == nil {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		names, err := f.Readdirnames(-1)
+		f.Close()
+		return names, err
+	}
+	return c.Readdirnames(name)
 }
 
 // our own readdir, which skips the files it cannot lstat
-func readdir_lstat(name string) ([]os.FileInfo, error) {
-	names, err := readdirnames(name)
+func (c *DirCache) readdir_lstat(name string) ([]os.FileInfo, error) {
+	names, err := c.readdir_names(name)
 	if err != nil {
 		return nil, err
 	}
@@ -113,8 +122,8 @@ func readdir_lstat(name string) ([]os.FileInfo, error) {
 	return out, nil
 }
 
-func readdir_gofiles_lstat(name string) ([]os.FileInfo, error) {
-	names, err := readdirnames(name)
+func (c *DirCache) readdir_gofiles_lstat(name string) ([]os.FileInfo, error) {
+	names, err := c.readdir_names(name)
 	if err != nil {
 		return nil, err
 	}
@@ -242,8 +251,10 @@ type file_reader_type struct {
 	gate chan struct{}
 }
 
-var file_reader = &file_reader_type{
-	gate: make(chan struct{}, 100),
+func new_file_reader() *file_reader_type {
+	return &file_reader_type{
+		gate: make(chan struct{}, 100),
+	}
 }
 
 func (r *file_reader_type) read_file(filename string) ([]byte, error) {